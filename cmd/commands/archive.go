@@ -0,0 +1,322 @@
+package commands
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ExportFormats are the layouts export can write, dir writes plain
+// directories and files and the others a single archive
+var ExportFormats = []string{"dir", "tar", "tar.gz", "zip"}
+
+// archiveWriter receives the directories and files of an export, names are
+// slash separated and relative to the export root
+type archiveWriter interface {
+	dir(name string, modTime time.Time) error
+	file(name string, content []byte, modTime time.Time) error
+	Close() error
+}
+
+// newArchiveWriter creates the export at target in format
+func newArchiveWriter(target string, format string) (archiveWriter, error) {
+	if format == "dir" {
+		if err := os.Mkdir(target, 0o755); err != nil {
+			return nil, err
+		}
+		return &dirWriter{root: target}, nil
+	}
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "tar":
+		return &tarWriter{out: out, tw: tar.NewWriter(out)}, nil
+	case "tar.gz":
+		gz := gzip.NewWriter(out)
+		return &tarWriter{out: out, gz: gz, tw: tar.NewWriter(gz)}, nil
+	default:
+		return &zipWriter{out: out, zw: zip.NewWriter(out)}, nil
+	}
+}
+
+type dirWriter struct {
+	root string
+	// dirs holds the directories whose times are set once their files are in
+	dirs map[string]time.Time
+}
+
+func (w *dirWriter) dir(name string, modTime time.Time) error {
+	target := filepath.Join(w.root, filepath.FromSlash(name))
+	if err := os.Mkdir(target, 0o755); err != nil {
+		return err
+	}
+	if w.dirs == nil {
+		w.dirs = make(map[string]time.Time)
+	}
+	w.dirs[target] = modTime
+	return nil
+}
+
+func (w *dirWriter) file(name string, content []byte, modTime time.Time) error {
+	target := filepath.Join(w.root, filepath.FromSlash(name))
+	if err := os.WriteFile(target, content, 0o644); err != nil {
+		return err
+	}
+	return os.Chtimes(target, modTime, modTime)
+}
+
+// Close sets the times of the directories, writing into one changes them
+func (w *dirWriter) Close() error {
+	for target, modTime := range w.dirs {
+		if err := os.Chtimes(target, modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type tarWriter struct {
+	out *os.File
+	gz  *gzip.Writer
+	tw  *tar.Writer
+}
+
+func (w *tarWriter) dir(name string, modTime time.Time) error {
+	return w.tw.WriteHeader(&tar.Header{Typeflag: tar.TypeDir, Name: name + "/", Mode: 0o755, ModTime: modTime})
+}
+
+func (w *tarWriter) file(name string, content []byte, modTime time.Time) error {
+	header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0o644, Size: int64(len(content)), ModTime: modTime}
+	if err := w.tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := w.tw.Write(content)
+	return err
+}
+
+func (w *tarWriter) Close() error {
+	err := w.tw.Close()
+	if w.gz != nil {
+		if gzErr := w.gz.Close(); err == nil {
+			err = gzErr
+		}
+	}
+	if closeErr := w.out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+type zipWriter struct {
+	out *os.File
+	zw  *zip.Writer
+}
+
+func (w *zipWriter) dir(name string, modTime time.Time) error {
+	_, err := w.zw.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: modTime})
+	return err
+}
+
+func (w *zipWriter) file(name string, content []byte, modTime time.Time) error {
+	writer, err := w.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = writer.Write(content)
+	return err
+}
+
+func (w *zipWriter) Close() error {
+	err := w.zw.Close()
+	if closeErr := w.out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// archiveFormat is the format of an archive judged by its extension, or ""
+// for anything else
+func archiveFormat(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	}
+	return ""
+}
+
+// trimArchiveExt strips the archive extension from the base name of an archive
+func trimArchiveExt(name string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tgz", ".tar", ".zip"} {
+		if strings.HasSuffix(lower, ext) {
+			return name[:len(name)-len(ext)]
+		}
+	}
+	return name
+}
+
+// extractArchive unpacks the archive into a new temporary directory the
+// caller removes, keeping the modification times of its entries
+func extractArchive(archive string, format string) (string, error) {
+	dir, err := os.MkdirTemp("", "vfs-import-*")
+	if err != nil {
+		return "", err
+	}
+
+	if format == "zip" {
+		err = extractZip(archive, dir)
+	} else {
+		err = extractTar(archive, dir, format == "tar.gz")
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", fmt.Errorf("the %s can't be extracted: %v", archive, err)
+	}
+	return dir, nil
+}
+
+// archivePath resolves an entry name inside dir, refusing names escaping it
+func archivePath(dir string, name string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("the entry %s points outside the archive", name)
+	}
+	return filepath.Join(dir, filepath.FromSlash(clean)), nil
+}
+
+// extracted holds the times to set once everything is written, since writing
+// into a directory changes its time
+type extracted map[string]time.Time
+
+func (e extracted) write(target string, content io.Reader, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, content); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	e[target] = modTime
+	return nil
+}
+
+func (e extracted) mkdir(target string, modTime time.Time) error {
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return err
+	}
+	e[target] = modTime
+	return nil
+}
+
+func (e extracted) setTimes() error {
+	for target, modTime := range e {
+		if err := os.Chtimes(target, modTime, modTime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTar(archive string, dir string, gzipped bool) error {
+	in, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var reader io.Reader = in
+	if gzipped {
+		gz, err := gzip.NewReader(in)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	entries := make(extracted)
+	tr := tar.NewReader(reader)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		target, err := archivePath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			err = entries.mkdir(target, header.ModTime)
+		case tar.TypeReg:
+			err = entries.write(target, tr, header.ModTime)
+		}
+		// Links and devices are left out like they are when importing a directory
+		if err != nil {
+			return err
+		}
+	}
+	return entries.setTimes()
+}
+
+func extractZip(archive string, dir string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	entries := make(extracted)
+	for _, entry := range zr.File {
+		target, err := archivePath(dir, entry.Name)
+		if err != nil {
+			return err
+		}
+		if entry.FileInfo().IsDir() {
+			if err := entries.mkdir(target, entry.Modified); err != nil {
+				return err
+			}
+			continue
+		}
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+
+		content, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		err = entries.write(target, content, entry.Modified)
+		content.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return entries.setTimes()
+}
@@ -0,0 +1,240 @@
+package commands
+
+import (
+	"fmt"
+	"os"
+	"repl-cli-iscoollab/internal/audit"
+	"repl-cli-iscoollab/internal/user"
+	"slices"
+	"strings"
+)
+
+var (
+	// AuditLog receives every mutating command, nil disables auditing
+	AuditLog *audit.Log
+
+	// Actor is who the session acts as, recorded in the audit log
+	Actor = defaultActor()
+
+	// Admins are the actors allowed to act across users, set from the
+	// admins setting
+	Admins = make(map[string]bool)
+
+	// MutatingCommands are the commands recorded in the audit log
+	MutatingCommands = map[string]bool{
+		"register":          true,
+		"create-folder":     true,
+		"delete-folder":     true,
+		"rename-folder":     true,
+		"create-file":       true,
+		"delete-file":       true,
+		"write-file":        true,
+		"edit":              true,
+		"revert-file":       true,
+		"set-retention":     true,
+		"set-description":   true,
+		"clear-description": true,
+		"set-attr":          true,
+		"unset-attr":        true,
+		"tag":               true,
+		"untag":             true,
+		"add-webhook":       true,
+		"remove-webhook":    true,
+		"redeliver-webhook": true,
+		"snapshot create":   true,
+		"snapshot restore":  true,
+		"snapshot delete":   true,
+		"import-dir":        true,
+		"sync":              true,
+		"unsync":            true,
+		"copy-file":         true,
+		"copy-folder":       true,
+		"gc":                true,
+	}
+
+	// userArgument is the position of the username in the arguments of
+	// commands not naming the user they act on first, -1 for commands
+	// acting on no user
+	userArgument = map[string]int{
+		"import-dir":        1,
+		"add-webhook":       2,
+		"remove-webhook":    -1,
+		"redeliver-webhook": -1,
+	}
+
+	// secretArguments is the position of the argument never written to the
+	// audit log or the history file
+	secretArguments = map[string]int{
+		"add-webhook": 1,
+	}
+)
+
+// redacted replaces secret arguments
+const redacted = "[redacted]"
+
+// Redact returns the arguments of command with its secret argument
+// replaced, a --output flag and its value don't count as positions
+func Redact(command string, args []string) []string {
+	i, exists := secretArguments[command]
+	if !exists {
+		return args
+	}
+
+	args = slices.Clone(args)
+	position := 0
+	for j := 0; j < len(args); j++ {
+		if args[j] == "--output" {
+			j++
+			continue
+		}
+		if position == i {
+			args[j] = redacted
+			break
+		}
+		position++
+	}
+	return args
+}
+
+// HistoryLine is the line the history file keeps for a command line, the
+// line as typed unless it holds a secret
+func HistoryLine(line string, args []string) string {
+	if len(args) == 0 {
+		return line
+	}
+	if _, exists := secretArguments[args[0]]; !exists {
+		return line
+	}
+
+	// ParseInput keeps the quotes of arguments holding spaces, so the
+	// joined line parses back to the same arguments
+	return strings.Join(append([]string{args[0]}, Redact(args[0], args[1:])...), " ")
+}
+
+func defaultActor() string {
+	if actor := os.Getenv("USER"); actor != "" {
+		return actor
+	}
+	return "unknown"
+}
+
+// switchActor makes name the session actor. The switch is recorded under
+// the actor switching, so every entry stays attributable to whoever ran it.
+// Once admins are configured only an admin may switch, otherwise anyone
+// could become one and use what admins can
+func switchActor(name string) error {
+	if len(Admins) > 0 {
+		if err := requireAdmin("set actor"); err != nil {
+			return err
+		}
+	}
+	if AuditLog != nil {
+		entry := audit.Entry{
+			Time:    user.Now(),
+			Actor:   Actor,
+			Command: "set actor",
+			Args:    []string{name},
+			User:    audit.NoUser,
+			Outcome: audit.Success,
+		}
+		if err := AuditLog.Append(entry); err != nil {
+			return fmt.Errorf("the actor can't be changed, the audit log can't be written: %v", err)
+		}
+	}
+	Actor = name
+	return nil
+}
+
+// requireAdmin fails unless the session acts as an admin, option names
+// what needs one in the error
+func requireAdmin(option string) error {
+	if !Admins[Actor] {
+		return user.Errorf(user.PermissionDenied, "the %s is only available to admins, %s is not one of the admins in the config", option, Actor)
+	}
+	return nil
+}
+
+// Record appends the outcome of a mutating command to the audit log,
+// other commands are ignored
+func Record(command string, args []string, err error) error {
+	// snapshot names its action before the user, record it as part of the command
+	if command == "snapshot" && len(args) > 0 {
+		command, args = command+" "+strings.ToLower(args[0]), args[1:]
+	}
+	if AuditLog == nil || !MutatingCommands[command] {
+		return nil
+	}
+
+	entry := audit.Entry{
+		Time:    user.Now(),
+		Actor:   Actor,
+		Command: command,
+		Args:    Redact(command, args),
+		Outcome: audit.Success,
+	}
+	if i, exists := userArgument[command]; exists {
+		entry.User = audit.NoUser
+		if i >= 0 && i < len(args) {
+			entry.User = args[i]
+		}
+	}
+	if err != nil {
+		entry.Outcome = audit.Failure
+		entry.Code = user.ErrorCode(err)
+		entry.Error = err.Error()
+	}
+	return AuditLog.Append(entry)
+}
+
+// Audit lists the recorded commands, oldest first, filtered by actor,
+// the user acted on, command and time range
+func Audit(args []string) (string, error) {
+	var filter audit.Filter
+	for _, name := range []string{"--actor", "--user", "--command", "--since", "--until"} {
+		var value string
+		var found bool
+		args, value, found = extractFlag(args, name)
+		if !found {
+			continue
+		}
+		if value == "" {
+			return "", user.Usage("audit")
+		}
+
+		var err error
+		switch name {
+		case "--actor":
+			filter.Actor = value
+		case "--user":
+			filter.User = value
+		case "--command":
+			filter.Command = strings.ToLower(unquote(value))
+		case "--since":
+			filter.Since, err = parseTime(value)
+		case "--until":
+			filter.Until, err = parseTime(value)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(args) != 0 {
+		return "", user.Usage("audit")
+	}
+
+	if AuditLog == nil {
+		return "", user.Errorf(user.UsageError, "the audit log is disabled, set audit_file in the config")
+	}
+
+	entries, err := AuditLog.Query(filter)
+	if err != nil {
+		return "", err
+	}
+
+	rows := make([][]string, 0, len(entries))
+	for _, entry := range entries {
+		rows = append(rows, []string{formatTime(entry.Time), entry.Actor, entry.Command, strings.Join(entry.Args, " "), entry.Outcome, entry.Code})
+	}
+
+	return renderTable([]string{"time", "actor", "command", "args", "outcome", "code"}, rows), nil
+}
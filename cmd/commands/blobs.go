@@ -0,0 +1,88 @@
+package commands
+
+import (
+	"fmt"
+	"repl-cli-iscoollab/internal/user"
+)
+
+// CopyFile copies a file into another folder, of the same user or of the one
+// given with --to-user, sharing its stored content
+func CopyFile(args []string) (string, error) {
+	args, toUser, found := extractFlag(args, "--to-user")
+	if len(args) < 4 || len(args) > 5 || (found && toUser == "") {
+		return "", user.Usage("copy-file")
+	}
+
+	src, err := getFile(args[0], args[1], args[2])
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		toUser = args[0]
+	}
+	dst, err := user.GetUser(toUser)
+	if err != nil {
+		return "", err
+	}
+	folder, err := dst.GetFolder(args[3])
+	if err != nil {
+		return "", err
+	}
+
+	fileName := src.Name
+	if len(args) == 5 {
+		fileName = args[4]
+	}
+	if err := folder.CopyFile(src, fileName, Actor); err != nil {
+		return "", err
+	}
+
+	return renderMessage(fmt.Sprintf("Copy %s/%s/%s to %s/%s/%s successfully", args[0], args[1], args[2], dst.Username, folder.Name, fileName)), nil
+}
+
+// CopyFolder copies a folder with its files under a new name, or to the user
+// given with --to-user, sharing the stored contents
+func CopyFolder(args []string) (string, error) {
+	args, toUser, found := extractFlag(args, "--to-user")
+	if len(args) < 2 || len(args) > 3 || (found && toUser == "") {
+		return "", user.Usage("copy-folder")
+	}
+
+	u, err := user.GetUser(args[0])
+	if err != nil {
+		return "", err
+	}
+	src, err := u.GetFolder(args[1])
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		toUser = args[0]
+	}
+	dst, err := user.GetUser(toUser)
+	if err != nil {
+		return "", err
+	}
+
+	folderName := src.Name
+	if len(args) == 3 {
+		folderName = args[2]
+	}
+	if err := dst.CopyFolder(src, folderName, Actor); err != nil {
+		return "", err
+	}
+
+	return renderMessage(fmt.Sprintf("Copy %s/%s with %s to %s/%s successfully", u.Username, src.Name, plural(len(src.Files), "file"), dst.Username, folderName)), nil
+}
+
+// GC drops the stored contents no revision refers to any more
+func GC(args []string) (string, error) {
+	if len(args) != 0 {
+		return "", user.Usage("gc")
+	}
+
+	count, bytes := user.GC()
+	stats := user.Blobs.Stats()
+	return renderMessage(fmt.Sprintf("Reclaim %s of %s successfully, %s of %s in use",
+		plural(count, "blob"), plural(bytes, "byte"), plural(stats.Blobs, "blob"), plural(stats.Bytes, "byte"))), nil
+}
@@ -3,33 +3,32 @@ package commands
 import (
 	"flag"
 	"fmt"
-	"os"
 	"repl-cli-iscoollab/internal/user"
+	"strconv"
 	"strings"
 )
 
 func Register(args []string) (string, error) {
 	if len(args) != 1 {
-		return "", fmt.Errorf(user.CommandsUsage["register"])
+		return "", user.Usage("register")
 	}
 
-	username := strings.ToLower(args[0])
+	username := args[0]
 	err := user.RegisterUser(username)
 	if err != nil {
 		return "", err
 	}
 
-	output := fmt.Sprintf("Add %s successfully\n", username)
-	return output, nil
+	return renderMessage(fmt.Sprintf("Add %s successfully", username)), nil
 }
 
 func CreateFolder(args []string) (string, error) {
 	if len(args) != 2 && len(args) != 3 {
-		return "", fmt.Errorf(user.CommandsUsage["create-folder"])
+		return "", user.Usage("create-folder")
 	}
 
-	username := strings.ToLower(args[0])
-	folderName := strings.ToLower(args[1])
+	username := args[0]
+	folderName := args[1]
 	var description string
 	if len(args) > 2 {
 		description = args[2]
@@ -46,17 +45,16 @@ func CreateFolder(args []string) (string, error) {
 		return "", err
 	}
 
-	output := fmt.Sprintf("Create %s successfully\n", folderName)
-	return output, nil
+	return renderMessage(fmt.Sprintf("Create %s successfully", folderName)), nil
 }
 
 func DeleteFolder(args []string) (string, error) {
 	if len(args) != 2 {
-		return "", fmt.Errorf(user.CommandsUsage["delete-folder"])
+		return "", user.Usage("delete-folder")
 	}
 
-	username := strings.ToLower(args[0])
-	folderName := strings.ToLower(args[1])
+	username := args[0]
+	folderName := args[1]
 
 	user, err := user.GetUser(username)
 	if err != nil {
@@ -68,31 +66,22 @@ func DeleteFolder(args []string) (string, error) {
 		return "", err
 	}
 
-	output := fmt.Sprintf("Delete %s successfully\n", folderName)
-	return output, nil
+	return renderMessage(fmt.Sprintf("Delete %s successfully", folderName)), nil
 }
 
 func ListFolders(args []string) (string, error) {
-	if len(args) < 1 || len(args) > 3 {
-		return "", fmt.Errorf(user.CommandsUsage["list-folders"])
+	args, opts, paged, err := listOptions(args, "list-folders")
+	if err != nil {
+		return "", err
 	}
-
-	username := strings.ToLower(args[0])
-	sortBy := "--sort-name"
-	sortOrder := "asc"
-	if len(args) > 1 {
-		if args[1] != "--sort-name" && args[1] != "--sort-created" {
-			return "", fmt.Errorf(user.CommandsUsage["list-folders"])
-		}
-
-		sortBy = args[1]
+	if len(args) < 1 || len(args) > 3 {
+		return "", user.Usage("list-folders")
 	}
-	if len(args) > 2 {
-		if args[2] != "asc" && args[2] != "desc" {
-			return "", fmt.Errorf(user.CommandsUsage["list-folders"])
-		}
 
-		sortOrder = args[2]
+	username := args[0]
+	opts.Sort, err = sortKeys(opts.Sort, args[1:], "list-folders")
+	if err != nil {
+		return "", err
 	}
 
 	user, err := user.GetUser(username)
@@ -100,31 +89,31 @@ func ListFolders(args []string) (string, error) {
 		return "", err
 	}
 
-	folders, err := user.ListFolders(sortBy, sortOrder)
+	page, err := user.ListFoldersPage(opts)
 	if err != nil {
 		return "", err
 	}
 
-	var output strings.Builder
-	for _, folder := range folders {
-		var description string
-		if folder.Description != "" {
-			description = " " + folder.Description
-		}
-		output.WriteString(fmt.Sprintf("%s%s %s %s\n", folder.Name, description, folder.CreatedAt, user.Username))
+	rows := make([][]string, 0, len(page.Items))
+	for _, folder := range page.Items {
+		rows = append(rows, []string{folder.Name, folder.Description, formatTime(folder.CreatedAt), user.Username, strings.Join(folder.Tags, ","), formatAttrs(folder.ListAttrs())})
 	}
 
-	return output.String(), nil
+	headers := []string{"name", "description", "created_at", "username", "tags", "attributes"}
+	if paged {
+		return renderPage(headers, rows, page.Total, page.Start, page.NextCursor), nil
+	}
+	return renderTable(headers, rows), nil
 }
 
 func RenameFolder(args []string) (string, error) {
 	if len(args) != 3 {
-		return "", fmt.Errorf(user.CommandsUsage["rename-folder"])
+		return "", user.Usage("rename-folder")
 	}
 
-	username := strings.ToLower(args[0])
-	folderName := strings.ToLower(args[1])
-	newFolderName := strings.ToLower(args[2])
+	username := args[0]
+	folderName := args[1]
+	newFolderName := args[2]
 
 	user, err := user.GetUser(username)
 	if err != nil {
@@ -136,18 +125,17 @@ func RenameFolder(args []string) (string, error) {
 		return "", err
 	}
 
-	output := fmt.Sprintf("Rename %s to %s successfully\n", folderName, newFolderName)
-	return output, nil
+	return renderMessage(fmt.Sprintf("Rename %s to %s successfully", folderName, newFolderName)), nil
 }
 
 func CreateFile(args []string) (string, error) {
 	if len(args) != 3 && len(args) != 4 {
-		return "", fmt.Errorf(user.CommandsUsage["create-file"])
+		return "", user.Usage("create-file")
 	}
 
-	username := strings.ToLower(args[0])
-	folderName := strings.ToLower(args[1])
-	fileName := strings.ToLower(args[2])
+	username := args[0]
+	folderName := args[1]
+	fileName := args[2]
 	var description string
 	if len(args) > 3 {
 		description = args[3]
@@ -168,32 +156,24 @@ func CreateFile(args []string) (string, error) {
 		return "", err
 	}
 
-	output := fmt.Sprintf("Create %s in %s/%s successfully\n", fileName, username, folderName)
-	return output, nil
+	return renderMessage(fmt.Sprintf("Create %s in %s/%s successfully", fileName, username, folderName)), nil
 }
 
 func ListFiles(args []string) (string, error) {
-	if len(args) < 2 || len(args) > 4 {
-		return "", fmt.Errorf(user.CommandsUsage["list-files"])
+	args, snapshotName, fromSnapshot := extractFlag(args, "--snapshot")
+	args, opts, paged, err := listOptions(args, "list-files")
+	if err != nil {
+		return "", err
 	}
-
-	username := strings.ToLower(args[0])
-	folderName := strings.ToLower(args[1])
-	sortBy := "--sort-name"
-	sortOrder := "asc"
-	if len(args) > 2 {
-		if args[2] != "--sort-name" && args[2] != "--sort-created" {
-			return "", fmt.Errorf(user.CommandsUsage["list-files"])
-		}
-
-		sortBy = args[2]
+	if len(args) < 2 || len(args) > 4 || (fromSnapshot && snapshotName == "") {
+		return "", user.Usage("list-files")
 	}
-	if len(args) > 3 {
-		if args[3] != "asc" && args[3] != "desc" {
-			return "", fmt.Errorf(user.CommandsUsage["list-files"])
-		}
 
-		sortOrder = args[3]
+	username := args[0]
+	folderName := args[1]
+	opts.Sort, err = sortKeys(opts.Sort, args[2:], "list-files")
+	if err != nil {
+		return "", err
 	}
 
 	user, err := user.GetUser(username)
@@ -201,36 +181,117 @@ func ListFiles(args []string) (string, error) {
 		return "", err
 	}
 
-	folder, err := user.GetFolder(folderName)
+	page, err := listFilesPage(user, folderName, snapshotName, fromSnapshot, opts)
 	if err != nil {
 		return "", err
 	}
 
-	files, err := folder.ListFiles(sortBy, sortOrder)
+	rows := make([][]string, 0, len(page.Items))
+	for _, file := range page.Items {
+		rows = append(rows, []string{file.Name, file.Description, formatTime(file.CreatedAt), user.Username, strings.Join(file.Tags, ","), formatAttrs(file.ListAttrs())})
+	}
+
+	headers := []string{"name", "description", "created_at", "username", "tags", "attributes"}
+	if paged {
+		return renderPage(headers, rows, page.Total, page.Start, page.NextCursor), nil
+	}
+	return renderTable(headers, rows), nil
+}
+
+// listOptions pulls the filter and paging flags of list-folders and list-files
+// out of the arguments, paged reports whether a total count header is wanted
+func listOptions(args []string, usage string) ([]string, user.ListOptions, bool, error) {
+	var opts user.ListOptions
+	var paged bool
+
+	flags := []string{"--sort", "--tag", "--name-prefix", "--created-after", "--created-before", "--limit", "--offset", "--cursor"}
+	for _, name := range flags {
+		var value string
+		var found bool
+		args, value, found = extractFlag(args, name)
+		if !found {
+			continue
+		}
+		if value == "" {
+			return nil, opts, false, user.Usage(usage)
+		}
+
+		var err error
+		switch name {
+		case "--sort":
+			opts.Sort, err = parseSort(value, usage)
+		case "--tag":
+			opts.Tags, err = user.ParseTagQuery(value)
+		case "--name-prefix":
+			opts.NamePrefix = value
+		case "--created-after":
+			opts.CreatedAfter, err = parseTime(value)
+		case "--created-before":
+			opts.CreatedBefore, err = parseTime(value)
+		case "--limit", "--offset":
+			n, convErr := strconv.Atoi(value)
+			if convErr != nil || n < 0 || (name == "--limit" && n == 0) {
+				return nil, opts, false, user.Errorf(user.UsageError, "the %s is not a valid %s", value, strings.TrimPrefix(name, "--"))
+			}
+			if name == "--limit" {
+				opts.Limit = n
+			} else {
+				opts.Offset = n
+			}
+		case "--cursor":
+			opts.Cursor = value
+		}
+		if err != nil {
+			return nil, opts, false, err
+		}
+
+		if name != "--sort" && name != "--tag" {
+			paged = true
+		}
+	}
+
+	return args, opts, paged, nil
+}
+
+// parseSort reads a --sort value, a bad key is reported with the usage
+func parseSort(value string, usage string) ([]user.SortKey, error) {
+	keys, err := user.ParseSortKeys(unquote(value))
 	if err != nil {
-		return "", err
+		return nil, user.Errorf(user.UsageError, "%v\n%s", err, user.CommandsUsage[usage])
 	}
+	return keys, nil
+}
 
-	var output strings.Builder
-	for _, file := range files {
-		var description string
-		if file.Description != "" {
-			description = " " + file.Description
+// sortKeys resolves the order of a listing from --sort or the positional
+// --sort-x [asc|desc] arguments, which can't be combined, or DefaultSort
+func sortKeys(keys []user.SortKey, positional []string, usage string) ([]user.SortKey, error) {
+	if keys != nil {
+		if len(positional) > 0 {
+			return nil, user.Usage(usage)
 		}
-		output.WriteString(fmt.Sprintf("%s%s %s %s\n", file.Name, description, file.CreatedAt, user.Username))
+		return keys, nil
+	}
+
+	if len(positional) == 0 {
+		return DefaultSort, nil
 	}
 
-	return output.String(), nil
+	sortBy := positional[0]
+	sortOrder := "asc"
+	if len(positional) > 1 {
+		sortOrder = positional[1]
+	}
+	return user.LegacySortKeys(sortBy, sortOrder, usage)
 }
 
 func DeleteFile(args []string) (string, error) {
 	if len(args) != 3 {
-		return "", fmt.Errorf(user.CommandsUsage["delete-file"])
+		return "", user.Usage("delete-file")
 	}
 
-	username := strings.ToLower(args[0])
-	folderName := strings.ToLower(args[1])
-	fileName := strings.ToLower(args[2])
+	username := args[0]
+	folderName := args[1]
+	fileName := args[2]
 
 	user, err := user.GetUser(username)
 	if err != nil {
@@ -247,30 +308,85 @@ func DeleteFile(args []string) (string, error) {
 		return "", err
 	}
 
-	output := fmt.Sprintf("Deleted file %s from %s/%s successfully\n", fileName, username, folderName)
-	return output, nil
+	return renderMessage(fmt.Sprintf("Deleted file %s from %s/%s successfully", fileName, username, folderName)), nil
 }
 
 func Help() string {
 	output := `Available commands:
-  register [username]                                                         - Register a new user
-  create-folder [username] [foldername] [description]?                        - Create a new folder
-  list-folders [username] [--sort-name|--sort-created] [asc|desc]             - List folders for a user
-  delete-folder [username] [foldername]                                       - Delete a folder
-  rename-folder [username] [foldername] [new-folder-name]                     - Rename a folder
-  create-file [username] [foldername] [filename] [description]?               - Create a new file
-  list-files [username] [foldername] [--sort-name|--sort-created] [asc|desc]  - List files in a folder
-  delete-file [username] [foldername] [filename]                              - Delete a file
-  help                                                                        - Show this help message
-  exit                                                                        - Exit the program
+  register [username]                                                                                    - Register a new user
+  create-folder [username] [foldername] [description]?                                                   - Create a new folder
+  list-folders [username] [--sort-name|--sort-created|--sort-modified] [asc|desc] [filters]?             - List folders for a user
+  delete-folder [username] [foldername]                                                                  - Delete a folder
+  rename-folder [username] [foldername] [new-folder-name]                                                - Rename a folder
+  create-file [username] [foldername] [filename] [description]?                                          - Create a new file
+  list-files [username] [foldername] [--sort-name|--sort-created|--sort-modified] [asc|desc] [filters]?  - List files in a folder
+  delete-file [username] [foldername] [filename]                                                         - Delete a file
+  set output [plain|json|csv|table]                                                                      - Set the output format for the session
+  set time-format [layout]                                                                               - Set how timestamps are displayed
+  set timezone [zone]                                                                                    - Set the time zone timestamps are displayed in
+  set actor [name]                                                                                       - Set who the session acts as in the audit log
+  tree [username] [foldername]? [--depth n]? [--description]? [--size]? [--time]? [--ascii]?             - Show folders and files as a tree
+  search [username|--all-users] [query]                                                                  - Search names, descriptions and contents
+  reindex                                                                                                - Rebuild the search index
+  set-description [username] [foldername] [filename]? [description]                                      - Set the description of a folder or file
+  clear-description [username] [foldername] [filename]?                                                  - Clear the description of a folder or file
+  set-attr [username] [foldername] [filename]? [key] [value]                                             - Set an attribute on a folder or file
+  get-attr [username] [foldername] [filename]? [key]                                                     - Show an attribute of a folder or file
+  list-attrs [username] [foldername] [filename]?                                                         - List the attributes of a folder or file
+  unset-attr [username] [foldername] [filename]? [key]                                                   - Remove an attribute from a folder or file
+  tag [username] [foldername] [filename]? [tag]                                                          - Tag a folder or file
+  untag [username] [foldername] [filename]? [tag]                                                        - Remove a tag from a folder or file
+  list-tags [username] [foldername]? [filename]?                                                         - List the tags of a user, folder or file
+  write-file [username] [foldername] [filename] [content]                                                - Replace the content of a file
+  cat [username] [foldername] [filename] [--rev n]? [--snapshot name]?                                   - Print the content of a file, one of its revisions or its content in a snapshot
+  edit [username] [foldername] [filename]                                                                - Edit the content of a file in $EDITOR, or a line editor when it isn't set
+  file-history [username] [foldername] [filename]                                                        - List the revisions of a file
+  diff-file [username] [foldername] [filename] [rev1] [rev2]?                                            - Show a unified diff between revisions, the latest by default
+  revert-file [username] [foldername] [filename] [rev]                                                   - Write an earlier revision back as a new revision
+  set-retention [username] [foldername] [filename] [count]                                               - Set how many revisions of a file are kept, 0 for the default
+  stat [username][/foldername]?[/filename]?                                                              - Show every field of a user, folder or file, including its content hash
+  copy-file [username] [foldername] [filename] [to-foldername] [to-filename]? [--to-user username]?      - Copy a file, sharing its content
+  copy-folder [username] [foldername] [to-foldername]? [--to-user username]?                             - Copy a folder with its files, sharing their contents
+  gc                                                                                                     - Reclaim the contents no file refers to any more
+  snapshot [create|list|restore|delete] [username] [name]?                                               - Freeze, list, restore or delete point-in-time copies of a user's folders
+  import-dir [host-path] [username] [foldername]? [--dry-run]? [--sanitize replace|skip]? [--hidden]?    - Import a host directory, archive or export as folders and files
+  sync [username]/[foldername] [host-dir] [--conflict skip|vfs|host|newer]? [--watch]? [--interval d]?   - Sync a folder with a host directory both ways, once or every interval
+  unsync [username]/[foldername]                                                                         - Stop syncing a folder
+  export [username] [host-path] [--format dir|tar|tar.gz|zip]?                                           - Export the folders and files of a user to the host
+  find [username|--all-users] [--sort-name|--sort-created|--sort-modified] [asc|desc] [filters]?         - Find files across folders
+  audit [filters]?                                                                                       - Show the audit log of mutating commands
+  watch [username]? [foldername]?                                                                        - Print changes to a user or folder as they happen
+  unwatch                                                                                                - Stop printing changes
+  add-webhook [url] [secret] [username]? [foldername]?                                                   - Post changes to a URL as signed JSON
+  list-webhooks                                                                                          - List webhooks with their delivery counts
+  remove-webhook [webhook-id]                                                                            - Remove a webhook
+  webhook-deliveries [webhook-id]? [--dead]?                                                             - Show the status of webhook deliveries or only the dead letters
+  redeliver-webhook [delivery-id]                                                                        - Send a dead letter again
+  config show                                                                                            - Show the settings in effect and where they came from
+  help                                                                                                   - Show this help message
+  exit                                                                                                   - Exit the program
 
 Note: Parameters in square brackets [] are required, those with ? are optional.
-For sorting, you can use either --sort-name or --sort-created, followed by asc (ascending) or desc (descending).
+For sorting, you can use --sort-name, --sort-created or --sort-modified, followed by asc (ascending) or desc (descending).
+--sort takes a comma list of name, created, modified, size and description, each with an optional :asc or :desc
+(e.g. --sort size:desc,name). Names compare numbers by value, so file2 comes before file10, and ties keep creation order.
+Time formats accept datetime, date, rfc3339, rfc3339nano or a Go layout, time zones accept Local, UTC or an IANA name.
+List filters: --sort keys, --tag tags, --name-prefix prefix, --created-after time, --created-before time,
+--limit n, --offset n and --cursor token. Paging or filtering by name or time prints a total count header.
+Tag filters match every tag joined by + and any group joined by , (e.g. --tag draft+client-x,final).
+Find filters: --name glob, --regex pattern, --description text, --created-after time, --created-before time,
+--min-size bytes, --max-size bytes, --tag tags and --attr key[=value].
+Search queries AND words by default, support OR, NOT, parentheses, "quoted phrases" and prefix* terms.
+list-files and cat accept --snapshot name to read a snapshot, commands changing data refuse it.
+Any command accepts --output [plain|json|csv|table] to override the session output format once.
+Audit filters: --actor name, --user username, --command name, --since time and --until time.
+Settings are read from --config path or $XDG_CONFIG_HOME/repl-cli-iscoollab/config.json, VFS_* variables override them.
 `
 	return output
 }
 
+// Exit clears the screen before the REPL ends, the REPL itself returns so the
+// process exits with the status of the last command
 func Exit() {
 	fmt.Print("\033[H\033[2J")
-	os.Exit(0)
 }
@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"repl-cli-iscoollab/internal/audit"
+	"repl-cli-iscoollab/internal/config"
+	"repl-cli-iscoollab/internal/user"
+	"repl-cli-iscoollab/internal/utils"
+	"strings"
+)
+
+// DefaultSort orders listings and find results when no sort is given
+var DefaultSort = []user.SortKey{{Field: "name"}}
+
+// ApplyConfig puts the limits, formats and defaults of cfg into effect
+// and makes it the configuration shown by config show. Every setting is
+// checked before any is applied, so an invalid one changes nothing
+func ApplyConfig(cfg config.Config) error {
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{
+		{"max_username_length", cfg.MaxUsernameLength},
+		{"max_folder_name_length", cfg.MaxFolderNameLength},
+		{"max_file_name_length", cfg.MaxFileNameLength},
+		{"audit_max_size", cfg.AuditMaxSize},
+		{"revision_retention", cfg.RevisionRetention},
+	} {
+		if limit.value <= 0 {
+			return user.Errorf(user.UsageError, "the %d is not a valid %s", limit.value, limit.name)
+		}
+	}
+
+	if cfg.AuditKeep < 0 {
+		return user.Errorf(user.UsageError, "the %d is not a valid audit_keep", cfg.AuditKeep)
+	}
+
+	sortKeys, err := user.ParseSortKeys(cfg.DefaultSort)
+	if err != nil {
+		return err
+	}
+	policy, err := namePolicy(cfg)
+	if err != nil {
+		return err
+	}
+	timeFormat, err := parseTimeFormat(cfg.TimeFormat)
+	if err != nil {
+		return err
+	}
+	timeZone, err := parseTimeZone(cfg.TimeZone)
+	if err != nil {
+		return err
+	}
+	output, err := parseOutputFormat(cfg.Output)
+	if err != nil {
+		return err
+	}
+
+	utils.Policy = policy
+	TimeFormat, TimeZone, Output = timeFormat, timeZone, output
+	user.MaxUsernameLength = cfg.MaxUsernameLength
+	user.MaxFolderNameLength = cfg.MaxFolderNameLength
+	user.MaxFileNameLength = cfg.MaxFileNameLength
+	user.RevisionRetention = cfg.RevisionRetention
+	DefaultSort = sortKeys
+	Admins = make(map[string]bool)
+	for _, admin := range splitList(cfg.Admins) {
+		Admins[admin] = true
+	}
+	AuditLog = nil
+	if cfg.AuditFile != "" {
+		AuditLog = &audit.Log{Path: cfg.AuditFile, MaxSize: int64(cfg.AuditMaxSize), Keep: cfg.AuditKeep}
+	}
+	config.Current = cfg
+	return nil
+}
+
+// namePolicy is the name policy of cfg with the forbidden characters,
+// reserved names and length unit of the deployment
+func namePolicy(cfg config.Config) (utils.NamePolicy, error) {
+	policy, err := utils.LookupNamePolicy(cfg.NamePolicy)
+	if err != nil {
+		return policy, err
+	}
+	return policy.Customize(cfg.NameForbidden, splitList(cfg.NameReserved), cfg.NameLengthUnit)
+}
+
+// splitList reads a comma separated setting, ignoring empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Config shows the settings the session was started with and where each came from
+func Config(args []string) (string, error) {
+	if len(args) != 1 || args[0] != "show" {
+		return "", user.Usage("config")
+	}
+
+	settings := config.Current.Settings()
+	rows := make([][]string, 0, len(settings)+1)
+	for _, setting := range settings {
+		// Quoted so empty values and the prompt's trailing space stay visible
+		rows = append(rows, []string{setting[0], `"` + setting[1] + `"`, setting[2]})
+	}
+
+	path := config.Current.Path
+	if path == "" {
+		path = "none"
+	}
+	rows = append(rows, []string{"config_file", path, ""})
+
+	return renderTable([]string{"key", "value", "source"}, rows), nil
+}
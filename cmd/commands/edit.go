@@ -0,0 +1,237 @@
+package commands
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"repl-cli-iscoollab/internal/user"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Input is the reader the REPL reads commands from, the line editor
+	// reads from it too so input piped in stays in order
+	Input = bufio.NewReader(os.Stdin)
+
+	// EditorOutput receives the prompts and listings of the line editor
+	EditorOutput io.Writer = os.Stdout
+
+	// runEditor opens path in the editor command and waits for it to exit
+	runEditor = func(editor string, path string) error {
+		fields := strings.Fields(editor)
+		cmd := exec.Command(fields[0], append(fields[1:], path)...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		return cmd.Run()
+	}
+)
+
+// Edit opens the content of a file in $EDITOR, or in a line editor when it
+// isn't set, and writes the result back as a new revision. A file changed
+// by anything else meanwhile is left alone and the edit is kept aside
+func Edit(args []string) (string, error) {
+	if len(args) != 3 {
+		return "", user.Usage("edit")
+	}
+
+	file, err := getFile(args[0], args[1], args[2])
+	if err != nil {
+		return "", err
+	}
+	edited, base, original := file, file.LastRevision(), file.Content
+
+	var content string
+	var saved bool
+	editor := strings.TrimSpace(os.Getenv("EDITOR"))
+	outsideSession(func() {
+		if editor != "" {
+			content, saved, err = editExternal(editor, file.Name, original)
+		} else {
+			content, saved, err = editLines(original)
+		}
+	})
+	if err != nil {
+		return "", err
+	}
+	if !saved {
+		return renderMessage(fmt.Sprintf("Discard the changes to %s", args[2])), nil
+	}
+
+	// Background syncs may have written, deleted or recreated the file while
+	// editing, a write of the same content still counts as a change
+	file, err = getFile(args[0], args[1], args[2])
+	if err != nil || file != edited || file.LastRevision() != base {
+		kept, keepErr := keepEdit(args[2], content)
+		if keepErr != nil {
+			return "", keepErr
+		}
+		if err != nil {
+			return "", user.Errorf(user.Conflict, "the %s was deleted while editing, your version is kept in %s", args[2], kept)
+		}
+		return "", user.Errorf(user.Conflict, "the %s was changed while editing, your version is kept in %s", args[2], kept)
+	}
+
+	if content == original {
+		return renderMessage(fmt.Sprintf("No changes to %s", args[2])), nil
+	}
+	revision := file.Write(content, Actor)
+	return renderMessage(fmt.Sprintf("Write %d bytes to %s in %s/%s as revision %d successfully", file.Size(), args[2], args[0], args[1], revision.Number)), nil
+}
+
+// editExternal runs the editor on a temporary copy of content named after
+// the file, so editors pick the syntax from its extension
+func editExternal(editor string, fileName string, content string) (string, bool, error) {
+	tmp, err := os.CreateTemp("", "vfs-edit-*-"+strings.Trim(fileName, `"'`))
+	if err != nil {
+		return "", false, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return "", false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", false, err
+	}
+
+	if err := runEditor(editor, tmp.Name()); err != nil {
+		return "", false, user.Errorf(user.UsageError, "the editor %s failed: %v", editor, err)
+	}
+	edited, err := os.ReadFile(tmp.Name())
+	if err != nil {
+		return "", false, err
+	}
+	return string(edited), true, nil
+}
+
+// keepEdit saves an edit that couldn't be written back to a host file
+func keepEdit(fileName string, content string) (string, error) {
+	kept, err := os.CreateTemp("", "vfs-edit-*-"+strings.Trim(fileName, `"'`))
+	if err != nil {
+		return "", err
+	}
+	if _, err := kept.WriteString(content); err != nil {
+		kept.Close()
+		return "", err
+	}
+	return kept.Name(), kept.Close()
+}
+
+const lineEditorHelp = `Line editor, commands:
+  p            print the lines with their numbers
+  a            append lines after the last one, end with a single "."
+  i N          insert lines before line N, or after the last with N one past it, end with a single "."
+  c N          replace line N with the lines that follow, end with a single "."
+  d N[,M]      delete line N, or lines N to M
+  w            save and quit
+  q            quit without saving
+`
+
+// editLines is a minimal line editor reading its commands from Input, for
+// sessions without $EDITOR. It reports false when the user quits without
+// saving or the input ends
+func editLines(content string) (string, bool, error) {
+	trailing := strings.HasSuffix(content, "\n")
+	var lines []string
+	if content != "" {
+		lines = strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	}
+
+	fmt.Fprint(EditorOutput, lineEditorHelp)
+	printLines(lines)
+	for {
+		fmt.Fprint(EditorOutput, "edit> ")
+		command, err := Input.ReadString('\n')
+		if err != nil && (err != io.EOF || command == "") {
+			fmt.Fprintln(EditorOutput)
+			return "", false, nil
+		}
+
+		name, argument, _ := strings.Cut(strings.TrimSpace(command), " ")
+		// i inserts before a line, or after the last one with one past it
+		limit := len(lines)
+		if name == "i" {
+			limit++
+		}
+		from, to, rangeErr := parseLineRange(argument, limit)
+		switch name {
+		case "":
+			continue
+		case "p":
+			printLines(lines)
+			continue
+		case "w":
+			text := strings.Join(lines, "\n")
+			if trailing || (content == "" && len(lines) > 0) {
+				text += "\n"
+			}
+			return text, true, nil
+		case "q":
+			return "", false, nil
+		case "a":
+			lines = append(lines, readLines()...)
+			continue
+		case "i", "c", "d":
+		default:
+			fmt.Fprint(EditorOutput, lineEditorHelp)
+			continue
+		}
+
+		if rangeErr != nil {
+			fmt.Fprintf(EditorOutput, "%s, type p to see the lines\n", rangeErr)
+			continue
+		}
+		switch name {
+		case "i":
+			lines = append(lines[:from-1], append(readLines(), lines[from-1:]...)...)
+		case "c":
+			lines = append(lines[:from-1], append(readLines(), lines[to:]...)...)
+		case "d":
+			lines = append(lines[:from-1], lines[to:]...)
+		}
+	}
+}
+
+// parseLineRange reads "N" or "N,M" as line numbers between 1 and limit
+func parseLineRange(argument string, limit int) (int, int, error) {
+	first, last, isRange := strings.Cut(argument, ",")
+	from, err := strconv.Atoi(strings.TrimSpace(first))
+	to := from
+	if err == nil && isRange {
+		to, err = strconv.Atoi(strings.TrimSpace(last))
+	}
+	if err != nil || from < 1 || to < from || to > limit {
+		return 0, 0, user.Errorf(user.UsageError, "the %s is not a line between 1 and %d", argument, limit)
+	}
+	return from, to, nil
+}
+
+// readLines reads the lines typed until a single "." or the end of input
+func readLines() []string {
+	var lines []string
+	for {
+		line, err := Input.ReadString('\n')
+		line = strings.TrimRight(line, "\r\n")
+		if line == "." || (err != nil && line == "") {
+			return lines
+		}
+		lines = append(lines, line)
+		if err != nil {
+			return lines
+		}
+	}
+}
+
+func printLines(lines []string) {
+	if len(lines) == 0 {
+		fmt.Fprintln(EditorOutput, "(empty)")
+		return
+	}
+	width := len(strconv.Itoa(len(lines)))
+	for i, line := range lines {
+		fmt.Fprintf(EditorOutput, "%*d  %s\n", width, i+1, line)
+	}
+}
@@ -0,0 +1,183 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"repl-cli-iscoollab/internal/user"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ManifestName is the sidecar file export writes next to the folders, it
+// keeps what the host filesystem can't so import-dir restores it
+const ManifestName = ".vfs-manifest.json"
+
+type manifest struct {
+	Version    int             `json:"version"`
+	User       string          `json:"user"`
+	ExportedAt time.Time       `json:"exported_at"`
+	Folders    []manifestEntry `json:"folders"`
+}
+
+// manifestEntry describes an exported folder or file, its host name is Name
+type manifestEntry struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ModifiedAt  time.Time         `json:"modified_at"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Files       []manifestEntry   `json:"files,omitempty"`
+}
+
+// readManifest loads the manifest of an exported directory, or returns nil
+// when the directory isn't one
+func readManifest(dir string) (*manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("the %s is not a valid manifest: %v", ManifestName, err)
+	}
+	return &m, nil
+}
+
+// folder returns the entry of the folder exported under name, or nil
+func (m *manifest) folder(name string) *manifestEntry {
+	if m == nil {
+		return nil
+	}
+	for i := range m.Folders {
+		if m.Folders[i].Name == name {
+			return &m.Folders[i]
+		}
+	}
+	return nil
+}
+
+// file returns the entry of the file exported under name, or nil
+func (e *manifestEntry) file(name string) *manifestEntry {
+	if e == nil {
+		return nil
+	}
+	for i := range e.Files {
+		if e.Files[i].Name == name {
+			return &e.Files[i]
+		}
+	}
+	return nil
+}
+
+func (e *manifestEntry) description() string {
+	if e == nil {
+		return ""
+	}
+	return e.Description
+}
+
+// Export writes the folders of a user to the host as directories holding
+// their files, or as a tar, tar.gz or zip archive of the same layout, with a
+// manifest keeping descriptions, attributes, tags and times
+func Export(args []string) (string, error) {
+	args, format, found := extractFlag(args, "--format")
+	if !found {
+		format = "dir"
+	}
+	if len(args) != 2 {
+		return "", user.Usage("export")
+	}
+	format = strings.ToLower(format)
+	if !slices.Contains(ExportFormats, format) {
+		return "", user.Errorf(user.UsageError, "the %s is not an export format, use one of %s", format, strings.Join(ExportFormats, ", "))
+	}
+
+	u, err := user.GetUser(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Clean(unquote(args[1]))
+	if _, err := os.Lstat(target); err == nil {
+		return "", user.Errorf(user.AlreadyExists, "the %s already exists on the host", target)
+	}
+
+	writer, err := newArchiveWriter(target, format)
+	if err != nil {
+		return "", err
+	}
+	folders, files, err := exportUser(u, writer)
+	if closeErr := writer.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.RemoveAll(target)
+		return "", err
+	}
+
+	return renderMessage(fmt.Sprintf("Export %s and %s of %s to %s successfully", plural(folders, "folder"), plural(files, "file"), u.Username, target)), nil
+}
+
+// exportUser writes the folders and files of the user in name order and the
+// manifest last, reading them records no access
+func exportUser(u *user.User, writer archiveWriter) (int, int, error) {
+	m := manifest{Version: 1, User: u.Username, ExportedAt: user.Now(), Folders: []manifestEntry{}}
+	files := 0
+
+	for _, folder := range sortedByName(slices.Collect(maps.Values(u.Folders)), func(f *user.Folder) string { return f.Name }) {
+		if err := writer.dir(folder.Name, folder.ModifiedAt); err != nil {
+			return 0, 0, err
+		}
+
+		entry := manifestEntry{
+			Name:        folder.Name,
+			Description: folder.Description,
+			CreatedAt:   folder.CreatedAt,
+			ModifiedAt:  folder.ModifiedAt,
+			Attributes:  folder.Attributes,
+			Tags:        folder.Tags,
+		}
+		for _, file := range sortedByName(slices.Collect(maps.Values(folder.Files)), func(f *user.File) string { return f.Name }) {
+			if err := writer.file(folder.Name+"/"+file.Name, []byte(file.Content), file.ModifiedAt); err != nil {
+				return 0, 0, err
+			}
+			entry.Files = append(entry.Files, manifestEntry{
+				Name:        file.Name,
+				Description: file.Description,
+				CreatedAt:   file.CreatedAt,
+				ModifiedAt:  file.ModifiedAt,
+				Attributes:  file.Attributes,
+				Tags:        file.Tags,
+			})
+			files++
+		}
+		m.Folders = append(m.Folders, entry)
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := writer.file(ManifestName, data, m.ExportedAt); err != nil {
+		return 0, 0, err
+	}
+	return len(m.Folders), files, nil
+}
+
+func sortedByName[T any](items []T, name func(T) string) []T {
+	slices.SortFunc(items, func(a, b T) int {
+		return strings.Compare(strings.ToLower(name(a)), strings.ToLower(name(b)))
+	})
+	return items
+}
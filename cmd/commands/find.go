@@ -0,0 +1,136 @@
+package commands
+
+import (
+	"regexp"
+	"repl-cli-iscoollab/internal/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeInputFormats are the layouts accepted for time arguments such as --created-after
+var TimeInputFormats = []string{time.RFC3339, time.DateTime, time.DateOnly}
+
+// parseTime reads a time argument in the display time zone
+func parseTime(str string) (time.Time, error) {
+	str = unquote(str)
+	for _, layout := range TimeInputFormats {
+		if t, err := time.ParseInLocation(layout, str, TimeZone); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, user.Errorf(user.UsageError, "the %s is not a valid time, use YYYY-MM-DD, \"YYYY-MM-DD hh:mm:ss\" or RFC 3339", str)
+}
+
+// findOptions pulls every filter flag out of the arguments
+func findOptions(args []string) ([]string, user.FindOptions, error) {
+	var opts user.FindOptions
+	usage := user.Usage("find")
+
+	flags := []string{"--name", "--regex", "--description", "--created-after", "--created-before", "--min-size", "--max-size", "--tag", "--attr"}
+	for _, name := range flags {
+		var value string
+		var found bool
+		args, value, found = extractFlag(args, name)
+		if !found {
+			continue
+		}
+		if value == "" {
+			return nil, opts, usage
+		}
+
+		var err error
+		switch name {
+		case "--name":
+			opts.Name = strings.ToLower(value)
+		case "--regex":
+			opts.Regex, err = regexp.Compile(unquote(value))
+			if err != nil {
+				return nil, opts, user.Errorf(user.UsageError, "the %s is not a valid regex", value)
+			}
+		case "--description":
+			opts.Description = unquote(value)
+		case "--created-after":
+			opts.CreatedAfter, err = parseTime(value)
+		case "--created-before":
+			opts.CreatedBefore, err = parseTime(value)
+		case "--min-size", "--max-size":
+			size, convErr := strconv.Atoi(value)
+			if convErr != nil || size < 0 {
+				return nil, opts, user.Errorf(user.UsageError, "the %s is not a valid size", value)
+			}
+			if name == "--min-size" {
+				opts.MinSize = size
+			} else {
+				opts.MaxSize = size
+				opts.HasMaxSize = true
+			}
+		case "--tag":
+			opts.Tags, err = user.ParseTagQuery(value)
+		case "--attr":
+			key, attrValue, _ := strings.Cut(value, "=")
+			opts.Attrs = map[string]string{strings.ToLower(key): attrValue}
+		}
+		if err != nil {
+			return nil, opts, err
+		}
+	}
+
+	return args, opts, nil
+}
+
+// Find searches the files of one user, or of every user with --all-users
+// for admins, and prints their fully qualified paths
+func Find(args []string) (string, error) {
+	args, sortFlag, sorted := extractFlag(args, "--sort")
+	var sortBy []user.SortKey
+	if sorted {
+		var err error
+		sortBy, err = parseSort(sortFlag, "find")
+		if err != nil {
+			return "", err
+		}
+	}
+
+	args, opts, err := findOptions(args)
+	if err != nil {
+		return "", err
+	}
+
+	if len(args) < 1 || len(args) > 3 {
+		return "", user.Usage("find")
+	}
+
+	keys, err := sortKeys(sortBy, args[1:], "find")
+	if err != nil {
+		return "", err
+	}
+
+	var matches []user.Match
+	if args[0] == "--all-users" {
+		if err := requireAdmin("--all-users"); err != nil {
+			return "", err
+		}
+		matches, err = user.FindAll(opts)
+	} else {
+		var u *user.User
+		u, err = user.GetUser(args[0])
+		if err != nil {
+			return "", err
+		}
+		matches, err = u.Find(opts)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	user.SortMatches(matches, keys)
+
+	rows := make([][]string, 0, len(matches))
+	for _, match := range matches {
+		file := match.File
+		rows = append(rows, []string{match.Path(), file.Description, formatTime(file.CreatedAt), strconv.Itoa(file.Size()), strings.Join(file.Tags, ","), formatAttrs(file.ListAttrs())})
+	}
+
+	return renderTable([]string{"path", "description", "created_at", "size", "tags", "attributes"}, rows), nil
+}
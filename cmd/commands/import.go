@@ -0,0 +1,365 @@
+package commands
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"repl-cli-iscoollab/internal/user"
+	"repl-cli-iscoollab/internal/utils"
+	"strings"
+	"time"
+)
+
+// SanitizeModes decide what import-dir does with host names the name policy
+// rejects, replace swaps the rejected characters for underscores
+var SanitizeModes = []string{"replace", "skip"}
+
+// importEntry is a host directory or file import-dir turns into a folder or
+// file, source is its path relative to the imported directory
+type importEntry struct {
+	source  string
+	path    string
+	folder  string
+	file    string
+	modTime time.Time
+	size    int64
+	// meta is what the manifest of an export recorded for the entry
+	meta *manifestEntry
+	// merged reports the folder already existed and keeps its own times
+	merged bool
+}
+
+type importPlan struct {
+	entries []importEntry
+	// skipped holds the source and the reason of every entry left out
+	skipped [][2]string
+}
+
+func (p *importPlan) skip(source string, reason string) {
+	p.skipped = append(p.skipped, [2]string{source, reason})
+}
+
+// ImportDir copies a host directory, or a tar, tar.gz or zip archive, into
+// folders of a user. The directory becomes the named folder, or one named
+// after it, and every directory below becomes a folder named after its path
+// joined with dots. Files keep their contents and modification times, names
+// the policy rejects are sanitized or skipped, and --dry-run only reports
+// what would be imported. A directory written by export is imported back
+// into the folders it lists with the descriptions, attributes, tags and
+// times of its manifest
+func ImportDir(args []string) (string, error) {
+	args, mode, sanitize := extractFlag(args, "--sanitize")
+	if !sanitize {
+		mode = "replace"
+	}
+	var dryRun, hidden bool
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg {
+		case "--dry-run":
+			dryRun = true
+		case "--hidden":
+			hidden = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	args = rest
+
+	if len(args) < 2 || len(args) > 3 {
+		return "", user.Usage("import-dir")
+	}
+	mode = strings.ToLower(mode)
+	if mode != "replace" && mode != "skip" {
+		return "", user.Errorf(user.UsageError, "the %s is not a sanitize mode, use one of %s", mode, strings.Join(SanitizeModes, ", "))
+	}
+
+	root := filepath.Clean(unquote(args[0]))
+	info, err := os.Stat(root)
+	if err != nil {
+		return "", user.Errorf(user.NotFound, "the %s doesn't exist on the host", root)
+	}
+	abs, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Base(abs)
+	if !info.IsDir() {
+		format := archiveFormat(root)
+		if format == "" {
+			return "", user.Errorf(user.UsageError, "the %s is not a directory or a tar, tar.gz or zip archive", root)
+		}
+
+		dir, err := extractArchive(root, format)
+		if err != nil {
+			return "", err
+		}
+		defer os.RemoveAll(dir)
+		root, base = dir, trimArchiveExt(base)
+	}
+
+	u, err := user.GetUser(args[1])
+	if err != nil {
+		return "", err
+	}
+
+	m, err := readManifest(root)
+	if err != nil {
+		return "", err
+	}
+
+	var rootFolder string
+	switch {
+	case m != nil && len(args) == 3:
+		return "", user.Errorf(user.UsageError, "the %s is an export, its folders are imported under their own names", args[0])
+	case m != nil:
+		// Exports have no root folder, each directory is a folder of its own
+	case len(args) == 3:
+		rootFolder = args[2]
+	default:
+		var ok bool
+		rootFolder, ok = utils.Policy.Sanitize(base, user.MaxFolderNameLength)
+		if !ok {
+			return "", user.Errorf(user.InvalidName, "the %s can't be used as a folder name, name the folder to import into", base)
+		}
+	}
+	if m == nil {
+		if _, err := utils.Policy.Normalize(rootFolder); err != nil {
+			return "", user.Errorf(user.InvalidName, "%s", err.Error())
+		}
+	}
+
+	plan, err := planImport(u, root, rootFolder, m, mode == "skip", hidden)
+	if err != nil {
+		return "", err
+	}
+	if !dryRun {
+		applyImport(u, plan)
+	}
+
+	return renderImport(u.Username, plan, dryRun), nil
+}
+
+// planImport walks the host directory and decides where every entry goes
+// without changing anything, with a manifest the directory is an export
+func planImport(u *user.User, root string, rootFolder string, m *manifest, strict bool, hidden bool) (*importPlan, error) {
+	plan := &importPlan{}
+	// folders maps the relative path of each imported directory to its folder,
+	// claimed maps the lowercased names taken so far to the source claiming them
+	folders := make(map[string]string)
+	claimed := make(map[string]string)
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		source, _ := filepath.Rel(root, path)
+		if m != nil && source == ManifestName {
+			return nil
+		}
+		if err != nil {
+			plan.skip(source, err.Error())
+			if entry != nil && entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		skipDir := func(reason string) error {
+			plan.skip(source, reason)
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if source != "." && !hidden && strings.HasPrefix(entry.Name(), ".") {
+			return skipDir("hidden, use --hidden to import it")
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return skipDir("not a regular file")
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return skipDir(err.Error())
+		}
+
+		if entry.IsDir() {
+			if m != nil && source == "." {
+				return nil
+			}
+			name := rootFolder
+			if m != nil {
+				name = strings.Join(strings.Split(filepath.ToSlash(source), "/"), ".")
+			} else if source != "." {
+				name = rootFolder + "." + strings.Join(strings.Split(filepath.ToSlash(source), "/"), ".")
+			}
+			name, reason := importName(name, user.MaxFolderNameLength, strict)
+			if reason != "" {
+				return skipDir(reason)
+			}
+			if other, taken := claimed[strings.ToLower(name)]; taken {
+				return skipDir(fmt.Sprintf("the folder %s is already imported from %s", name, other))
+			}
+
+			claimed[strings.ToLower(name)] = source
+			folders[source] = name
+			plan.entries = append(plan.entries, importEntry{source: source, path: path, folder: name, modTime: info.ModTime(), meta: m.folder(name)})
+			return nil
+		}
+
+		folderName, inFolder := folders[filepath.Dir(source)]
+		if !inFolder {
+			return skipDir("not inside a folder of the export")
+		}
+		name, reason := importName(entry.Name(), user.MaxFileNameLength, strict)
+		if reason != "" {
+			return skipDir(reason)
+		}
+		target := folderName + "/" + name
+		if other, taken := claimed[strings.ToLower(target)]; taken {
+			return skipDir(fmt.Sprintf("the file %s is already imported from %s", target, other))
+		}
+		if folder, err := u.GetFolder(folderName); err == nil {
+			if _, err := folder.GetFile(name); err == nil {
+				return skipDir(fmt.Sprintf("the file %s already exists", target))
+			}
+		}
+
+		claimed[strings.ToLower(target)] = source
+		plan.entries = append(plan.entries, importEntry{source: source, path: path, folder: folderName, file: name, modTime: info.ModTime(), size: info.Size(), meta: m.folder(folderName).file(entry.Name())})
+		return nil
+	})
+	return plan, err
+}
+
+// importName is the VFS name for a host name, or the reason it is skipped
+func importName(name string, maxLength int, strict bool) (string, string) {
+	if _, err := utils.Policy.Normalize(name); err == nil && utils.Policy.Length(name) <= maxLength {
+		return name, ""
+	}
+	if strict {
+		return "", fmt.Sprintf("the %s is not a valid name", name)
+	}
+
+	sanitized, ok := utils.Policy.Sanitize(name, maxLength)
+	if !ok {
+		return "", fmt.Sprintf("the %s can't be turned into a valid name", name)
+	}
+	return sanitized, ""
+}
+
+// applyImport creates the planned folders and files, entries failing on the
+// way are moved to the skipped list
+func applyImport(u *user.User, plan *importPlan) {
+	applied := plan.entries[:0]
+	for _, entry := range plan.entries {
+		if err := importEntryInto(u, &entry); err != nil {
+			plan.skip(entry.source, err.Error())
+			continue
+		}
+		applied = append(applied, entry)
+	}
+	plan.entries = applied
+
+	// Adding the files changed the folders, so their times are set again
+	for _, entry := range plan.entries {
+		if entry.file != "" || entry.merged {
+			continue
+		}
+		folder, _ := u.GetFolder(entry.folder)
+		if entry.meta != nil {
+			folder.SetTimes(entry.meta.CreatedAt, entry.meta.ModifiedAt)
+		} else {
+			folder.SetTimes(entry.modTime, entry.modTime)
+		}
+	}
+}
+
+func importEntryInto(u *user.User, entry *importEntry) error {
+	if entry.file == "" {
+		// Importing into an existing folder adds to it and keeps its times
+		if _, err := u.GetFolder(entry.folder); err == nil {
+			entry.merged = true
+			return nil
+		}
+		if err := u.CreateFolder(entry.folder, entry.meta.description()); err != nil {
+			return err
+		}
+		if entry.meta == nil {
+			return nil
+		}
+
+		folder, _ := u.GetFolder(entry.folder)
+		for key, value := range entry.meta.Attributes {
+			if err := folder.SetAttr(key, value); err != nil {
+				return err
+			}
+		}
+		for _, tag := range entry.meta.Tags {
+			if err := u.TagFolder(entry.folder, tag); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	content, err := os.ReadFile(entry.path)
+	if err != nil {
+		return err
+	}
+	folder, err := u.GetFolder(entry.folder)
+	if err != nil {
+		return err
+	}
+	if err := folder.CreateFile(entry.file, entry.meta.description()); err != nil {
+		return err
+	}
+
+	file, _ := folder.GetFile(entry.file)
+	file.Write(string(content), Actor)
+	if entry.meta == nil {
+		file.SetTimes(entry.modTime, entry.modTime)
+		return nil
+	}
+
+	for key, value := range entry.meta.Attributes {
+		if err := file.SetAttr(key, value); err != nil {
+			return err
+		}
+	}
+	for _, tag := range entry.meta.Tags {
+		if err := u.TagFile(entry.folder, entry.file, tag); err != nil {
+			return err
+		}
+	}
+	file.SetTimes(entry.meta.CreatedAt, entry.meta.ModifiedAt)
+	return nil
+}
+
+func renderImport(username string, plan *importPlan, dryRun bool) string {
+	var folders, files int
+	rows := make([][]string, 0, len(plan.entries)+len(plan.skipped))
+	for _, entry := range plan.entries {
+		if entry.file == "" {
+			folders++
+			rows = append(rows, []string{"folder", entry.source, entry.folder, ""})
+		} else {
+			files++
+			rows = append(rows, []string{"file", entry.source, entry.folder + "/" + entry.file, plural(int(entry.size), "byte")})
+		}
+	}
+	for _, skipped := range plan.skipped {
+		rows = append(rows, []string{"skip", skipped[0], "", skipped[1]})
+	}
+
+	output := renderTable([]string{"action", "source", "target", "note"}, rows)
+	if Output != "plain" {
+		return output
+	}
+
+	summary := fmt.Sprintf("Import %s and %s into %s successfully, %d skipped", plural(folders, "folder"), plural(files, "file"), username, len(plan.skipped))
+	if dryRun {
+		summary = fmt.Sprintf("Dry run: would import %s and %s into %s, %d skipped", plural(folders, "folder"), plural(files, "file"), username, len(plan.skipped))
+	}
+	return output + summary + "\n"
+}
@@ -0,0 +1,173 @@
+package commands
+
+import (
+	"fmt"
+	"repl-cli-iscoollab/internal/user"
+	"strings"
+)
+
+// resolveEntry looks up the folder, or the file inside it when a filename is
+// given, and returns it with the label used in success messages
+func resolveEntry(username string, folderName string, fileName string) (user.Entry, string, error) {
+	u, err := user.GetUser(username)
+	if err != nil {
+		return nil, "", err
+	}
+
+	folder, err := u.GetFolder(folderName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if fileName == "" {
+		return folder, entryLabel(username, folderName, fileName), nil
+	}
+
+	file, err := folder.GetFile(fileName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return file, entryLabel(username, folderName, fileName), nil
+}
+
+// entryLabel names a folder, or a file inside it, the way success messages do
+func entryLabel(username string, folderName string, fileName string) string {
+	if fileName == "" {
+		return folderName
+	}
+	return fmt.Sprintf("%s in %s/%s", fileName, username, folderName)
+}
+
+// entryArgs splits the leading [username] [foldername] [filename]? arguments
+// from the trailing ones, the filename is present when args has the longer arity
+func entryArgs(args []string, trailing int) (string, string, string, []string) {
+	username := args[0]
+	folderName := args[1]
+	var fileName string
+	if len(args) == trailing+3 {
+		fileName = args[2]
+	}
+	return username, folderName, fileName, args[len(args)-trailing:]
+}
+
+func SetDescription(args []string) (string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return "", user.Usage("set-description")
+	}
+
+	username, folderName, fileName, rest := entryArgs(args, 1)
+	entry, label, err := resolveEntry(username, folderName, fileName)
+	if err != nil {
+		return "", err
+	}
+
+	entry.SetDescription(rest[0])
+
+	return renderMessage(fmt.Sprintf("Set description of %s successfully", label)), nil
+}
+
+func ClearDescription(args []string) (string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", user.Usage("clear-description")
+	}
+
+	username, folderName, fileName, _ := entryArgs(args, 0)
+	entry, label, err := resolveEntry(username, folderName, fileName)
+	if err != nil {
+		return "", err
+	}
+
+	entry.SetDescription("")
+
+	return renderMessage(fmt.Sprintf("Clear description of %s successfully", label)), nil
+}
+
+func SetAttr(args []string) (string, error) {
+	if len(args) != 4 && len(args) != 5 {
+		return "", user.Usage("set-attr")
+	}
+
+	username, folderName, fileName, rest := entryArgs(args, 2)
+	entry, label, err := resolveEntry(username, folderName, fileName)
+	if err != nil {
+		return "", err
+	}
+
+	key := strings.ToLower(rest[0])
+	err = entry.SetAttr(key, rest[1])
+	if err != nil {
+		return "", err
+	}
+
+	return renderMessage(fmt.Sprintf("Set %s on %s successfully", key, label)), nil
+}
+
+func GetAttr(args []string) (string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return "", user.Usage("get-attr")
+	}
+
+	username, folderName, fileName, rest := entryArgs(args, 1)
+	entry, _, err := resolveEntry(username, folderName, fileName)
+	if err != nil {
+		return "", err
+	}
+
+	key := strings.ToLower(rest[0])
+	value, err := entry.GetAttr(key)
+	if err != nil {
+		return "", err
+	}
+
+	return renderTable([]string{"key", "value"}, [][]string{{key, value}}), nil
+}
+
+func ListAttrs(args []string) (string, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return "", user.Usage("list-attrs")
+	}
+
+	username, folderName, fileName, _ := entryArgs(args, 0)
+	entry, _, err := resolveEntry(username, folderName, fileName)
+	if err != nil {
+		return "", err
+	}
+
+	attrs := entry.ListAttrs()
+	rows := make([][]string, 0, len(attrs))
+	for _, attr := range attrs {
+		rows = append(rows, []string{attr[0], attr[1]})
+	}
+
+	return renderTable([]string{"key", "value"}, rows), nil
+}
+
+func UnsetAttr(args []string) (string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return "", user.Usage("unset-attr")
+	}
+
+	username, folderName, fileName, rest := entryArgs(args, 1)
+	entry, label, err := resolveEntry(username, folderName, fileName)
+	if err != nil {
+		return "", err
+	}
+
+	key := strings.ToLower(rest[0])
+	err = entry.UnsetAttr(key)
+	if err != nil {
+		return "", err
+	}
+
+	return renderMessage(fmt.Sprintf("Unset %s on %s successfully", key, label)), nil
+}
+
+// formatAttrs joins attributes as comma separated key=value pairs for listings
+func formatAttrs(attrs [][2]string) string {
+	pairs := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		pairs = append(pairs, attr[0]+"="+attr[1])
+	}
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,313 @@
+package commands
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"repl-cli-iscoollab/internal/user"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	// Output is the format used to render command results for the session
+	Output = "plain"
+
+	OutputFormats = []string{"plain", "json", "csv", "table"}
+
+	// PageOutput receives the total and next cursor of csv pages, CSV has
+	// no comments so they are kept out of the records on stdout
+	PageOutput io.Writer = os.Stderr
+
+	// TimeFormat and TimeZone control how timestamps are displayed
+	TimeFormat = time.DateTime
+	TimeZone   = time.Local
+
+	TimeFormats = map[string]string{
+		"datetime":    time.DateTime,
+		"date":        time.DateOnly,
+		"rfc3339":     time.RFC3339,
+		"rfc3339nano": time.RFC3339Nano,
+	}
+)
+
+func SetOutputFormat(format string) error {
+	format, err := parseOutputFormat(format)
+	if err != nil {
+		return err
+	}
+	Output = format
+	return nil
+}
+
+func parseOutputFormat(format string) (string, error) {
+	format = strings.ToLower(format)
+	for _, f := range OutputFormats {
+		if f == format {
+			return format, nil
+		}
+	}
+
+	return "", user.Errorf(user.UsageError, "the %s is not a valid output format, use one of %s", format, strings.Join(OutputFormats, ", "))
+}
+
+// SetTimeFormat accepts either a named format or a Go reference time layout
+func SetTimeFormat(format string) error {
+	layout, err := parseTimeFormat(format)
+	if err != nil {
+		return err
+	}
+	TimeFormat = layout
+	return nil
+}
+
+func parseTimeFormat(format string) (string, error) {
+	if layout, exists := TimeFormats[strings.ToLower(format)]; exists {
+		return layout, nil
+	}
+
+	reference := time.Date(2006, time.January, 2, 15, 4, 5, 0, time.UTC)
+	if reference.Format(format) == format {
+		return "", user.Errorf(user.UsageError, "the %s is not a valid time format", format)
+	}
+	return format, nil
+}
+
+func SetTimeZone(zone string) error {
+	location, err := parseTimeZone(zone)
+	if err != nil {
+		return err
+	}
+	TimeZone = location
+	return nil
+}
+
+func parseTimeZone(zone string) (*time.Location, error) {
+	location, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, user.Errorf(user.UsageError, "the %s is not a valid time zone", zone)
+	}
+	return location, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.In(TimeZone).Format(TimeFormat)
+}
+
+// ExtractOutputFlag removes a "--output [format]" pair from the arguments
+// so it can be applied to a single command, returning the remaining args
+func ExtractOutputFlag(args []string) ([]string, string, error) {
+	rest, format, found := extractFlag(args, "--output")
+	if found && format == "" {
+		return nil, "", user.Errorf(user.UsageError, "the --output flag requires a format, use one of %s", strings.Join(OutputFormats, ", "))
+	}
+
+	return rest, strings.ToLower(format), nil
+}
+
+// extractFlag removes every "[name] [value]" pair from the arguments and
+// returns the remaining args with the last value, which is empty when the
+// flag is given without one
+func extractFlag(args []string, name string) ([]string, string, bool) {
+	rest := make([]string, 0, len(args))
+	var value string
+	var found bool
+	for i := 0; i < len(args); i++ {
+		if args[i] != name {
+			rest = append(rest, args[i])
+			continue
+		}
+
+		found = true
+		value = ""
+		if i+1 < len(args) {
+			value = args[i+1]
+			i++
+		}
+	}
+
+	return rest, value, found
+}
+
+func Set(args []string) (string, error) {
+	if len(args) != 2 {
+		return "", user.Usage("set")
+	}
+
+	option := strings.ToLower(args[0])
+	value := unquote(args[1])
+	var err error
+	switch option {
+	case "output":
+		value = strings.ToLower(value)
+		err = SetOutputFormat(value)
+	case "time-format":
+		err = SetTimeFormat(value)
+	case "timezone":
+		err = SetTimeZone(value)
+	case "actor":
+		err = switchActor(value)
+	default:
+		return "", user.Usage("set")
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return renderMessage(fmt.Sprintf("Set %s to %s successfully", option, value)), nil
+}
+
+// renderMessage renders a single success message in the session output format
+func renderMessage(message string) string {
+	switch Output {
+	case "json":
+		return renderJSON(struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}{"ok", message})
+	case "csv", "table":
+		return renderTable([]string{"status", "message"}, [][]string{{"ok", message}})
+	default:
+		return message + "\n"
+	}
+}
+
+// RenderError renders an error in the session output format with its stable
+// code, usage errors are printed as-is in plain mode while other errors get
+// an "Error: " prefix
+func RenderError(err error) string {
+	code := user.ErrorCode(err)
+	switch Output {
+	case "json":
+		return renderJSON(struct {
+			Status string `json:"status"`
+			Code   string `json:"code"`
+			Error  string `json:"error"`
+		}{"error", code, err.Error()})
+	case "csv", "table":
+		return renderTable([]string{"status", "code", "error"}, [][]string{{"error", code, err.Error()}})
+	default:
+		if user.IsUsage(err) {
+			return err.Error() + "\n"
+		}
+		return "Error: " + err.Error() + "\n"
+	}
+}
+
+// renderTable renders rows under the given headers in the session output format.
+// Plain mode keeps the historical space separated layout and skips empty cells
+func renderTable(headers []string, rows [][]string) string {
+	switch Output {
+	case "json":
+		return renderJSON(tableRecords(headers, rows))
+	case "csv":
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		w.Write(headers)
+		for _, row := range rows {
+			record := make([]string, len(row))
+			for i, cell := range row {
+				record[i] = unquote(cell)
+			}
+			w.Write(record)
+		}
+		w.Flush()
+		return buf.String()
+	case "table":
+		var buf bytes.Buffer
+		w := tabwriter.NewWriter(&buf, 0, 0, 2, ' ', 0)
+		labels := make([]string, len(headers))
+		for i, header := range headers {
+			labels[i] = strings.ToUpper(strings.ReplaceAll(header, "_", " "))
+		}
+		fmt.Fprintln(w, strings.Join(labels, "\t"))
+		for _, row := range rows {
+			record := make([]string, len(row))
+			for i, cell := range row {
+				record[i] = unquote(cell)
+			}
+			fmt.Fprintln(w, strings.Join(record, "\t"))
+		}
+		w.Flush()
+		return buf.String()
+	default:
+		var output strings.Builder
+		for _, row := range rows {
+			cells := make([]string, 0, len(row))
+			for _, cell := range row {
+				if cell != "" {
+					cells = append(cells, cell)
+				}
+			}
+			output.WriteString(strings.Join(cells, " ") + "\n")
+		}
+		return output.String()
+	}
+}
+
+// renderPage renders a page of a listing with a header counting every matching
+// entry and, when more entries follow, the cursor of the next page
+func renderPage(headers []string, rows [][]string, total int, start int, nextCursor string) string {
+	switch Output {
+	case "json":
+		return renderJSON(struct {
+			Total      int                 `json:"total"`
+			Offset     int                 `json:"offset"`
+			NextCursor string              `json:"next_cursor,omitempty"`
+			Items      []map[string]string `json:"items"`
+		}{total, start, nextCursor, tableRecords(headers, rows)})
+	case "csv":
+		fmt.Fprint(PageOutput, pageSummary(len(rows), total, start, nextCursor))
+		return renderTable(headers, rows)
+	}
+
+	summary, cursor, _ := strings.Cut(pageSummary(len(rows), total, start, nextCursor), "\n")
+	return summary + "\n" + renderTable(headers, rows) + cursor
+}
+
+// pageSummary is the "Total" line of a page, followed by the next cursor
+// line when more entries follow
+func pageSummary(count int, total int, start int, nextCursor string) string {
+	summary := fmt.Sprintf("Total %d, showing none\n", total)
+	if count > 0 {
+		summary = fmt.Sprintf("Total %d, showing %d-%d\n", total, start+1, start+count)
+	}
+	if nextCursor != "" {
+		summary += fmt.Sprintf("Next cursor: %s\n", nextCursor)
+	}
+	return summary
+}
+
+// tableRecords turns rows into objects keyed by their headers
+func tableRecords(headers []string, rows [][]string) []map[string]string {
+	records := make([]map[string]string, 0, len(rows))
+	for _, row := range rows {
+		record := make(map[string]string, len(headers))
+		for i, header := range headers {
+			record[header] = unquote(row[i])
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func renderJSON(v any) string {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Sprintf("{\"status\":\"error\",\"error\":%q}\n", err.Error())
+	}
+	return string(data) + "\n"
+}
+
+// unquote strips the quotes kept around names and descriptions containing
+// whitespace, structured formats already delimit their values
+func unquote(str string) string {
+	if len(str) >= 2 && (str[0] == '"' || str[0] == '\'') && str[len(str)-1] == str[0] {
+		return str[1 : len(str)-1]
+	}
+	return str
+}
@@ -0,0 +1,234 @@
+package commands
+
+import (
+	"fmt"
+	"repl-cli-iscoollab/internal/user"
+	"repl-cli-iscoollab/internal/utils"
+	"strconv"
+	"strings"
+)
+
+func getFile(username string, folderName string, fileName string) (*user.File, error) {
+	u, err := user.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+
+	folder, err := u.GetFolder(folderName)
+	if err != nil {
+		return nil, err
+	}
+
+	return folder.GetFile(fileName)
+}
+
+func parseRevision(value string) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		return 0, user.Errorf(user.UsageError, "the %s is not a valid revision", value)
+	}
+	return number, nil
+}
+
+// WriteFile replaces the content of a file, the old content stays as a revision
+func WriteFile(args []string) (string, error) {
+	if len(args) != 4 {
+		return "", user.Usage("write-file")
+	}
+
+	username := args[0]
+	folderName := args[1]
+	fileName := args[2]
+	content := unquote(args[3])
+
+	user, err := user.GetUser(username)
+	if err != nil {
+		return "", err
+	}
+
+	folder, err := user.GetFolder(folderName)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := folder.GetFile(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	file.Write(content, Actor)
+
+	return renderMessage(fmt.Sprintf("Write %d bytes to %s in %s/%s successfully", file.Size(), fileName, username, folderName)), nil
+}
+
+// Cat prints the content of a file, or of one of its revisions with --rev,
+// as it is now or as it was in a snapshot with --snapshot
+func Cat(args []string) (string, error) {
+	args, revFlag, atRevision := extractFlag(args, "--rev")
+	args, snapshotName, fromSnapshot := extractFlag(args, "--snapshot")
+	if len(args) != 3 || (fromSnapshot && snapshotName == "") {
+		return "", user.Usage("cat")
+	}
+
+	username := args[0]
+	folderName := args[1]
+	fileName := args[2]
+
+	folder, err := lookupFolder(username, folderName, snapshotName, fromSnapshot)
+	if err != nil {
+		return "", err
+	}
+
+	file, err := folder.GetFile(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	var content string
+	if atRevision {
+		number, err := parseRevision(revFlag)
+		if err != nil {
+			return "", err
+		}
+		revision, err := file.Revision(number)
+		if err != nil {
+			return "", err
+		}
+		content = revision.Content
+	} else if fromSnapshot {
+		// Reading a snapshot doesn't record an access
+		content = file.Content
+	} else {
+		content = file.Read()
+	}
+	if Output != "plain" {
+		return renderTable([]string{"name", "content"}, [][]string{{fileName, content}}), nil
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+
+	return content, nil
+}
+
+// FileHistory lists the retained revisions of a file, newest first
+func FileHistory(args []string) (string, error) {
+	if len(args) != 3 {
+		return "", user.Usage("file-history")
+	}
+
+	file, err := getFile(args[0], args[1], args[2])
+	if err != nil {
+		return "", err
+	}
+
+	rows := make([][]string, 0, len(file.Revisions))
+	for i := len(file.Revisions) - 1; i >= 0; i-- {
+		revision := file.Revisions[i]
+		rows = append(rows, []string{
+			strconv.Itoa(revision.Number),
+			revision.Author,
+			formatTime(revision.CreatedAt),
+			strconv.Itoa(len(revision.Content)),
+		})
+	}
+
+	return renderTable([]string{"revision", "author", "created_at", "size"}, rows), nil
+}
+
+// DiffFile prints a unified diff between two revisions of a file, the
+// second defaulting to the latest
+func DiffFile(args []string) (string, error) {
+	if len(args) < 4 || len(args) > 5 {
+		return "", user.Usage("diff-file")
+	}
+
+	file, err := getFile(args[0], args[1], args[2])
+	if err != nil {
+		return "", err
+	}
+
+	numbers := make([]int, 0, 2)
+	for _, arg := range args[3:] {
+		number, err := parseRevision(arg)
+		if err != nil {
+			return "", err
+		}
+		numbers = append(numbers, number)
+	}
+	if len(numbers) == 1 {
+		if len(file.Revisions) == 0 {
+			return "", user.Errorf(user.NotFound, "the %s has no revisions", file.Name)
+		}
+		numbers = append(numbers, file.Revisions[len(file.Revisions)-1].Number)
+	}
+
+	from, err := file.Revision(numbers[0])
+	if err != nil {
+		return "", err
+	}
+	to, err := file.Revision(numbers[1])
+	if err != nil {
+		return "", err
+	}
+
+	diff := utils.UnifiedDiff(
+		fmt.Sprintf("%s@%d", file.Name, from.Number),
+		fmt.Sprintf("%s@%d", file.Name, to.Number),
+		from.Content,
+		to.Content,
+	)
+	if Output == "plain" {
+		return diff, nil
+	}
+	return renderTable([]string{"from", "to", "diff"}, [][]string{{strconv.Itoa(from.Number), strconv.Itoa(to.Number), diff}}), nil
+}
+
+// RevertFile restores the content of an earlier revision as a new revision
+func RevertFile(args []string) (string, error) {
+	if len(args) != 4 {
+		return "", user.Usage("revert-file")
+	}
+
+	file, err := getFile(args[0], args[1], args[2])
+	if err != nil {
+		return "", err
+	}
+
+	number, err := parseRevision(args[3])
+	if err != nil {
+		return "", err
+	}
+
+	revision, err := file.Revert(number, Actor)
+	if err != nil {
+		return "", err
+	}
+
+	return renderMessage(fmt.Sprintf("Revert %s to revision %d as revision %d successfully", file.Name, number, revision.Number)), nil
+}
+
+// SetRetention changes how many revisions a file keeps, 0 restores the default
+func SetRetention(args []string) (string, error) {
+	if len(args) != 4 {
+		return "", user.Usage("set-retention")
+	}
+
+	file, err := getFile(args[0], args[1], args[2])
+	if err != nil {
+		return "", err
+	}
+
+	count, err := strconv.Atoi(args[3])
+	if err != nil {
+		return "", user.Errorf(user.UsageError, "the %s is not a valid retention", args[3])
+	}
+	if err := file.SetRetention(count); err != nil {
+		return "", err
+	}
+	if count == 0 {
+		count = user.RevisionRetention
+	}
+
+	return renderMessage(fmt.Sprintf("Keep %d revisions of %s successfully", count, file.Name)), nil
+}
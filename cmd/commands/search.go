@@ -0,0 +1,56 @@
+package commands
+
+import (
+	"fmt"
+	"repl-cli-iscoollab/internal/user"
+	"strconv"
+	"strings"
+)
+
+// Search runs a full-text query over names, descriptions and file contents
+// of one user, or of every user with --all-users for admins, best matches first
+func Search(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", user.Usage("search")
+	}
+
+	var u *user.User
+	if args[0] == "--all-users" {
+		if err := requireAdmin("--all-users"); err != nil {
+			return "", err
+		}
+	} else {
+		var err error
+		u, err = user.GetUser(args[0])
+		if err != nil {
+			return "", err
+		}
+	}
+
+	results, err := user.Search(u, strings.Join(args[1:], " "))
+	if err != nil {
+		return "", err
+	}
+
+	rows := make([][]string, 0, len(results))
+	for _, result := range results {
+		kind, description := "folder", result.Folder.Description
+		if result.File != nil {
+			kind, description = "file", result.File.Description
+		}
+		rows = append(rows, []string{result.Path(), kind, strconv.FormatFloat(result.Score, 'f', 3, 64), description})
+	}
+
+	return renderTable([]string{"path", "type", "score", "description"}, rows), nil
+}
+
+// Reindex rebuilds the search index from scratch
+func Reindex(args []string) (string, error) {
+	if len(args) != 0 {
+		return "", user.Usage("reindex")
+	}
+
+	documents := user.Reindex()
+
+	return renderMessage(fmt.Sprintf("Reindex %d folders and files successfully", documents)), nil
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"fmt"
+	"repl-cli-iscoollab/internal/user"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Snapshot creates, lists, restores and deletes the snapshots of a user
+func Snapshot(args []string) (string, error) {
+	if len(args) < 2 {
+		return "", user.Usage("snapshot")
+	}
+
+	action := strings.ToLower(args[0])
+	switch {
+	case action == "list" && len(args) == 2:
+	case slices.Contains([]string{"create", "restore", "delete"}, action) && len(args) == 3:
+	default:
+		return "", user.Usage("snapshot")
+	}
+
+	u, err := user.GetUser(args[1])
+	if err != nil {
+		return "", err
+	}
+
+	switch action {
+	case "create":
+		snapshot, err := u.CreateSnapshot(args[2])
+		if err != nil {
+			return "", err
+		}
+		return renderMessage(fmt.Sprintf("Create snapshot %s of %s successfully", snapshot.Name, u.Username)), nil
+	case "restore":
+		if err := u.RestoreSnapshot(args[2]); err != nil {
+			return "", err
+		}
+		return renderMessage(fmt.Sprintf("Restore %s from snapshot %s successfully", u.Username, args[2])), nil
+	case "delete":
+		if err := u.DeleteSnapshot(args[2]); err != nil {
+			return "", err
+		}
+		return renderMessage(fmt.Sprintf("Delete snapshot %s successfully", args[2])), nil
+	}
+
+	snapshots := u.ListSnapshots()
+	rows := make([][]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		rows = append(rows, []string{snapshot.Name, formatTime(snapshot.CreatedAt), strconv.Itoa(len(snapshot.Folders)), strconv.Itoa(snapshot.FileCount())})
+	}
+	return renderTable([]string{"name", "created_at", "folders", "files"}, rows), nil
+}
+
+// ReadOnly rejects a mutating command aimed at a snapshot with --snapshot,
+// snapshots only change through snapshot restore and delete
+func ReadOnly(command string, args []string) error {
+	if !MutatingCommands[command] {
+		return nil
+	}
+	if _, name, found := extractFlag(args, "--snapshot"); found {
+		return user.Errorf(user.PermissionDenied, "the snapshot %s is read-only", name)
+	}
+	return nil
+}
+
+// lookupFolder finds a folder of the user, in the named snapshot when fromSnapshot is set
+func lookupFolder(username string, folderName string, snapshotName string, fromSnapshot bool) (*user.Folder, error) {
+	u, err := user.GetUser(username)
+	if err != nil {
+		return nil, err
+	}
+	if !fromSnapshot {
+		return u.GetFolder(folderName)
+	}
+
+	snapshot, err := u.GetSnapshot(snapshotName)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.GetFolder(folderName)
+}
+
+func listFilesPage(u *user.User, folderName string, snapshotName string, fromSnapshot bool, opts user.ListOptions) (user.Page[*user.File], error) {
+	if fromSnapshot {
+		snapshot, err := u.GetSnapshot(snapshotName)
+		if err != nil {
+			return user.Page[*user.File]{}, err
+		}
+		return snapshot.ListFilesPage(folderName, opts)
+	}
+
+	folder, err := u.GetFolder(folderName)
+	if err != nil {
+		return user.Page[*user.File]{}, err
+	}
+	return folder.ListFilesPage(opts)
+}
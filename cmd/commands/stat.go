@@ -0,0 +1,187 @@
+package commands
+
+import (
+	"fmt"
+	"repl-cli-iscoollab/internal/user"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// aclEntry grants access to a principal, r to read and w to change
+type aclEntry struct {
+	Principal string `json:"principal"`
+	Access    string `json:"access"`
+}
+
+// accessList is who can reach the entries of u. Nothing can be shared, so
+// the owner is the only one changing them, and the admins read them
+// through find and search --all-users
+func accessList(u *user.User) []aclEntry {
+	acl := []aclEntry{{Principal: u.Username, Access: "rw"}}
+	admins := make([]string, 0, len(Admins))
+	for admin := range Admins {
+		if !strings.EqualFold(admin, u.Username) {
+			admins = append(admins, admin)
+		}
+	}
+	slices.Sort(admins)
+	for _, admin := range admins {
+		acl = append(acl, aclEntry{Principal: admin, Access: "r"})
+	}
+	return acl
+}
+
+type statInfo struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Path        string            `json:"path"`
+	Owner       string            `json:"owner"`
+	Description string            `json:"description"`
+	CreatedAt   string            `json:"created_at"`
+	ModifiedAt  string            `json:"modified_at"`
+	AccessedAt  string            `json:"accessed_at"`
+	Size        int               `json:"size"`
+	Folders     *int              `json:"folders,omitempty"`
+	Files       *int              `json:"files,omitempty"`
+	Tags        []string          `json:"tags"`
+	Attributes  map[string]string `json:"attributes"`
+	Revisions   *int              `json:"revisions,omitempty"`
+	Hash        string            `json:"hash,omitempty"`
+	ACL         []aclEntry        `json:"acl"`
+
+	// attrs holds Attributes sorted by key for the human output
+	attrs [][2]string
+}
+
+// Stat prints every field of a user, folder or file addressed as
+// user[/folder[/file]], reading it records no access
+func Stat(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", user.Usage("stat")
+	}
+	parts := strings.SplitN(args[0], "/", 3)
+	for _, part := range parts {
+		if part == "" {
+			return "", user.Usage("stat")
+		}
+	}
+
+	u, err := user.GetUser(parts[0])
+	if err != nil {
+		return "", err
+	}
+	info := statInfo{
+		Owner:      u.Username,
+		Tags:       []string{},
+		Attributes: map[string]string{},
+		ACL:        accessList(u),
+	}
+
+	switch len(parts) {
+	case 1:
+		folders, files, size := len(u.Folders), 0, 0
+		for _, folder := range u.Folders {
+			files += len(folder.Files)
+			size += folder.Size()
+		}
+		info.Name, info.Type, info.Path = u.Username, "user", u.Username
+		info.CreatedAt, info.ModifiedAt, info.AccessedAt = formatTime(u.CreatedAt), formatTime(u.ModifiedAt), formatTime(u.AccessedAt)
+		info.Size, info.Folders, info.Files = size, &folders, &files
+	case 2:
+		folder, err := u.GetFolder(parts[1])
+		if err != nil {
+			return "", err
+		}
+		files := len(folder.Files)
+		info.Name, info.Type, info.Path = folder.Name, "folder", u.Username+"/"+folder.Name
+		info.Description = unquote(folder.Description)
+		info.CreatedAt, info.ModifiedAt, info.AccessedAt = formatTime(folder.CreatedAt), formatTime(folder.ModifiedAt), formatTime(folder.AccessedAt)
+		info.Size, info.Files = folder.Size(), &files
+		info.Tags = append(info.Tags, folder.Tags...)
+		info.attrs = folder.ListAttrs()
+		for _, pair := range info.attrs {
+			info.Attributes[pair[0]] = pair[1]
+		}
+	default:
+		folder, err := u.GetFolder(parts[1])
+		if err != nil {
+			return "", err
+		}
+		file, err := folder.GetFile(parts[2])
+		if err != nil {
+			return "", err
+		}
+		revisions := len(file.Revisions)
+		info.Name, info.Type, info.Path = file.Name, "file", u.Username+"/"+folder.Name+"/"+file.Name
+		info.Description = unquote(file.Description)
+		info.CreatedAt, info.ModifiedAt, info.AccessedAt = formatTime(file.CreatedAt), formatTime(file.ModifiedAt), formatTime(file.AccessedAt)
+		info.Size, info.Revisions, info.Hash = file.Size(), &revisions, file.ContentHash()
+		info.Tags = append(info.Tags, file.Tags...)
+		info.attrs = file.ListAttrs()
+		for _, pair := range info.attrs {
+			info.Attributes[pair[0]] = pair[1]
+		}
+	}
+
+	if Output == "json" {
+		return renderJSON(info), nil
+	}
+	return renderStat(info), nil
+}
+
+// renderStat lists the fields one per line, a field without a value shows
+// as "-" so every line has both columns
+func renderStat(info statInfo) string {
+	attributes := make([]string, 0, len(info.attrs))
+	for _, pair := range info.attrs {
+		attributes = append(attributes, pair[0]+"="+pair[1])
+	}
+	acl := make([]string, 0, len(info.ACL))
+	for _, entry := range info.ACL {
+		acl = append(acl, entry.Principal+":"+entry.Access)
+	}
+
+	rows := [][]string{
+		{"name", info.Name},
+		{"type", info.Type},
+		{"path", info.Path},
+		{"owner", info.Owner},
+		{"description", info.Description},
+		{"created_at", info.CreatedAt},
+		{"modified_at", info.ModifiedAt},
+		{"accessed_at", info.AccessedAt},
+		{"size", plural(info.Size, "byte")},
+	}
+	if info.Folders != nil {
+		rows = append(rows, []string{"folders", strconv.Itoa(*info.Folders)})
+	}
+	if info.Files != nil {
+		rows = append(rows, []string{"files", strconv.Itoa(*info.Files)})
+	}
+	rows = append(rows,
+		[]string{"tags", strings.Join(info.Tags, ", ")},
+		[]string{"attributes", strings.Join(attributes, ", ")},
+	)
+	if info.Revisions != nil {
+		rows = append(rows, []string{"revisions", strconv.Itoa(*info.Revisions)})
+	}
+	if info.Hash != "" {
+		rows = append(rows, []string{"hash", "sha256:" + info.Hash})
+	}
+	rows = append(rows, []string{"acl", strings.Join(acl, ", ")})
+
+	if Output != "plain" {
+		return renderTable([]string{"field", "value"}, rows)
+	}
+
+	var output strings.Builder
+	for _, row := range rows {
+		value := row[1]
+		if value == "" {
+			value = "-"
+		}
+		output.WriteString(fmt.Sprintf("%-12s %s\n", row[0]+":", value))
+	}
+	return output.String()
+}
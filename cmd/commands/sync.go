@@ -0,0 +1,634 @@
+package commands
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"repl-cli-iscoollab/internal/config"
+	"repl-cli-iscoollab/internal/user"
+	"repl-cli-iscoollab/internal/utils"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncStateName is the file sync keeps in the host directory, recording
+// every file as it was after the last pass so each side's changes and
+// deletions can be told apart
+const SyncStateName = ".vfs-sync.json"
+
+// ConflictPolicies decide which side wins when a file changed on both sides
+// since the last pass, skip leaves both alone and reports the conflict
+var ConflictPolicies = []string{"skip", "vfs", "host", "newer"}
+
+var (
+	// Session serializes commands with work running in the background, the
+	// REPL holds it with LockSession while a command runs
+	Session sync.Mutex
+
+	// sessionHeld reports whether the REPL holds Session for the running command
+	sessionHeld bool
+
+	// syncing holds the stop function of each watching sync by user/folder
+	syncing = make(map[string]func())
+)
+
+// LockSession takes Session for a command, see outsideSession
+func LockSession() {
+	Session.Lock()
+	sessionHeld = true
+}
+
+func UnlockSession() {
+	sessionHeld = false
+	Session.Unlock()
+}
+
+// outsideSession runs fn with Session released when the running command
+// holds it, so background syncs go on while fn waits for the user
+func outsideSession(fn func()) {
+	if !sessionHeld {
+		fn()
+		return
+	}
+	UnlockSession()
+	defer LockSession()
+	fn()
+}
+
+type syncState struct {
+	User   string `json:"user"`
+	Folder string `json:"folder"`
+	// Files is keyed by the lowercased file name
+	Files map[string]syncedFile `json:"files"`
+}
+
+// syncedFile is a file as both sides agreed on it after a pass, the host
+// modification time and size spare hashing files that weren't touched
+type syncedFile struct {
+	Hash    string    `json:"hash"`
+	ModTime time.Time `json:"mod_time"`
+	Size    int64     `json:"size"`
+}
+
+// hostFile is a file found in the host directory, content is read only
+// once it is needed
+type hostFile struct {
+	name    string
+	path    string
+	modTime time.Time
+	size    int64
+	hash    string
+	content []byte
+}
+
+func (h *hostFile) read() error {
+	if h.content != nil {
+		return nil
+	}
+	content, err := os.ReadFile(h.path)
+	if err != nil {
+		return err
+	}
+	h.content, h.hash = content, user.HashContent(string(content))
+	return nil
+}
+
+// syncReport is what a pass did, created, updated and deleted count the
+// VFS side first and the host second
+type syncReport struct {
+	rows      [][]string
+	created   [2]int
+	updated   [2]int
+	deleted   [2]int
+	conflicts int
+}
+
+func (r *syncReport) add(action string, side int, name string, note string) {
+	r.rows = append(r.rows, []string{action, []string{"vfs", "host"}[side], name, note})
+	switch action {
+	case "created":
+		r.created[side]++
+	case "updated":
+		r.updated[side]++
+	case "deleted":
+		r.deleted[side]++
+	}
+}
+
+func (r *syncReport) changed() bool {
+	return r.created != [2]int{} || r.updated != [2]int{} || r.deleted != [2]int{} || r.conflicts > 0
+}
+
+// vfsChanged reports whether the pass changed files in the VFS
+func (r *syncReport) vfsChanged() bool {
+	return r.created[vfsSide] > 0 || r.updated[vfsSide] > 0 || r.deleted[vfsSide] > 0
+}
+
+const (
+	vfsSide = iota
+	hostSide
+)
+
+// Sync makes a folder and a host directory hold the same files, copying
+// what changed on either side since the last pass to the other, and with
+// --watch keeps doing so every --interval until unsync
+func Sync(args []string) (string, error) {
+	args, policy, found := extractFlag(args, "--conflict")
+	if !found {
+		policy = "skip"
+	}
+	args, every, found := extractFlag(args, "--interval")
+	if !found {
+		every = "2s"
+	}
+	watch := false
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "--watch" {
+			watch = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	args = rest
+
+	if len(args) != 2 {
+		return "", user.Usage("sync")
+	}
+	policy = strings.ToLower(policy)
+	if !slices.Contains(ConflictPolicies, policy) {
+		return "", user.Errorf(user.UsageError, "the %s is not a conflict policy, use one of %s", policy, strings.Join(ConflictPolicies, ", "))
+	}
+	interval, err := time.ParseDuration(every)
+	if err != nil || interval <= 0 {
+		return "", user.Errorf(user.UsageError, "the %s is not a valid interval, use a duration like 500ms or 2s", every)
+	}
+
+	u, folder, err := syncTarget(args[0])
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Clean(unquote(args[1]))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+
+	report, err := syncPass(u, folder.Name, dir, policy)
+	if err != nil {
+		return "", err
+	}
+	output := renderSync(u.Username+"/"+folder.Name, dir, report)
+	if !watch {
+		return output, nil
+	}
+
+	key := strings.ToLower(u.Username + "/" + folder.Name)
+	if stop, exists := syncing[key]; exists {
+		stop()
+	}
+	syncing[key] = watchSync(key, u, folder, dir, policy, interval)
+
+	message := fmt.Sprintf("Syncing %s/%s with %s every %s, use unsync to stop", u.Username, folder.Name, dir, interval)
+	if Output != "plain" {
+		return renderMessage(message), nil
+	}
+	return output + message + "\n", nil
+}
+
+// Unsync stops the watching sync of a folder
+func Unsync(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", user.Usage("unsync")
+	}
+
+	u, folder, err := syncTarget(args[0])
+	if err != nil {
+		return "", err
+	}
+	key := strings.ToLower(u.Username + "/" + folder.Name)
+	stop, exists := syncing[key]
+	if !exists {
+		return "", user.Errorf(user.NotFound, "the %s/%s is not being synced", u.Username, folder.Name)
+	}
+
+	stop()
+	delete(syncing, key)
+	return renderMessage(fmt.Sprintf("Stopped syncing %s/%s", u.Username, folder.Name)), nil
+}
+
+func syncTarget(target string) (*user.User, *user.Folder, error) {
+	username, folderName, found := strings.Cut(target, "/")
+	if !found || username == "" || folderName == "" {
+		return nil, nil, user.Usage("sync")
+	}
+	u, err := user.GetUser(username)
+	if err != nil {
+		return nil, nil, err
+	}
+	folder, err := u.GetFolder(folderName)
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, folder, nil
+}
+
+// watchSync runs a pass every interval under Session until the returned
+// function is called, printing the passes that changed something, and the
+// errors of failing ones, to WatchOutput. No command follows a background
+// pass, so the pass saves the data file and records itself in the audit
+// log the way the REPL does after a command. Once the user or folder is
+// gone the sync stops itself and leaves syncing, key being its entry there
+func watchSync(key string, u *user.User, folder *user.Folder, dir string, policy string, interval time.Duration) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			if !awaitSession(done) {
+				return
+			}
+			var output string
+			target := u.Username + "/" + folder.Name
+			report, err := syncPass(u, folder.Name, dir, policy)
+			switch {
+			case err != nil:
+				output = RenderError(err)
+			case report.changed():
+				output = renderSync(target, dir, report)
+				if report.vfsChanged() && config.Current.DataFile != "" {
+					if err = user.Save(config.Current.DataFile); err != nil {
+						output += RenderError(err)
+					}
+				}
+			}
+			if err != nil || report.changed() {
+				if auditErr := Record("sync", []string{target, dir, "--watch"}, err); auditErr != nil {
+					output += fmt.Sprintf("Error: the audit log can't be written: %s\n", auditErr)
+				}
+			}
+			gone := errors.Is(err, user.NotFound)
+			if gone {
+				delete(syncing, key)
+				output += fmt.Sprintf("Stopped syncing %s\n", target)
+			}
+			Session.Unlock()
+			fmt.Fprint(WatchOutput, output)
+			if gone {
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// awaitSession takes Session unless done is closed first, so stopping a sync
+// from a command holding the session doesn't wait for itself
+func awaitSession(done chan struct{}) bool {
+	for !Session.TryLock() {
+		select {
+		case <-done:
+			return false
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	select {
+	case <-done:
+		Session.Unlock()
+		return false
+	default:
+		return true
+	}
+}
+
+// syncPass compares the folder, the host directory and the state of the
+// last pass, and copies every change to the other side
+func syncPass(u *user.User, folderName string, dir string, policy string) (*syncReport, error) {
+	folder, err := u.GetFolder(folderName)
+	if err != nil {
+		return nil, err
+	}
+	state, err := loadSyncState(dir, u.Username, folder.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &syncReport{}
+	hostFiles, err := listHostFiles(dir, report)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(hostFiles)+len(folder.Files)+len(state.Files))
+	for key := range hostFiles {
+		keys = append(keys, key)
+	}
+	for _, file := range folder.Files {
+		keys = append(keys, strings.ToLower(file.Name))
+	}
+	for key := range state.Files {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	keys = slices.Compact(keys)
+
+	for _, key := range keys {
+		host := hostFiles[key]
+		var file *user.File
+		if host != nil {
+			file, _ = folder.GetFile(host.name)
+		}
+		if file == nil {
+			file = folderFile(folder, key)
+		}
+		if err := syncFile(folder, dir, state, key, file, host, policy, report); err != nil {
+			return nil, err
+		}
+	}
+
+	return report, saveSyncState(dir, state)
+}
+
+// folderFile finds a file by its lowercased name
+func folderFile(folder *user.Folder, key string) *user.File {
+	for _, file := range folder.Files {
+		if strings.ToLower(file.Name) == key {
+			return file
+		}
+	}
+	return nil
+}
+
+// syncFile brings one file in line on both sides and records the result
+func syncFile(folder *user.Folder, dir string, state *syncState, key string, file *user.File, host *hostFile, policy string, report *syncReport) error {
+	base, synced := state.Files[key]
+
+	var vfsHash string
+	vfsChanged := false
+	if file != nil {
+		vfsHash = file.ContentHash()
+		vfsChanged = !synced || vfsHash != base.Hash
+	}
+	hostChanged := false
+	if host != nil {
+		// An untouched modification time and size mean an unchanged file
+		if !synced || !host.modTime.Equal(base.ModTime) || host.size != base.Size {
+			if err := host.read(); err != nil {
+				return err
+			}
+			hostChanged = !synced || host.hash != base.Hash
+		}
+	}
+
+	switch {
+	case file == nil && host == nil:
+		delete(state.Files, key)
+		return nil
+	case file != nil && host != nil && host.hash == vfsHash && (vfsChanged || hostChanged):
+		// Both sides made the same change
+		return recordSynced(state, key, host)
+	case file == nil && host != nil && !synced:
+		return copyToVFS(folder, state, key, host, report, "created")
+	case file != nil && host == nil && !synced:
+		return copyToHost(dir, state, key, file, report, "created")
+	case !vfsChanged && !hostChanged:
+		switch {
+		case file == nil:
+			return deleteOnHost(state, key, host, report)
+		case host == nil:
+			return deleteInVFS(folder, state, key, file, report)
+		case host.content != nil:
+			// Touched without changing, remember the new time to skip hashing it
+			return recordSynced(state, key, host)
+		}
+		return nil
+	case !vfsChanged && file != nil:
+		return copyToVFS(folder, state, key, host, report, "updated")
+	case !hostChanged && host != nil:
+		return copyToHost(dir, state, key, file, report, "updated")
+	}
+
+	// Both sides changed since the last pass, a deleted side counts as
+	// changed so the changed content isn't lost silently
+	winner := resolveConflict(policy, file, host)
+	name := key
+	if file != nil {
+		name = file.Name
+	} else if host != nil {
+		name = host.name
+	}
+	switch winner {
+	case vfsSide:
+		if file == nil {
+			return deleteOnHost(state, key, host, report)
+		}
+		return copyToHost(dir, state, key, file, report, "updated")
+	case hostSide:
+		if host == nil {
+			return deleteInVFS(folder, state, key, file, report)
+		}
+		return copyToVFS(folder, state, key, host, report, "updated")
+	}
+
+	report.conflicts++
+	report.rows = append(report.rows, []string{"conflict", "", name, "changed on both sides, use --conflict vfs, host or newer to resolve it"})
+	return nil
+}
+
+// resolveConflict returns the side that wins under policy, or -1 to leave
+// both sides alone. Under newer a side holding the file beats one that
+// deleted it
+func resolveConflict(policy string, file *user.File, host *hostFile) int {
+	switch policy {
+	case "vfs":
+		return vfsSide
+	case "host":
+		return hostSide
+	case "newer":
+		if file == nil {
+			return hostSide
+		}
+		if host == nil || !host.modTime.After(file.ModifiedAt) {
+			return vfsSide
+		}
+		return hostSide
+	}
+	return -1
+}
+
+func copyToVFS(folder *user.Folder, state *syncState, key string, host *hostFile, report *syncReport, action string) error {
+	if err := host.read(); err != nil {
+		return err
+	}
+
+	file := folderFile(folder, key)
+	if file == nil {
+		if err := folder.CreateFile(host.name, ""); err != nil {
+			report.rows = append(report.rows, []string{"skip", "vfs", host.name, err.Error()})
+			return nil
+		}
+		file = folderFile(folder, key)
+		action = "created"
+	}
+	file.Write(string(host.content), Actor)
+
+	report.add(action, vfsSide, file.Name, plural(len(host.content), "byte"))
+	return recordSynced(state, key, host)
+}
+
+func copyToHost(dir string, state *syncState, key string, file *user.File, report *syncReport, action string) error {
+	path := filepath.Join(dir, file.Name)
+	content := []byte(file.Content)
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		return err
+	}
+	if err := os.Chtimes(path, file.ModifiedAt, file.ModifiedAt); err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	report.add(action, hostSide, file.Name, plural(len(content), "byte"))
+	return recordSynced(state, key, &hostFile{name: file.Name, path: path, modTime: info.ModTime(), size: info.Size(), hash: user.HashContent(string(content)), content: content})
+}
+
+func deleteInVFS(folder *user.Folder, state *syncState, key string, file *user.File, report *syncReport) error {
+	if err := folder.DeleteFile(file.Name); err != nil {
+		return err
+	}
+	report.add("deleted", vfsSide, file.Name, "")
+	delete(state.Files, key)
+	return nil
+}
+
+func deleteOnHost(state *syncState, key string, host *hostFile, report *syncReport) error {
+	if err := os.Remove(host.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	report.add("deleted", hostSide, host.name, "")
+	delete(state.Files, key)
+	return nil
+}
+
+func recordSynced(state *syncState, key string, host *hostFile) error {
+	if err := host.read(); err != nil {
+		return err
+	}
+	state.Files[key] = syncedFile{Hash: host.hash, ModTime: host.modTime, Size: host.size}
+	return nil
+}
+
+// listHostFiles returns the regular files directly in dir by their
+// lowercased name, hidden files and names the VFS can't hold are reported
+// and left alone
+func listHostFiles(dir string, report *syncReport) (map[string]*hostFile, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string]*hostFile)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") || !entry.Type().IsRegular() {
+			continue
+		}
+		normalized, err := utils.Policy.Normalize(name)
+		if err != nil || utils.Policy.Length(name) > user.MaxFileNameLength {
+			report.rows = append(report.rows, []string{"skip", "host", name, "not a valid file name"})
+			continue
+		}
+		key := strings.ToLower(normalized)
+		if other, exists := files[key]; exists {
+			report.rows = append(report.rows, []string{"skip", "host", name, "the name is taken by " + other.name})
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		files[key] = &hostFile{name: name, path: filepath.Join(dir, name), modTime: info.ModTime(), size: info.Size()}
+	}
+	return files, nil
+}
+
+func loadSyncState(dir string, username string, folderName string) (*syncState, error) {
+	state := &syncState{User: username, Folder: folderName, Files: make(map[string]syncedFile)}
+
+	data, err := os.ReadFile(filepath.Join(dir, SyncStateName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved syncState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("the %s is not a valid sync state: %v", filepath.Join(dir, SyncStateName), err)
+	}
+	if !strings.EqualFold(saved.User, username) || !strings.EqualFold(saved.Folder, folderName) {
+		return nil, fmt.Errorf("the %s is synced with %s/%s, remove its %s to sync it with another folder", dir, saved.User, saved.Folder, SyncStateName)
+	}
+	if saved.Files != nil {
+		state.Files = saved.Files
+	}
+	return state, nil
+}
+
+// saveSyncState replaces the state file atomically, so an interrupted pass
+// leaves the previous one
+func saveSyncState(dir string, state *syncState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, SyncStateName+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, SyncStateName))
+}
+
+func renderSync(target string, dir string, report *syncReport) string {
+	output := renderTable([]string{"action", "side", "file", "note"}, report.rows)
+	if Output != "plain" {
+		return output
+	}
+
+	summary := fmt.Sprintf("Sync %s with %s: %d created, %d updated, %d deleted in the VFS, %d created, %d updated, %d deleted on the host, %s",
+		target, dir,
+		report.created[vfsSide], report.updated[vfsSide], report.deleted[vfsSide],
+		report.created[hostSide], report.updated[hostSide], report.deleted[hostSide],
+		plural(report.conflicts, "conflict"))
+	return output + summary + "\n"
+}
@@ -0,0 +1,100 @@
+package commands
+
+import (
+	"fmt"
+	"repl-cli-iscoollab/internal/user"
+	"strings"
+)
+
+func Tag(args []string) (string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return "", user.Usage("tag")
+	}
+
+	username, folderName, fileName, rest := entryArgs(args, 1)
+	tag := strings.ToLower(rest[0])
+
+	u, err := user.GetUser(username)
+	if err != nil {
+		return "", err
+	}
+
+	if fileName == "" {
+		err = u.TagFolder(folderName, tag)
+	} else {
+		err = u.TagFile(folderName, fileName, tag)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return renderMessage(fmt.Sprintf("Tag %s with %s successfully", entryLabel(username, folderName, fileName), tag)), nil
+}
+
+func Untag(args []string) (string, error) {
+	if len(args) != 3 && len(args) != 4 {
+		return "", user.Usage("untag")
+	}
+
+	username, folderName, fileName, rest := entryArgs(args, 1)
+	tag := strings.ToLower(rest[0])
+
+	u, err := user.GetUser(username)
+	if err != nil {
+		return "", err
+	}
+
+	if fileName == "" {
+		err = u.UntagFolder(folderName, tag)
+	} else {
+		err = u.UntagFile(folderName, fileName, tag)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return renderMessage(fmt.Sprintf("Untag %s from %s successfully", tag, entryLabel(username, folderName, fileName))), nil
+}
+
+// ListTags lists every tag of a user with its usage count, or the tags
+// of a single folder or file when those are given
+func ListTags(args []string) (string, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return "", user.Usage("list-tags")
+	}
+
+	username := args[0]
+	u, err := user.GetUser(username)
+	if err != nil {
+		return "", err
+	}
+
+	if len(args) == 1 {
+		tags := u.ListTags()
+		rows := make([][]string, 0, len(tags))
+		for _, tag := range tags {
+			rows = append(rows, []string{tag[0], tag[1]})
+		}
+		return renderTable([]string{"tag", "count"}, rows), nil
+	}
+
+	folder, err := u.GetFolder(args[1])
+	if err != nil {
+		return "", err
+	}
+
+	tags := folder.Tags
+	if len(args) == 3 {
+		file, err := folder.GetFile(args[2])
+		if err != nil {
+			return "", err
+		}
+		tags = file.Tags
+	}
+
+	rows := make([][]string, 0, len(tags))
+	for _, tag := range tags {
+		rows = append(rows, []string{tag})
+	}
+	return renderTable([]string{"tag"}, rows), nil
+}
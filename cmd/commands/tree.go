@@ -0,0 +1,199 @@
+package commands
+
+import (
+	"fmt"
+	"repl-cli-iscoollab/internal/user"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// treeNode is a user, folder or file in the tree, also used for its JSON variant
+type treeNode struct {
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Description string     `json:"description,omitempty"`
+	Size        int        `json:"size"`
+	CreatedAt   string     `json:"created_at"`
+	Children    []treeNode `json:"children,omitempty"`
+}
+
+// treeBranches are the connectors drawn in front of each entry
+type treeBranches struct {
+	middle, last, pipe, space string
+}
+
+var (
+	unicodeBranches = treeBranches{"├── ", "└── ", "│   ", "    "}
+	asciiBranches   = treeBranches{"|-- ", "`-- ", "|   ", "    "}
+)
+
+// Tree prints a user's folders and files, or a single folder's files, as a tree
+func Tree(args []string) (string, error) {
+	args, depthFlag, limitDepth := extractFlag(args, "--depth")
+	columns := make(map[string]bool)
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch arg {
+		case "--description", "--size", "--time", "--ascii":
+			columns[arg] = true
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	args = rest
+
+	if len(args) < 1 || len(args) > 2 {
+		return "", user.Usage("tree")
+	}
+
+	depth := -1
+	if limitDepth {
+		var err error
+		depth, err = strconv.Atoi(depthFlag)
+		if err != nil || depth < 0 {
+			return "", user.Errorf(user.UsageError, "the %s is not a valid depth", depthFlag)
+		}
+	}
+
+	u, err := user.GetUser(args[0])
+	if err != nil {
+		return "", err
+	}
+
+	var root treeNode
+	if len(args) == 2 {
+		folder, err := u.GetFolder(args[1])
+		if err != nil {
+			return "", err
+		}
+		root = folderNode(folder, depth)
+	} else {
+		root = treeNode{Name: u.Username, Type: "user", CreatedAt: formatTime(u.CreatedAt)}
+		if depth != 0 {
+			for _, name := range sortedKeys(u.Folders) {
+				folder := folderNode(u.Folders[name], depth-1)
+				root.Size += folder.Size
+				root.Children = append(root.Children, folder)
+			}
+		}
+	}
+
+	folders, files := countNodes(root)
+
+	switch Output {
+	case "json":
+		unquoteTree(&root)
+		return renderJSON(struct {
+			Tree    treeNode `json:"tree"`
+			Folders int      `json:"folders"`
+			Files   int      `json:"files"`
+		}{root, folders, files}), nil
+	case "csv", "table":
+		var rows [][]string
+		flattenTree(root, "", &rows)
+		return renderTable([]string{"path", "type", "description", "size", "created_at"}, rows), nil
+	}
+
+	branches := unicodeBranches
+	if columns["--ascii"] {
+		branches = asciiBranches
+	}
+
+	var output strings.Builder
+	output.WriteString(treeLine(root, columns) + "\n")
+	drawTree(&output, root.Children, "", branches, columns)
+	output.WriteString(fmt.Sprintf("\n%s, %s\n", plural(folders, "folder"), plural(files, "file")))
+
+	return output.String(), nil
+}
+
+// folderNode builds a folder with its files, depth limits how many levels
+// below the folder are included and is negative for no limit
+func folderNode(folder *user.Folder, depth int) treeNode {
+	node := treeNode{Name: folder.Name, Type: "folder", Description: folder.Description, CreatedAt: formatTime(folder.CreatedAt)}
+	for _, name := range sortedKeys(folder.Files) {
+		file := folder.Files[name]
+		node.Size += file.Size()
+		if depth != 0 {
+			node.Children = append(node.Children, treeNode{Name: file.Name, Type: "file", Description: file.Description, Size: file.Size(), CreatedAt: formatTime(file.CreatedAt)})
+		}
+	}
+	return node
+}
+
+func drawTree(output *strings.Builder, nodes []treeNode, prefix string, branches treeBranches, columns map[string]bool) {
+	for i, node := range nodes {
+		connector, indent := branches.middle, branches.pipe
+		if i == len(nodes)-1 {
+			connector, indent = branches.last, branches.space
+		}
+		output.WriteString(prefix + connector + treeLine(node, columns) + "\n")
+		drawTree(output, node.Children, prefix+indent, branches, columns)
+	}
+}
+
+// treeLine is the name of the node followed by the requested columns
+func treeLine(node treeNode, columns map[string]bool) string {
+	cells := []string{node.Name}
+	if columns["--description"] && node.Description != "" {
+		cells = append(cells, node.Description)
+	}
+	if columns["--size"] {
+		cells = append(cells, plural(node.Size, "byte"))
+	}
+	if columns["--time"] {
+		cells = append(cells, node.CreatedAt)
+	}
+	return strings.Join(cells, "  ")
+}
+
+func flattenTree(node treeNode, parent string, rows *[][]string) {
+	path := node.Name
+	if parent != "" {
+		path = parent + "/" + node.Name
+	}
+	*rows = append(*rows, []string{path, node.Type, node.Description, strconv.Itoa(node.Size), node.CreatedAt})
+	for _, child := range node.Children {
+		flattenTree(child, path, rows)
+	}
+}
+
+// unquoteTree strips the quotes kept around descriptions with whitespace
+func unquoteTree(node *treeNode) {
+	node.Description = unquote(node.Description)
+	for i := range node.Children {
+		unquoteTree(&node.Children[i])
+	}
+}
+
+func countNodes(node treeNode) (int, int) {
+	var folders, files int
+	for _, child := range node.Children {
+		if child.Type == "folder" {
+			folders++
+		} else {
+			files++
+		}
+		childFolders, childFiles := countNodes(child)
+		folders += childFolders
+		files += childFiles
+	}
+	return folders, files
+}
+
+func sortedKeys[T any](entries map[string]T) []string {
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func plural(count int, noun string) string {
+	if count == 1 {
+		return fmt.Sprintf("1 %s", noun)
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
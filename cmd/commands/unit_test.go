@@ -1,12 +1,37 @@
 package commands
 
 import (
+	"archive/tar"
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"repl-cli-iscoollab/internal/audit"
+	"repl-cli-iscoollab/internal/config"
 	"repl-cli-iscoollab/internal/user"
+	"repl-cli-iscoollab/internal/utils"
+	"repl-cli-iscoollab/internal/webhook"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+// testTime is the instant the frozen clock reports for every unit test
+var testTime = time.Date(2023, time.January, 1, 15, 0, 0, 0, time.Local)
+
+func TestMain(m *testing.M) {
+	user.SetClock(user.NewFakeClock(testTime))
+	os.Exit(m.Run())
+}
+
 // Test_Register tests the Register function with various input scenarios.
 // Testing strategy:
 // 1. Test valid registrations (normal, with space, with uppercase)
@@ -21,8 +46,8 @@ func Test_Register(t *testing.T) {
 	}{
 		{"Valid registration", []string{"testuser"}, "Add testuser successfully\n", nil},
 		{"Valid registration with space", []string{`"test user"`}, "Add \"test user\" successfully\n", nil},
-		{"Valid registration with uppercase", []string{"TestUser123"}, "Add testuser123 successfully\n", nil},
-		{"Invalid args count (too many)", []string{"testuser", "extra"}, "", fmt.Errorf(user.CommandsUsage["register"])},
+		{"Valid registration with uppercase", []string{"TestUser123"}, "Add TestUser123 successfully\n", nil},
+		{"Invalid args count (too many)", []string{"testuser", "extra"}, "", user.Usage("register")},
 		{"Empty username", []string{""}, "", fmt.Errorf("the  contain invalid chars")},
 		{"Username with spaces", []string{"test user"}, "", fmt.Errorf("the test user contain invalid chars")},
 		{"Username with special characters", []string{"test@user"}, "", fmt.Errorf("the test@user contain invalid chars")},
@@ -67,8 +92,8 @@ func Test_CreateFolder(t *testing.T) {
 	}{
 		{"Valid folder creation", []string{"testuser", "testfolder", "description"}, "Create testfolder successfully\n", nil},
 		{"Valid folder creation with space description", []string{"testuser", "\"test folder\"", `"This is description"`}, "Create \"test folder\" successfully\n", nil},
-		{"Invalid args count (too few)", []string{"testuser"}, "", fmt.Errorf(user.CommandsUsage["create-folder"])},
-		{"Invalid args count (too many)", []string{"testuser", "testfolder", "description", "extra"}, "", fmt.Errorf(user.CommandsUsage["create-folder"])},
+		{"Invalid args count (too few)", []string{"testuser"}, "", user.Usage("create-folder")},
+		{"Invalid args count (too many)", []string{"testuser", "testfolder", "description", "extra"}, "", user.Usage("create-folder")},
 		{"Empty folder name", []string{"testuser", "", "description"}, "", fmt.Errorf("the  contain invalid chars")},
 		{"Folder name with spaces", []string{"testuser", "test folder", "description"}, "", fmt.Errorf("the test folder contain invalid chars")},
 		{"Folder name with special characters", []string{"testuser", "test@folder", "description"}, "", fmt.Errorf("the test@folder contain invalid chars")},
@@ -119,50 +144,56 @@ func Test_ListFolders(t *testing.T) {
 		{
 			"Valid list folders",
 			[]string{"testuser"},
-			fmt.Sprintf("folder1 description1 %s testuser\nfolder2 description2 %s testuser\n", time.Now().Format("2006-01-02 15:04:05"), time.Now().Format("2006-01-02 15:04:05")),
+			fmt.Sprintf("folder1 description1 %s testuser\nfolder2 description2 %s testuser\n", testTime.Format("2006-01-02 15:04:05"), testTime.Format("2006-01-02 15:04:05")),
 			nil,
 		},
 		{
 			"Valid list folders with sort by name asc",
 			[]string{"testuser", "--sort-name", "asc"},
-			fmt.Sprintf("folder1 description1 %s testuser\nfolder2 description2 %s testuser\n", time.Now().Format("2006-01-02 15:04:05"), time.Now().Format("2006-01-02 15:04:05")),
+			fmt.Sprintf("folder1 description1 %s testuser\nfolder2 description2 %s testuser\n", testTime.Format("2006-01-02 15:04:05"), testTime.Format("2006-01-02 15:04:05")),
 			nil,
 		},
 		{
 			"Valid list folders with sort by name desc",
 			[]string{"testuser", "--sort-name", "desc"},
-			fmt.Sprintf("folder2 description2 %s testuser\nfolder1 description1 %s testuser\n", time.Now().Format("2006-01-02 15:04:05"), time.Now().Format("2006-01-02 15:04:05")),
+			fmt.Sprintf("folder2 description2 %s testuser\nfolder1 description1 %s testuser\n", testTime.Format("2006-01-02 15:04:05"), testTime.Format("2006-01-02 15:04:05")),
 			nil,
 		},
 		{
 			"Valid list folders with sort by created asc",
 			[]string{"testuser", "--sort-created", "asc"},
-			fmt.Sprintf("folder1 description1 %s testuser\nfolder2 description2 %s testuser\n", time.Now().Format("2006-01-02 15:04:05"), time.Now().Format("2006-01-02 15:04:05")),
+			fmt.Sprintf("folder1 description1 %s testuser\nfolder2 description2 %s testuser\n", testTime.Format("2006-01-02 15:04:05"), testTime.Format("2006-01-02 15:04:05")),
 			nil,
 		},
 		{
 			"Valid list folders with sort by created desc",
 			[]string{"testuser", "--sort-created", "desc"},
-			fmt.Sprintf("folder1 description1 %s testuser\nfolder2 description2 %s testuser\n", time.Now().Format("2006-01-02 15:04:05"), time.Now().Format("2006-01-02 15:04:05")),
+			fmt.Sprintf("folder2 description2 %s testuser\nfolder1 description1 %s testuser\n", testTime.Format("2006-01-02 15:04:05"), testTime.Format("2006-01-02 15:04:05")),
+			nil,
+		},
+		{
+			"Valid list folders with sort by modified asc",
+			[]string{"testuser", "--sort-modified", "asc"},
+			fmt.Sprintf("folder1 description1 %s testuser\nfolder2 description2 %s testuser\n", testTime.Format("2006-01-02 15:04:05"), testTime.Format("2006-01-02 15:04:05")),
 			nil,
 		},
 		{
 			"Invalid args count (too few)",
 			[]string{},
 			"",
-			fmt.Errorf(user.CommandsUsage["list-folders"]),
+			user.Usage("list-folders"),
 		},
 		{
 			"Invalid args count (too many)",
 			[]string{"testuser", "--sort-name", "asc", "extra"},
 			"",
-			fmt.Errorf(user.CommandsUsage["list-folders"]),
+			user.Usage("list-folders"),
 		},
 		{
 			"Invalid sort option",
 			[]string{"testuser", "--sort-invalid", "asc"},
 			"",
-			fmt.Errorf(user.CommandsUsage["list-folders"]),
+			user.Usage("list-folders"),
 		},
 		{
 			"Nonexistent user",
@@ -208,8 +239,8 @@ func Test_DeleteFolder(t *testing.T) {
 	}{
 		{"Valid delete folder", []string{"testuser", "testfolder"}, "Delete testfolder successfully\n", nil},
 		{"Valid delete folder with space", []string{"testuser", `"test folder"`}, "Delete \"test folder\" successfully\n", nil},
-		{"Invalid args count (too few)", []string{"testuser"}, "", fmt.Errorf(user.CommandsUsage["delete-folder"])},
-		{"Invalid args count (too many)", []string{"testuser", "testfolder", "extra"}, "", fmt.Errorf(user.CommandsUsage["delete-folder"])},
+		{"Invalid args count (too few)", []string{"testuser"}, "", user.Usage("delete-folder")},
+		{"Invalid args count (too many)", []string{"testuser", "testfolder", "extra"}, "", user.Usage("delete-folder")},
 		{"Nonexistent folder", []string{"testuser", "nonexistentfolder"}, "", fmt.Errorf("the nonexistentfolder doesn't exist")},
 	}
 
@@ -250,8 +281,8 @@ func Test_RenameFolder(t *testing.T) {
 		expectedError  error
 	}{
 		{"Valid rename folder", []string{"testuser", "oldfolder", "newfolder"}, "Rename oldfolder to newfolder successfully\n", nil},
-		{"Invalid args count (too few)", []string{"testuser", "oldfolder"}, "", fmt.Errorf(user.CommandsUsage["rename-folder"])},
-		{"Invalid args count (too many)", []string{"testuser", "oldfolder", "newfolder", "extra"}, "", fmt.Errorf(user.CommandsUsage["rename-folder"])},
+		{"Invalid args count (too few)", []string{"testuser", "oldfolder"}, "", user.Usage("rename-folder")},
+		{"Invalid args count (too many)", []string{"testuser", "oldfolder", "newfolder", "extra"}, "", user.Usage("rename-folder")},
 		{"Empty old folder name", []string{"testuser", "", "newfolder"}, "", fmt.Errorf("the  doesn't exist")},
 		{"Empty new folder name", []string{"testuser", "oldfolder", ""}, "", fmt.Errorf("the oldfolder doesn't exist")},
 		{"New folder name with invalid characters", []string{"testuser", "oldfolder", "new@folder"}, "", fmt.Errorf("the oldfolder doesn't exist")},
@@ -296,8 +327,8 @@ func Test_CreateFile(t *testing.T) {
 		expectedError  error
 	}{
 		{"Valid file creation", []string{"testuser", "testfolder", "testfile", "description"}, "Create testfile in testuser/testfolder successfully\n", nil},
-		{"Invalid args count (too few)", []string{"testuser", "testfolder"}, "", fmt.Errorf(user.CommandsUsage["create-file"])},
-		{"Invalid args count (too many)", []string{"testuser", "testfolder", "testfile", "description", "extra"}, "", fmt.Errorf(user.CommandsUsage["create-file"])},
+		{"Invalid args count (too few)", []string{"testuser", "testfolder"}, "", user.Usage("create-file")},
+		{"Invalid args count (too many)", []string{"testuser", "testfolder", "testfile", "description", "extra"}, "", user.Usage("create-file")},
 		{"Empty file name", []string{"testuser", "testfolder", "", "description"}, "", fmt.Errorf("the  contain invalid chars")},
 		{"File name with invalid characters", []string{"testuser", "testfolder", "test@file", "description"}, "", fmt.Errorf("the test@file contain invalid chars")},
 		{"File name too long", []string{"testuser", "testfolder", string(make([]byte, 256)), "description"}, "", fmt.Errorf("the %s contain invalid chars", string(make([]byte, 256)))},
@@ -337,7 +368,7 @@ func Test_ListFiles(t *testing.T) {
 	CreateFile([]string{"testuser", "testfolder", "file2", "description2"})
 	DeleteFile([]string{"testuser", "testfolder", "testfile"})
 
-	now := time.Now().Format("2006-01-02 15:04:05")
+	now := testTime.Format("2006-01-02 15:04:05")
 	tests := []struct {
 		name           string
 		args           []string
@@ -348,10 +379,10 @@ func Test_ListFiles(t *testing.T) {
 		{"Valid list files with sort by name", []string{"testuser", "testfolder", "--sort-name", "asc"}, fmt.Sprintf("file1 description1 %s testuser\nfile2 description2 %s testuser\n", now, now), nil},
 		{"Valid list files with sort by created", []string{"testuser", "testfolder", "--sort-created", "asc"}, fmt.Sprintf("file1 description1 %s testuser\nfile2 description2 %s testuser\n", now, now), nil},
 		{"Valid list files with sort order desc", []string{"testuser", "testfolder", "--sort-name", "desc"}, fmt.Sprintf("file2 description2 %s testuser\nfile1 description1 %s testuser\n", now, now), nil},
-		{"Invalid args count (too few)", []string{"testuser"}, "", fmt.Errorf(user.CommandsUsage["list-files"])},
-		{"Invalid args count (too many)", []string{"testuser", "testfolder", "--sort-name", "asc", "extra"}, "", fmt.Errorf(user.CommandsUsage["list-files"])},
-		{"Invalid sort option", []string{"testuser", "testfolder", "--sort-invalid", "asc"}, "", fmt.Errorf(user.CommandsUsage["list-files"])},
-		{"Invalid sort order", []string{"testuser", "testfolder", "--sort-name", "invalid"}, "", fmt.Errorf(user.CommandsUsage["list-files"])},
+		{"Invalid args count (too few)", []string{"testuser"}, "", user.Usage("list-files")},
+		{"Invalid args count (too many)", []string{"testuser", "testfolder", "--sort-name", "asc", "extra"}, "", user.Usage("list-files")},
+		{"Invalid sort option", []string{"testuser", "testfolder", "--sort-invalid", "asc"}, "", user.Usage("list-files")},
+		{"Invalid sort order", []string{"testuser", "testfolder", "--sort-name", "invalid"}, "", user.Usage("list-files")},
 	}
 
 	for _, tt := range tests {
@@ -390,8 +421,8 @@ func Test_DeleteFile(t *testing.T) {
 	}{
 		{"Valid delete file", []string{"testuser", "testfolder", "testfile"}, "Deleted file testfile from testuser/testfolder successfully\n", nil},
 		{"Valid delete file with space", []string{"testuser", "testfolder", `"test file"`}, "Deleted file \"test file\" from testuser/testfolder successfully\n", nil},
-		{"Invalid args count (too few)", []string{"testuser", "testfolder"}, "", fmt.Errorf(user.CommandsUsage["delete-file"])},
-		{"Invalid args count (too many)", []string{"testuser", "testfolder", "testfile", "extra"}, "", fmt.Errorf(user.CommandsUsage["delete-file"])},
+		{"Invalid args count (too few)", []string{"testuser", "testfolder"}, "", user.Usage("delete-file")},
+		{"Invalid args count (too many)", []string{"testuser", "testfolder", "testfile", "extra"}, "", user.Usage("delete-file")},
 	}
 
 	for _, tt := range tests {
@@ -417,3 +448,2306 @@ func Test_DeleteFile(t *testing.T) {
 func Test_Help(t *testing.T) {
 	Help()
 }
+
+// Test_Set tests the Set function with various input scenarios.
+// Testing strategy:
+// 1. Test setting every supported output format, time format and time zone
+// 2. Test invalid options, formats, zones and args count
+func Test_Set(t *testing.T) {
+	defer func() {
+		Output = "plain"
+		TimeFormat = time.DateTime
+		TimeZone = time.Local
+	}()
+
+	tests := []struct {
+		name           string
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Valid set output json", []string{"output", "json"}, "{\n  \"status\": \"ok\",\n  \"message\": \"Set output to json successfully\"\n}\n", nil},
+		{"Valid set output csv", []string{"output", "CSV"}, "status,message\nok,Set output to csv successfully\n", nil},
+		{"Valid set output table", []string{"output", "table"}, "STATUS  MESSAGE\nok      Set output to table successfully\n", nil},
+		{"Valid set output plain", []string{"output", "plain"}, "Set output to plain successfully\n", nil},
+		{"Valid set time format", []string{"time-format", "rfc3339"}, "Set time-format to rfc3339 successfully\n", nil},
+		{"Valid set time format layout", []string{"time-format", `"02 Jan 06 15:04 MST"`}, "Set time-format to 02 Jan 06 15:04 MST successfully\n", nil},
+		{"Invalid time format", []string{"time-format", "yesterday"}, "", fmt.Errorf("the yesterday is not a valid time format")},
+		{"Valid set timezone", []string{"timezone", "UTC"}, "Set timezone to UTC successfully\n", nil},
+		{"Invalid timezone", []string{"timezone", "Mars/Olympus"}, "", fmt.Errorf("the Mars/Olympus is not a valid time zone")},
+		{"Invalid output format", []string{"output", "xml"}, "", fmt.Errorf("the xml is not a valid output format, use one of plain, json, csv, table")},
+		{"Invalid option", []string{"color", "on"}, "", user.Usage("set")},
+		{"Invalid args count (too few)", []string{"output"}, "", user.Usage("set")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := Set(tt.args)
+			if (err != nil) != (tt.expectedError != nil) {
+				t.Errorf("Set() error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if err != nil && err.Error() != tt.expectedError.Error() {
+				t.Errorf("Set() error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("Set() output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+}
+
+// Test_OutputFormats tests rendering listings and errors in every output format.
+// Testing strategy:
+// 1. Test a listing with a description containing spaces and commas
+// 2. Test errors and usage errors in structured formats
+func Test_OutputFormats(t *testing.T) {
+	defer func() { Output = "plain" }()
+
+	Register([]string{"formatuser"})
+	CreateFolder([]string{"formatuser", "docs", `"Specs, drafts and notes"`})
+	SetAttr([]string{"formatuser", "docs", "team", "backend"})
+	Tag([]string{"formatuser", "docs", "shared"})
+	now := testTime.Format("2006-01-02 15:04:05")
+
+	tests := []struct {
+		format         string
+		expectedOutput string
+		expectedError  string
+	}{
+		{"plain", fmt.Sprintf("docs \"Specs, drafts and notes\" %s formatuser shared team=backend\n", now), "Error: the nobody doesn't exist\n"},
+		{"json", fmt.Sprintf("[\n  {\n    \"attributes\": \"team=backend\",\n    \"created_at\": \"%s\",\n    \"description\": \"Specs, drafts and notes\",\n    \"name\": \"docs\",\n    \"tags\": \"shared\",\n    \"username\": \"formatuser\"\n  }\n]\n", now), "{\n  \"status\": \"error\",\n  \"code\": \"not_found\",\n  \"error\": \"the nobody doesn't exist\"\n}\n"},
+		{"csv", fmt.Sprintf("name,description,created_at,username,tags,attributes\ndocs,\"Specs, drafts and notes\",%s,formatuser,shared,team=backend\n", now), "status,code,error\nerror,not_found,the nobody doesn't exist\n"},
+		{"table", fmt.Sprintf("NAME  DESCRIPTION              CREATED AT           USERNAME    TAGS    ATTRIBUTES\ndocs  Specs, drafts and notes  %s  formatuser  shared  team=backend\n", now), "STATUS  CODE       ERROR\nerror   not_found  the nobody doesn't exist\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			if err := SetOutputFormat(tt.format); err != nil {
+				t.Fatalf("SetOutputFormat() error = %v", err)
+			}
+			output, err := ListFolders([]string{"formatuser"})
+			if err != nil {
+				t.Fatalf("ListFolders() error = %v", err)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("ListFolders() output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+			_, err = ListFolders([]string{"nobody"})
+			if rendered := RenderError(err); rendered != tt.expectedError {
+				t.Errorf("RenderError() = %v, expected %v", rendered, tt.expectedError)
+			}
+		})
+	}
+
+	Output = "plain"
+	if rendered := RenderError(user.Usage("set")); rendered != user.CommandsUsage["set"]+"\n" {
+		t.Errorf("RenderError() = %v, expected usage without prefix", rendered)
+	}
+}
+
+// Test_Descriptions tests the SetDescription and ClearDescription functions.
+// Testing strategy:
+// 1. Test setting and clearing descriptions on folders and files
+// 2. Test that the listings show the new descriptions
+// 3. Test invalid args count and nonexistent targets
+func Test_Descriptions(t *testing.T) {
+	Register([]string{"metauser"})
+	CreateFolder([]string{"metauser", "docs", "old"})
+	CreateFile([]string{"metauser", "docs", "plan", "old"})
+
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Valid set folder description", SetDescription, []string{"metauser", "docs", `"Team docs"`}, "Set description of docs successfully\n", nil},
+		{"Valid set file description", SetDescription, []string{"metauser", "docs", "plan", "draft"}, "Set description of plan in metauser/docs successfully\n", nil},
+		{"List folders shows description", ListFolders, []string{"metauser"}, fmt.Sprintf("docs \"Team docs\" %s metauser\n", testTime.Format("2006-01-02 15:04:05")), nil},
+		{"List files shows description", ListFiles, []string{"metauser", "docs"}, fmt.Sprintf("plan draft %s metauser\n", testTime.Format("2006-01-02 15:04:05")), nil},
+		{"Valid clear file description", ClearDescription, []string{"metauser", "docs", "plan"}, "Clear description of plan in metauser/docs successfully\n", nil},
+		{"List files without description", ListFiles, []string{"metauser", "docs"}, fmt.Sprintf("plan %s metauser\n", testTime.Format("2006-01-02 15:04:05")), nil},
+		{"Invalid set args count (too few)", SetDescription, []string{"metauser", "docs"}, "", user.Usage("set-description")},
+		{"Invalid clear args count (too many)", ClearDescription, []string{"metauser", "docs", "plan", "extra"}, "", user.Usage("clear-description")},
+		{"Nonexistent file", SetDescription, []string{"metauser", "docs", "missing", "text"}, "", fmt.Errorf("the missing doesn't exist")},
+		{"Nonexistent folder", ClearDescription, []string{"metauser", "missing"}, "", fmt.Errorf("the missing doesn't exist")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil) != (tt.expectedError != nil) {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if err != nil && err.Error() != tt.expectedError.Error() {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+}
+
+// Test_Attributes tests the SetAttr, GetAttr, ListAttrs and UnsetAttr functions.
+// Testing strategy:
+// 1. Test setting, reading, listing and removing attributes on folders and files
+// 2. Test that the listings show the attributes
+// 3. Test invalid keys, args count and missing attributes
+func Test_Attributes(t *testing.T) {
+	Register([]string{"attruser"})
+	CreateFolder([]string{"attruser", "docs"})
+	CreateFile([]string{"attruser", "docs", "plan"})
+
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Valid set folder attr", SetAttr, []string{"attruser", "docs", "Owner", "alice"}, "Set owner on docs successfully\n", nil},
+		{"Valid set file attr", SetAttr, []string{"attruser", "docs", "plan", "status", "review"}, "Set status on plan in attruser/docs successfully\n", nil},
+		{"Valid overwrite file attr", SetAttr, []string{"attruser", "docs", "plan", "status", "final"}, "Set status on plan in attruser/docs successfully\n", nil},
+		{"Valid set second file attr", SetAttr, []string{"attruser", "docs", "plan", "client", "acme"}, "Set client on plan in attruser/docs successfully\n", nil},
+		{"Valid get folder attr", GetAttr, []string{"attruser", "docs", "owner"}, "owner alice\n", nil},
+		{"Valid get file attr", GetAttr, []string{"attruser", "docs", "plan", "status"}, "status final\n", nil},
+		{"Valid list file attrs", ListAttrs, []string{"attruser", "docs", "plan"}, "client acme\nstatus final\n", nil},
+		{"List files shows attrs", ListFiles, []string{"attruser", "docs"}, fmt.Sprintf("plan %s attruser client=acme,status=final\n", testTime.Format("2006-01-02 15:04:05")), nil},
+		{"Valid unset file attr", UnsetAttr, []string{"attruser", "docs", "plan", "client"}, "Unset client on plan in attruser/docs successfully\n", nil},
+		{"Valid list after unset", ListAttrs, []string{"attruser", "docs", "plan"}, "status final\n", nil},
+		{"Set decomposed key", SetAttr, []string{"attruser", "docs", "plan", "cafe\u0301", "yes"}, "Set cafe\u0301 on plan in attruser/docs successfully\n", nil},
+		{"Decomposed key is stored composed", ListAttrs, []string{"attruser", "docs", "plan"}, "caf\u00e9 yes\nstatus final\n", nil},
+		{"Get decomposed key", GetAttr, []string{"attruser", "docs", "plan", "cafe\u0301"}, "cafe\u0301 yes\n", nil},
+		{"Unset decomposed key", UnsetAttr, []string{"attruser", "docs", "plan", "cafe\u0301"}, "Unset cafe\u0301 on plan in attruser/docs successfully\n", nil},
+		{"Get missing attr", GetAttr, []string{"attruser", "docs", "plan", "client"}, "", fmt.Errorf("the client attribute doesn't exist")},
+		{"Unset missing attr", UnsetAttr, []string{"attruser", "docs", "color"}, "", fmt.Errorf("the color attribute doesn't exist")},
+		{"Attr key with invalid characters", SetAttr, []string{"attruser", "docs", "a@b", "value"}, "", fmt.Errorf("the a@b contain invalid chars")},
+		{"Invalid set args count (too few)", SetAttr, []string{"attruser", "docs", "key"}, "", user.Usage("set-attr")},
+		{"Invalid list args count (too few)", ListAttrs, []string{"attruser"}, "", user.Usage("list-attrs")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil) != (tt.expectedError != nil) {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if err != nil && err.Error() != tt.expectedError.Error() {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+}
+
+// Test_Tags tests the Tag, Untag and ListTags functions and the --tag filter.
+// Testing strategy:
+// 1. Test tagging and untagging folders and files
+// 2. Test listing tags per user, folder and file
+// 3. Test AND/OR tag filters on list-folders and list-files
+// 4. Test that the tag index follows renames and deletions
+// 5. Test invalid tags, duplicates and args count
+func Test_Tags(t *testing.T) {
+	Register([]string{"taguser"})
+	CreateFolder([]string{"taguser", "reports"})
+	CreateFolder([]string{"taguser", "archive"})
+	CreateFile([]string{"taguser", "reports", "q1"})
+	CreateFile([]string{"taguser", "reports", "q2"})
+	CreateFile([]string{"taguser", "reports", "q3"})
+	created := testTime.Format("2006-01-02 15:04:05")
+
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Valid tag folder", Tag, []string{"taguser", "reports", "Client-X"}, "Tag reports with client-x successfully\n", nil},
+		{"Valid tag file", Tag, []string{"taguser", "reports", "q1", "draft"}, "Tag q1 in taguser/reports with draft successfully\n", nil},
+		{"Valid tag file second tag", Tag, []string{"taguser", "reports", "q1", "client-x"}, "Tag q1 in taguser/reports with client-x successfully\n", nil},
+		{"Valid tag other file", Tag, []string{"taguser", "reports", "q2", "final"}, "Tag q2 in taguser/reports with final successfully\n", nil},
+		{"Valid tag third file", Tag, []string{"taguser", "reports", "q3", "draft"}, "Tag q3 in taguser/reports with draft successfully\n", nil},
+		{"Duplicate tag", Tag, []string{"taguser", "reports", "q1", "draft"}, "", fmt.Errorf("the q1 is already tagged draft")},
+		{"Tag with invalid characters", Tag, []string{"taguser", "reports", "a@b"}, "", fmt.Errorf("the a@b contain invalid chars")},
+		{"List user tags", ListTags, []string{"taguser"}, "client-x 2\ndraft 2\nfinal 1\n", nil},
+		{"List folder tags", ListTags, []string{"taguser", "reports"}, "client-x\n", nil},
+		{"List file tags", ListTags, []string{"taguser", "reports", "q1"}, "client-x\ndraft\n", nil},
+		{"Filter folders by tag", ListFolders, []string{"taguser", "--tag", "client-x"}, fmt.Sprintf("reports %s taguser client-x\n", created), nil},
+		{"Filter files by AND", ListFiles, []string{"taguser", "reports", "--tag", "draft+client-x"}, fmt.Sprintf("q1 %s taguser client-x,draft\n", created), nil},
+		{"Filter files by OR", ListFiles, []string{"taguser", "reports", "--sort-name", "desc", "--tag", "final,client-x"}, fmt.Sprintf("q2 %s taguser final\nq1 %s taguser client-x,draft\n", created, created), nil},
+		{"Filter files without matches", ListFiles, []string{"taguser", "reports", "--tag", "final+draft"}, "", nil},
+		{"Filter without tag", ListFiles, []string{"taguser", "reports", "--tag"}, "", user.Usage("list-files")},
+		{"Valid untag file", Untag, []string{"taguser", "reports", "q3", "draft"}, "Untag draft from q3 in taguser/reports successfully\n", nil},
+		{"Untag missing tag", Untag, []string{"taguser", "reports", "q3", "draft"}, "", fmt.Errorf("the q3 is not tagged draft")},
+		{"Tag file decomposed", Tag, []string{"taguser", "reports", "q3", "cafe\u0301"}, "Tag q3 in taguser/reports with cafe\u0301 successfully\n", nil},
+		{"Untag file decomposed", Untag, []string{"taguser", "reports", "q3", "cafe\u0301"}, "Untag cafe\u0301 from q3 in taguser/reports successfully\n", nil},
+		{"Tag folder composed", Tag, []string{"taguser", "reports", "caf\u00e9"}, "Tag reports with caf\u00e9 successfully\n", nil},
+		{"Untag folder decomposed", Untag, []string{"taguser", "reports", "cafe\u0301"}, "Untag cafe\u0301 from reports successfully\n", nil},
+		{"Rename keeps tags indexed", RenameFolder, []string{"taguser", "reports", "reports-2024"}, "Rename reports to reports-2024 successfully\n", nil},
+		{"Filter files after rename", ListFiles, []string{"taguser", "reports-2024", "--tag", "draft"}, fmt.Sprintf("q1 %s taguser client-x,draft\n", created), nil},
+		{"Delete file drops tags", DeleteFile, []string{"taguser", "reports-2024", "q2"}, "Deleted file q2 from taguser/reports-2024 successfully\n", nil},
+		{"List user tags after delete", ListTags, []string{"taguser"}, "client-x 2\ndraft 1\n", nil},
+		{"Delete folder drops tags", DeleteFolder, []string{"taguser", "reports-2024"}, "Delete reports-2024 successfully\n", nil},
+		{"List user tags after folder delete", ListTags, []string{"taguser"}, "", nil},
+		{"Invalid args count (too few)", Tag, []string{"taguser", "archive"}, "", user.Usage("tag")},
+		{"Invalid list args count (too many)", ListTags, []string{"taguser", "archive", "file", "extra"}, "", user.Usage("list-tags")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil) != (tt.expectedError != nil) {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if err != nil && err.Error() != tt.expectedError.Error() {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+
+	// Matching reorders the tags of a group by index size on its own copy
+	Tag([]string{"taguser", "archive", "rare"})
+	Tag([]string{"taguser", "archive", "common"})
+	CreateFolder([]string{"taguser", "other"})
+	Tag([]string{"taguser", "other", "common"})
+	u, _ := user.GetUser("taguser")
+	query, _ := user.ParseTagQuery("common+rare")
+	if folders := u.TaggedFolders(query); !folders["archive"] || len(folders) != 1 || query[0][0] != "common" || query[0][1] != "rare" {
+		t.Errorf("TaggedFolders() = %v, query after = %v", folders, query)
+	}
+}
+
+// Test_WriteFile tests the WriteFile and Cat functions with various input scenarios.
+// Testing strategy:
+// 1. Test writing content with and without spaces and reading it back
+// 2. Test invalid args count and nonexistent files
+func Test_WriteFile(t *testing.T) {
+	Register([]string{"contentuser"})
+	CreateFolder([]string{"contentuser", "notes"})
+	CreateFile([]string{"contentuser", "notes", "todo"})
+
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Cat empty file", Cat, []string{"contentuser", "notes", "todo"}, "", nil},
+		{"Valid write", WriteFile, []string{"contentuser", "notes", "todo", "milk"}, "Write 4 bytes to todo in contentuser/notes successfully\n", nil},
+		{"Valid write with spaces", WriteFile, []string{"contentuser", "notes", "todo", `"buy milk and eggs"`}, "Write 17 bytes to todo in contentuser/notes successfully\n", nil},
+		{"Valid cat", Cat, []string{"contentuser", "notes", "todo"}, "buy milk and eggs\n", nil},
+		{"Write to nonexistent file", WriteFile, []string{"contentuser", "notes", "missing", "text"}, "", fmt.Errorf("the missing doesn't exist")},
+		{"Invalid write args count (too few)", WriteFile, []string{"contentuser", "notes", "todo"}, "", user.Usage("write-file")},
+		{"Invalid cat args count (too many)", Cat, []string{"contentuser", "notes", "todo", "extra"}, "", user.Usage("cat")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil) != (tt.expectedError != nil) {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if err != nil && err.Error() != tt.expectedError.Error() {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+}
+
+// Test_Find tests the Find function with various input scenarios.
+// Testing strategy:
+// 1. Test matching by glob, regex, description, time range, size, tags and attributes
+// 2. Test sorting results and searching across all users
+// 3. Test invalid filters and args count
+func Test_Find(t *testing.T) {
+	clock := user.NewFakeClock(time.Date(2024, time.March, 1, 9, 0, 0, 0, time.Local))
+	user.SetClock(clock)
+	defer user.SetClock(user.NewFakeClock(testTime))
+
+	Register([]string{"finduser"})
+	Register([]string{"findother"})
+	CreateFolder([]string{"finduser", "reports"})
+	CreateFolder([]string{"finduser", "drafts"})
+	CreateFolder([]string{"findother", "inbox"})
+	CreateFile([]string{"finduser", "reports", "q1.txt", `"Quarterly summary"`})
+	clock.Advance(time.Hour)
+	CreateFile([]string{"finduser", "drafts", "q2.txt", "wip"})
+	clock.Advance(time.Hour)
+	CreateFile([]string{"finduser", "drafts", "notes.md"})
+	CreateFile([]string{"findother", "inbox", "q3.txt"})
+	WriteFile([]string{"finduser", "reports", "q1.txt", `"twelve bytes"`})
+	Tag([]string{"finduser", "drafts", "q2.txt", "draft"})
+	SetAttr([]string{"finduser", "drafts", "notes.md", "status", "review"})
+
+	savedActor, savedAdmins := Actor, Admins
+	defer func() { Actor, Admins = savedActor, savedAdmins }()
+	Actor, Admins = "findadmin", map[string]bool{"findadmin": true}
+
+	tests := []struct {
+		name           string
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Find everything", []string{"finduser"}, "finduser/drafts/notes.md 2024-03-01 11:00:00 0 status=review\nfinduser/drafts/q2.txt wip 2024-03-01 10:00:00 0 draft\nfinduser/reports/q1.txt \"Quarterly summary\" 2024-03-01 09:00:00 12\n", nil},
+		{"Find by glob", []string{"finduser", "--name", "q*.txt"}, "finduser/drafts/q2.txt wip 2024-03-01 10:00:00 0 draft\nfinduser/reports/q1.txt \"Quarterly summary\" 2024-03-01 09:00:00 12\n", nil},
+		{"Find by regex", []string{"finduser", "--regex", `\.md$`}, "finduser/drafts/notes.md 2024-03-01 11:00:00 0 status=review\n", nil},
+		{"Find by description", []string{"finduser", "--description", "SUMMARY"}, "finduser/reports/q1.txt \"Quarterly summary\" 2024-03-01 09:00:00 12\n", nil},
+		{"Find by created range", []string{"finduser", "--created-after", `"2024-03-01 09:30:00"`, "--created-before", `"2024-03-01 10:30:00"`}, "finduser/drafts/q2.txt wip 2024-03-01 10:00:00 0 draft\n", nil},
+		{"Find by min size", []string{"finduser", "--min-size", "1"}, "finduser/reports/q1.txt \"Quarterly summary\" 2024-03-01 09:00:00 12\n", nil},
+		{"Find by max size", []string{"finduser", "--sort-created", "desc", "--max-size", "0"}, "finduser/drafts/notes.md 2024-03-01 11:00:00 0 status=review\nfinduser/drafts/q2.txt wip 2024-03-01 10:00:00 0 draft\n", nil},
+		{"Find by tag", []string{"finduser", "--tag", "draft"}, "finduser/drafts/q2.txt wip 2024-03-01 10:00:00 0 draft\n", nil},
+		{"Find by attribute", []string{"finduser", "--attr", "status=review"}, "finduser/drafts/notes.md 2024-03-01 11:00:00 0 status=review\n", nil},
+		{"Find by attribute value mismatch", []string{"finduser", "--attr", "status=final"}, "", nil},
+		{"Find across all users", []string{"--all-users", "--sort-created", "desc", "--name", "q*"}, "findother/inbox/q3.txt 2024-03-01 11:00:00 0\nfinduser/drafts/q2.txt wip 2024-03-01 10:00:00 0 draft\nfinduser/reports/q1.txt \"Quarterly summary\" 2024-03-01 09:00:00 12\n", nil},
+		{"Invalid time", []string{"finduser", "--created-after", "yesterday"}, "", fmt.Errorf("the yesterday is not a valid time, use YYYY-MM-DD, \"YYYY-MM-DD hh:mm:ss\" or RFC 3339")},
+		{"Invalid regex", []string{"finduser", "--regex", "("}, "", fmt.Errorf("the ( is not a valid regex")},
+		{"Invalid size", []string{"finduser", "--min-size", "big"}, "", fmt.Errorf("the big is not a valid size")},
+		{"Invalid sort option", []string{"finduser", "--sort-size"}, "", user.Usage("find")},
+		{"Flag without value", []string{"finduser", "--name"}, "", user.Usage("find")},
+		{"Invalid args count (too few)", []string{}, "", user.Usage("find")},
+		{"Nonexistent user", []string{"nobody"}, "", fmt.Errorf("the nobody doesn't exist")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := Find(tt.args)
+			if (err != nil) != (tt.expectedError != nil) {
+				t.Errorf("Find() error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if err != nil && err.Error() != tt.expectedError.Error() {
+				t.Errorf("Find() error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("Find() output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+
+	Actor = "finduser"
+	if _, err := Find([]string{"--all-users", "--name", "q*"}); !errors.Is(err, user.PermissionDenied) {
+		t.Errorf("Find() error = %v, expected permission_denied for a non-admin", err)
+	}
+	if _, err := Search([]string{"--all-users", "q3"}); !errors.Is(err, user.PermissionDenied) {
+		t.Errorf("Search() error = %v, expected permission_denied for a non-admin", err)
+	}
+
+	// A non-admin can't become an admin by switching the actor
+	if _, err := Set([]string{"actor", "findadmin"}); !errors.Is(err, user.PermissionDenied) || Actor != "finduser" {
+		t.Errorf("Set() error = %v, actor = %s, expected permission_denied", err, Actor)
+	}
+	Actor = "findadmin"
+	if _, err := Set([]string{"actor", "finduser"}); err != nil || Actor != "finduser" {
+		t.Errorf("Set() error = %v, actor = %s, expected an admin to switch", err, Actor)
+	}
+}
+
+// Test_Search tests the Search and Reindex functions with various input scenarios.
+// Testing strategy:
+// 1. Test plain, phrase, prefix and boolean queries over names, descriptions and contents
+// 2. Test that ranking puts the most relevant documents first
+// 3. Test that the index follows edits, renames and deletions
+// 4. Test rebuilding the index and invalid queries
+func Test_Search(t *testing.T) {
+	Register([]string{"searchuser"})
+	savedActor, savedAdmins := Actor, Admins
+	defer func() { Actor, Admins = savedActor, savedAdmins }()
+	Actor, Admins = "searchadmin", map[string]bool{"searchadmin": true}
+	CreateFolder([]string{"searchuser", "zephyr", `"Zephyr launch plans"`})
+	CreateFile([]string{"searchuser", "zephyr", "budget", `"Zephyr budget estimate"`})
+	CreateFile([]string{"searchuser", "zephyr", "timeline"})
+	CreateFile([]string{"searchuser", "zephyr", "minutes", `"Meeting minutes"`})
+	WriteFile([]string{"searchuser", "zephyr", "timeline", `"launch checklist launch review launch"`})
+	WriteFile([]string{"searchuser", "zephyr", "minutes", `"plans for the spring launch were approved"`})
+
+	// paths returns the first column of every plain output line
+	paths := func(output string) string {
+		var result []string
+		for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+			if fields := strings.Fields(line); len(fields) > 0 {
+				result = append(result, fields[0])
+			}
+		}
+		return strings.Join(result, " ")
+	}
+
+	// Steps that only change state expect "-" and skip the path check
+	steps := []struct {
+		name          string
+		command       func([]string) (string, error)
+		args          []string
+		expectedPaths string
+		expectedError error
+	}{
+		{"Search description word", Search, []string{"searchuser", "budget"}, "searchuser/zephyr/budget", nil},
+		{"Search ranks by frequency", Search, []string{"searchuser", "launch"}, "searchuser/zephyr/timeline searchuser/zephyr searchuser/zephyr/minutes", nil},
+		{"Search implicit AND", Search, []string{"searchuser", "spring", "approved"}, "searchuser/zephyr/minutes", nil},
+		{"Search OR", Search, []string{"searchuser", "budget", "OR", "approved"}, "searchuser/zephyr/budget searchuser/zephyr/minutes", nil},
+		{"Search NOT", Search, []string{"searchuser", "launch", "NOT", "spring"}, "searchuser/zephyr/timeline searchuser/zephyr", nil},
+		{"Search phrase", Search, []string{"searchuser", `"spring launch"`}, "searchuser/zephyr/minutes", nil},
+		{"Search prefix", Search, []string{"searchuser", "approv*"}, "searchuser/zephyr/minutes", nil},
+		{"Search parentheses", Search, []string{"searchuser", "(budget", "OR", "minutes)", "AND", "zephyr"}, "searchuser/zephyr/budget", nil},
+		{"Edit updates index", SetDescription, []string{"searchuser", "zephyr", "budget", `"Cost sheet"`}, "-", nil},
+		{"Search after edit", Search, []string{"searchuser", "estimate"}, "", nil},
+		{"Attribute updates index", SetAttr, []string{"searchuser", "zephyr", "timeline", "client", "acme"}, "-", nil},
+		{"Search attribute value", Search, []string{"searchuser", "acme"}, "searchuser/zephyr/timeline", nil},
+		{"Unset attribute updates index", UnsetAttr, []string{"searchuser", "zephyr", "timeline", "client"}, "-", nil},
+		{"Search after unset", Search, []string{"searchuser", "acme"}, "", nil},
+		{"Rename keeps documents", RenameFolder, []string{"searchuser", "zephyr", "aurora"}, "-", nil},
+		{"Search after rename", Search, []string{"searchuser", "cost"}, "searchuser/aurora/budget", nil},
+		{"Search new folder name", Search, []string{"searchuser", "aurora"}, "searchuser/aurora", nil},
+		{"Delete removes document", DeleteFile, []string{"searchuser", "aurora", "minutes"}, "-", nil},
+		{"Search after delete", Search, []string{"searchuser", "approved"}, "", nil},
+		{"Search all users", Search, []string{"--all-users", "aurora"}, "searchuser/aurora", nil},
+		{"Reindex", Reindex, []string{}, "-", nil},
+		{"Search after reindex", Search, []string{"searchuser", "cost"}, "searchuser/aurora/budget", nil},
+		{"Unbalanced parentheses", Search, []string{"searchuser", "(budget"}, "", fmt.Errorf("missing ) in search query")},
+		{"Dangling operator", Search, []string{"searchuser", "budget", "OR"}, "", fmt.Errorf("unexpected end of search query")},
+		{"Invalid args count (too few)", Search, []string{"searchuser"}, "", user.Usage("search")},
+		{"Nonexistent user", Search, []string{"nobody", "word"}, "", fmt.Errorf("the nobody doesn't exist")},
+	}
+
+	for _, tt := range steps {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil) != (tt.expectedError != nil) {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if err != nil && err.Error() != tt.expectedError.Error() {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if tt.expectedPaths == "-" {
+				return
+			}
+			if got := paths(output); got != tt.expectedPaths {
+				t.Errorf("Search() paths = %v, expectedPaths %v", got, tt.expectedPaths)
+			}
+		})
+	}
+}
+
+// Test_Tree tests the Tree function with various input scenarios.
+// Testing strategy:
+// 1. Test the full tree of a user and of a single folder
+// 2. Test depth limits, columns and ASCII branches
+// 3. Test the JSON and table variants
+// 4. Test invalid depth, args count and nonexistent folders
+func Test_Tree(t *testing.T) {
+	defer func() { Output = "plain" }()
+
+	Register([]string{"treeuser"})
+	CreateFolder([]string{"treeuser", "src", `"Source code"`})
+	CreateFolder([]string{"treeuser", "docs"})
+	CreateFile([]string{"treeuser", "src", "main.go"})
+	CreateFile([]string{"treeuser", "src", "util.go", "helpers"})
+	CreateFile([]string{"treeuser", "docs", "readme"})
+	WriteFile([]string{"treeuser", "src", "main.go", "package"})
+	created := testTime.Format("2006-01-02 15:04:05")
+
+	tests := []struct {
+		name           string
+		output         string
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Valid user tree", "plain", []string{"treeuser"}, "treeuser\n├── docs\n│   └── readme\n└── src\n    ├── main.go\n    └── util.go\n\n2 folders, 3 files\n", nil},
+		{"Valid folder tree with columns", "plain", []string{"treeuser", "src", "--size", "--description"}, "src  \"Source code\"  7 bytes\n├── main.go  7 bytes\n└── util.go  helpers  0 bytes\n\n0 folders, 2 files\n", nil},
+		{"Valid tree with depth and time", "plain", []string{"treeuser", "--depth", "1", "--time"}, fmt.Sprintf("treeuser  %s\n├── docs  %s\n└── src  %s\n\n2 folders, 0 files\n", created, created, created), nil},
+		{"Valid ASCII tree", "plain", []string{"treeuser", "docs", "--ascii"}, "docs\n`-- readme\n\n0 folders, 1 file\n", nil},
+		{"Valid JSON tree", "json", []string{"treeuser", "src", "--depth", "0"}, fmt.Sprintf("{\n  \"tree\": {\n    \"name\": \"src\",\n    \"type\": \"folder\",\n    \"description\": \"Source code\",\n    \"size\": 7,\n    \"created_at\": \"%s\"\n  },\n  \"folders\": 0,\n  \"files\": 0\n}\n", created), nil},
+		{"Valid CSV tree", "csv", []string{"treeuser", "docs"}, fmt.Sprintf("path,type,description,size,created_at\ndocs,folder,,0,%s\ndocs/readme,file,,0,%s\n", created, created), nil},
+		{"Invalid depth", "plain", []string{"treeuser", "--depth", "-1"}, "", fmt.Errorf("the -1 is not a valid depth")},
+		{"Invalid args count (too many)", "plain", []string{"treeuser", "src", "extra"}, "", user.Usage("tree")},
+		{"Nonexistent folder", "plain", []string{"treeuser", "missing"}, "", fmt.Errorf("the missing doesn't exist")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Output = tt.output
+			output, err := Tree(tt.args)
+			if (err != nil) != (tt.expectedError != nil) {
+				t.Errorf("Tree() error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if err != nil && err.Error() != tt.expectedError.Error() {
+				t.Errorf("Tree() error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("Tree() output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+}
+
+// Test_Pagination tests the filter and paging flags of ListFolders and ListFiles.
+// Testing strategy:
+// 1. Test name prefix and created time filters with the total count header
+// 2. Test limit, offset and following cursors until the last page
+// 3. Test that cursors stay valid when entries are deleted in between
+// 4. Test the JSON page envelope and invalid flag values
+func Test_Pagination(t *testing.T) {
+	defer func() { Output = "plain" }()
+
+	clock := user.NewFakeClock(time.Date(2024, time.May, 1, 8, 0, 0, 0, time.Local))
+	user.SetClock(clock)
+	defer user.SetClock(user.NewFakeClock(testTime))
+
+	Register([]string{"pageuser"})
+	CreateFolder([]string{"pageuser", "logs"})
+	for _, name := range []string{"app-1", "app-2", "app-3", "db-1", "db-2"} {
+		clock.Advance(time.Minute)
+		CreateFile([]string{"pageuser", "logs", name})
+	}
+
+	line := func(name string, minute int) string {
+		return fmt.Sprintf("%s 2024-05-01 08:%02d:00 pageuser\n", name, minute)
+	}
+
+	output, err := ListFiles([]string{"pageuser", "logs", "--name-prefix", "APP", "--created-after", `"2024-05-01 08:01:00"`})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if expected := "Total 2, showing 1-2\n" + line("app-2", 2) + line("app-3", 3); output != expected {
+		t.Errorf("ListFiles() output = %v, expectedOutput %v", output, expected)
+	}
+
+	output, err = ListFiles([]string{"pageuser", "logs", "--limit", "2", "--offset", "1", "--created-before", `"2024-05-01 08:05:00"`})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	if !strings.HasPrefix(output, "Total ") || !strings.Contains(output, line("app-2", 2)+line("app-3", 3)+"Next cursor: ") {
+		t.Errorf("ListFiles() output = %v, expected second page of two entries with a cursor", output)
+	}
+
+	// Walk every page through cursors, deleting an upcoming entry on the way
+	var names []string
+	var cursor string
+	for page := 0; page < 5; page++ {
+		args := []string{"pageuser", "logs", "--sort-created", "desc", "--limit", "2"}
+		if cursor != "" {
+			args = append(args, "--cursor", cursor)
+		}
+		output, err := ListFiles(args)
+		if err != nil {
+			t.Fatalf("ListFiles() error = %v", err)
+		}
+
+		cursor = ""
+		for _, row := range strings.Split(strings.TrimSuffix(output, "\n"), "\n")[1:] {
+			if next, found := strings.CutPrefix(row, "Next cursor: "); found {
+				cursor = next
+				continue
+			}
+			names = append(names, strings.Fields(row)[0])
+		}
+		if page == 0 {
+			DeleteFile([]string{"pageuser", "logs", "app-3"})
+		}
+		if cursor == "" {
+			break
+		}
+	}
+	if got := strings.Join(names, " "); got != "db-2 db-1 app-2 app-1" {
+		t.Errorf("cursor pages = %v, expected db-2 db-1 app-2 app-1", got)
+	}
+
+	// CSV keeps only the records, the page summary goes to PageOutput
+	var summary strings.Builder
+	PageOutput = &summary
+	defer func() { PageOutput = os.Stderr }()
+	Output = "csv"
+	output, err = ListFiles([]string{"pageuser", "logs", "--limit", "1", "--name-prefix", "db"})
+	if err != nil {
+		t.Fatalf("ListFiles() error = %v", err)
+	}
+	records, csvErr := csv.NewReader(strings.NewReader(output)).ReadAll()
+	if csvErr != nil || len(records) != 2 || records[1][0] != "db-1" {
+		t.Errorf("ListFiles() output = %v, error = %v, expected a header and one record", output, csvErr)
+	}
+	if !strings.HasPrefix(summary.String(), "Total 2, showing 1-1\nNext cursor: ") {
+		t.Errorf("ListFiles() page summary = %q", summary.String())
+	}
+
+	Output = "json"
+	output, err = ListFolders([]string{"pageuser", "--limit", "1"})
+	if err != nil {
+		t.Fatalf("ListFolders() error = %v", err)
+	}
+	if expected := "{\n  \"total\": 1,\n  \"offset\": 0,\n  \"items\": [\n    {\n      \"attributes\": \"\",\n      \"created_at\": \"2024-05-01 08:00:00\",\n      \"description\": \"\",\n      \"name\": \"logs\",\n      \"tags\": \"\",\n      \"username\": \"pageuser\"\n    }\n  ]\n}\n"; output != expected {
+		t.Errorf("ListFolders() output = %v, expectedOutput %v", output, expected)
+	}
+	Output = "plain"
+
+	errorTests := []struct {
+		name          string
+		args          []string
+		expectedError error
+	}{
+		{"Invalid limit", []string{"pageuser", "logs", "--limit", "0"}, fmt.Errorf("the 0 is not a valid limit")},
+		{"Invalid offset", []string{"pageuser", "logs", "--offset", "-2"}, fmt.Errorf("the -2 is not a valid offset")},
+		{"Invalid cursor", []string{"pageuser", "logs", "--cursor", "???"}, fmt.Errorf("the ??? is not a valid cursor")},
+		{"Flag without value", []string{"pageuser", "logs", "--name-prefix"}, user.Usage("list-files")},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ListFiles(tt.args)
+			if err == nil || err.Error() != tt.expectedError.Error() {
+				t.Errorf("ListFiles() error = %v, expectedError %v", err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func Test_Sort(t *testing.T) {
+	Register([]string{"sortuser"})
+	CreateFolder([]string{"sortuser", "docs"})
+	for _, name := range []string{"file10", "file2", "b", "file1", "a"} {
+		CreateFile([]string{"sortuser", "docs", name})
+	}
+	WriteFile([]string{"sortuser", "docs", "file10", "xx"})
+	WriteFile([]string{"sortuser", "docs", "file2", "xx"})
+	WriteFile([]string{"sortuser", "docs", "a", "x"})
+
+	names := func(output string) string {
+		var names []string
+		for _, row := range strings.Split(strings.TrimSuffix(output, "\n"), "\n") {
+			names = append(names, strings.Fields(row)[0])
+		}
+		return strings.Join(names, " ")
+	}
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{"Natural name order", []string{"sortuser", "docs", "--sort", "name"}, "a b file1 file2 file10"},
+		{"Natural name order desc", []string{"sortuser", "docs", "--sort-name", "desc"}, "file10 file2 file1 b a"},
+		{"Size then name", []string{"sortuser", "docs", "--sort", "size:desc,name"}, "file2 file10 a b file1"},
+		{"Ties keep creation order", []string{"sortuser", "docs", "--sort", "created"}, "file10 file2 b file1 a"},
+		{"Ties keep creation order desc", []string{"sortuser", "docs", "--sort", "size:desc"}, "file10 file2 a b file1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := ListFiles(tt.args)
+			if err != nil {
+				t.Fatalf("ListFiles() error = %v", err)
+			}
+			if got := names(output); got != tt.expected {
+				t.Errorf("ListFiles() names = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+
+	output, err := Find([]string{"sortuser", "--sort", "size,name:desc"})
+	if err != nil {
+		t.Fatalf("Find() error = %v", err)
+	}
+	if expected := "sortuser/docs/file1 sortuser/docs/b sortuser/docs/a sortuser/docs/file10 sortuser/docs/file2"; names(output) != expected {
+		t.Errorf("Find() names = %v, expected %v", names(output), expected)
+	}
+
+	// Names sort case-insensitively, the exact casing only breaks ties
+	Register([]string{"mixedsort"})
+	for _, name := range []string{"Zeta", "alpha", "Beta", "file10", "File2"} {
+		CreateFolder([]string{"mixedsort", name})
+	}
+	output, err = ListFolders([]string{"mixedsort", "--sort", "name"})
+	if err != nil {
+		t.Fatalf("ListFolders() error = %v", err)
+	}
+	if expected := "alpha Beta File2 file10 Zeta"; names(output) != expected {
+		t.Errorf("ListFolders() names = %v, expected %v", names(output), expected)
+	}
+	for _, tt := range []struct {
+		a, b     string
+		expected int
+	}{{"Zeta", "alpha", 1}, {"B", "b", -1}, {"b", "B", 1}, {"x", "x", 0}, {"Note9", "note10", -1}} {
+		if got := utils.NaturalCompare(tt.a, tt.b); got != tt.expected {
+			t.Errorf("NaturalCompare(%q, %q) = %d, expected %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+
+	errorTests := []struct {
+		name          string
+		args          []string
+		expectedError error
+	}{
+		{"Unknown key", []string{"sortuser", "docs", "--sort", "owner"}, fmt.Errorf("the owner is not a sort field, use one of name, created, modified, size, description\n%s", user.CommandsUsage["list-files"])},
+		{"Unknown direction", []string{"sortuser", "docs", "--sort", "name:up"}, fmt.Errorf("the up is not a sort direction, use asc or desc\n%s", user.CommandsUsage["list-files"])},
+		{"Combined with positional sort", []string{"sortuser", "docs", "--sort-name", "--sort", "name"}, user.Usage("list-files")},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ListFiles(tt.args)
+			if err == nil || err.Error() != tt.expectedError.Error() {
+				t.Errorf("ListFiles() error = %v, expectedError %v", err, tt.expectedError)
+			}
+		})
+	}
+}
+
+func Test_CaseInsensitiveNames(t *testing.T) {
+	Register([]string{"CaseUser"})
+	CreateFolder([]string{"caseuser", "Projects"})
+	CreateFile([]string{"CASEUSER", "projects", "ProjectPlan.docx"})
+	Tag([]string{"caseuser", "PROJECTS", "projectplan.DOCX", "draft"})
+
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Listing keeps the original casing", ListFiles, []string{"caseuser", "projects", "--tag", "draft"}, "ProjectPlan.docx 2023-01-01 15:00:00 CaseUser draft\n", nil},
+		{"Duplicate user in another case", Register, []string{"caseUSER"}, "", fmt.Errorf("the caseUSER has already existed")},
+		{"Duplicate folder in another case", CreateFolder, []string{"caseuser", "PROJECTS"}, "", fmt.Errorf("the PROJECTS has already existed")},
+		{"Duplicate file in another case", CreateFile, []string{"caseuser", "projects", "projectplan.docx"}, "", fmt.Errorf("the projectplan.docx has already existed")},
+		{"Rename that only changes case", RenameFolder, []string{"caseuser", "projects", "MyProjects"}, "Rename projects to MyProjects successfully\n", nil},
+		{"Case-only rename", RenameFolder, []string{"caseuser", "myprojects", "myProjects"}, "Rename myprojects to myProjects successfully\n", nil},
+		{"Lookup after case-only rename", ListFolders, []string{"caseuser"}, "myProjects 2023-01-01 15:00:00 CaseUser\n", nil},
+		{"Tags follow the rename", ListFiles, []string{"caseuser", "MYPROJECTS", "--tag", "draft"}, "ProjectPlan.docx 2023-01-01 15:00:00 CaseUser draft\n", nil},
+		{"Rename onto another folder in another case", RenameFolder, []string{"caseuser", "myprojects", "Projects"}, "Rename myprojects to Projects successfully\n", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil) != (tt.expectedError != nil) || (err != nil && err.Error() != tt.expectedError.Error()) {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+
+	CreateFolder([]string{"caseuser", "Archive"})
+	if _, err := RenameFolder([]string{"caseuser", "projects", "ARCHIVE"}); err == nil || err.Error() != "the ARCHIVE already exists" {
+		t.Errorf("RenameFolder() error = %v, expectedError the ARCHIVE already exists", err)
+	}
+}
+
+func Test_NamePolicy(t *testing.T) {
+	savedAuditLog := AuditLog
+	defer func() {
+		if err := ApplyConfig(config.Default()); err != nil {
+			t.Fatalf("ApplyConfig() error = %v", err)
+		}
+		AuditLog = savedAuditLog
+	}()
+
+	Register([]string{"policyuser"})
+
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Accented folder", CreateFolder, []string{"policyuser", "Caf\u00e9"}, "Create Caf\u00e9 successfully\n", nil},
+		{"CJK file", CreateFile, []string{"policyuser", "caf\u00e9", "報告.txt"}, "Create 報告.txt in policyuser/caf\u00e9 successfully\n", nil},
+		{"Decomposed spelling finds the precomposed name", CreateFile, []string{"policyuser", "cafe\u0301", "notes"}, "Create notes in policyuser/cafe\u0301 successfully\n", nil},
+		{"Decomposed duplicate", CreateFolder, []string{"policyuser", "Cafe\u0301"}, "", fmt.Errorf("the Caf\u00e9 has already existed")},
+		{"Reserved name", CreateFolder, []string{"policyuser", ".."}, "", fmt.Errorf("the .. is a reserved name")},
+		{"Forbidden character", CreateFolder, []string{"policyuser", "a/b"}, "", fmt.Errorf("the a/b contain invalid chars")},
+		{"Length counts runes", Register, []string{strings.Repeat("名", 25)}, fmt.Sprintf("Add %s successfully\n", strings.Repeat("名", 25)), nil},
+		{"Too many runes", Register, []string{strings.Repeat("名", 26)}, "", fmt.Errorf("username is too long, max length allowed is 25")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil) != (tt.expectedError != nil) || (err != nil && err.Error() != tt.expectedError.Error()) {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+
+	if err := utils.SetNamePolicy("ascii"); err != nil {
+		t.Fatalf("SetNamePolicy() error = %v", err)
+	}
+	if _, err := CreateFolder([]string{"policyuser", "Crème"}); err == nil || err.Error() != "the Crème contain invalid chars" {
+		t.Errorf("CreateFolder() error = %v, expectedError the Crème contain invalid chars", err)
+	}
+	if _, err := Register([]string{strings.Repeat("a", 9) + "é"}); err == nil {
+		t.Errorf("Register() expected an error in ascii mode")
+	}
+
+	// A deployment adds forbidden characters, reserved names and its length unit
+	path := filepath.Join(t.TempDir(), "config.json")
+	os.WriteFile(path, []byte(`{"name_reserved": "con, nul"}`), 0o600)
+	t.Setenv("VFS_NAME_FORBIDDEN", "-")
+	t.Setenv("VFS_NAME_LENGTH_UNIT", "bytes")
+	cfg, err := config.Load(path, true)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+	configTests := []struct {
+		name          string
+		command       func([]string) (string, error)
+		args          []string
+		expectedError error
+	}{
+		{"Forbidden from env", CreateFolder, []string{"policyuser", "a-b"}, fmt.Errorf("the a-b contain invalid chars")},
+		{"Reserved from file", CreateFolder, []string{"policyuser", "CON"}, fmt.Errorf("the CON is a reserved name")},
+		{"Default reserved names stay", CreateFolder, []string{"policyuser", "."}, fmt.Errorf("the . is a reserved name")},
+		{"Length counts bytes", Register, []string{strings.Repeat("名", 9)}, fmt.Errorf("username is too long, max length allowed is 25")},
+	}
+	for _, tt := range configTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := tt.command(tt.args); err == nil || err.Error() != tt.expectedError.Error() {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+			}
+		})
+	}
+	if len(utils.UnicodePolicy.Reserved) != 2 || utils.UnicodePolicy.Forbidden != "" {
+		t.Errorf("ApplyConfig() changed the unicode preset to %+v", utils.UnicodePolicy)
+	}
+
+	cfg.NameLengthUnit = "words"
+	if err := ApplyConfig(cfg); err == nil || err.Error() != "the words is not a name length unit, use runes or bytes" {
+		t.Errorf("ApplyConfig() error = %v", err)
+	}
+
+	if err := utils.SetNamePolicy("latin1"); err == nil || err.Error() != "the latin1 is not a name policy, use unicode or ascii" {
+		t.Errorf("SetNamePolicy() error = %v", err)
+	}
+}
+
+func Test_Config(t *testing.T) {
+	// ApplyConfig points AuditLog at the temporary state directory, later
+	// tests must not keep writing there once it's removed
+	savedAuditLog := AuditLog
+	defer func() {
+		if err := ApplyConfig(config.Default()); err != nil {
+			t.Fatalf("ApplyConfig() error = %v", err)
+		}
+		AuditLog = savedAuditLog
+	}()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	os.WriteFile(path, []byte(`{"max_folder_name_length": 5, "time_format": "date", "default_sort": "name:desc"}`), 0o600)
+	t.Setenv("VFS_PROMPT", "vfs> ")
+	t.Setenv("XDG_STATE_HOME", dir)
+	t.Setenv("VFS_MAX_FILE_NAME_LENGTH", "3")
+
+	cfg, err := config.Load(path, true)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if err := ApplyConfig(cfg); err != nil {
+		t.Fatalf("ApplyConfig() error = %v", err)
+	}
+
+	Register([]string{"configuser"})
+	CreateFolder([]string{"configuser", "a"})
+	CreateFolder([]string{"configuser", "b"})
+
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Folder name limit from file", CreateFolder, []string{"configuser", "toolong"}, "", fmt.Errorf("foldername is too long, max length allowed is 5")},
+		{"File name limit from env", CreateFile, []string{"configuser", "a", "four"}, "", fmt.Errorf("filename is too long, max length allowed is 3")},
+		{"Default sort and time format", ListFolders, []string{"configuser"}, "b 2023-01-01 configuser\na 2023-01-01 configuser\n", nil},
+		{"Show", Config, []string{"show"}, `max_username_length "25" default
+max_folder_name_length "5" file
+max_file_name_length "3" env
+name_policy "unicode" default
+name_forbidden "" default
+name_reserved "" default
+name_length_unit "" default
+time_format "date" file
+timezone "Local" default
+default_sort "name:desc" file
+output "plain" default
+prompt "vfs> " env
+history_file "" default
+data_file "" default
+audit_file "` + filepath.Join(dir, "repl-cli-iscoollab", "audit.log") + `" default
+audit_max_size "10485760" default
+audit_keep "5" default
+revision_retention "20" default
+admins "" default
+config_file ` + path + "\n", nil},
+		{"Unknown subcommand", Config, []string{"edit"}, "", user.Usage("config")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil) != (tt.expectedError != nil) || (err != nil && err.Error() != tt.expectedError.Error()) {
+				t.Errorf("error = %v, expectedError %v", err, tt.expectedError)
+				return
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+
+	// An invalid setting leaves every other one as it was
+	bad := cfg
+	bad.NamePolicy, bad.Output, bad.TimeZone = "ascii", "json", "Mars/Olympus"
+	if err := ApplyConfig(bad); !errors.Is(err, user.UsageError) {
+		t.Errorf("ApplyConfig() error = %v, expected usage", err)
+	}
+	if utils.Policy.ASCII || Output != "plain" || TimeFormat != time.DateOnly || config.Current.TimeZone != cfg.TimeZone {
+		t.Errorf("ApplyConfig() applied part of an invalid config: ascii %v, output %s, time format %s", utils.Policy.ASCII, Output, TimeFormat)
+	}
+
+	errorTests := []struct {
+		name          string
+		content       string
+		env           string
+		expectedError string
+	}{
+		{"Unknown setting", `{"max_name": 3}`, "", "is not a valid config file"},
+		{"Bad env number", `{}`, "abc", "the abc of VFS_MAX_USERNAME_LENGTH is not a number"},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.WriteFile(path, []byte(tt.content), 0o600)
+			if tt.env != "" {
+				t.Setenv("VFS_MAX_USERNAME_LENGTH", tt.env)
+			}
+			_, err := config.Load(path, true)
+			if err == nil || !strings.Contains(err.Error(), tt.expectedError) {
+				t.Errorf("Load() error = %v, expectedError %v", err, tt.expectedError)
+			}
+		})
+	}
+
+	if _, err := config.Load(filepath.Join(dir, "missing.json"), false); err != nil {
+		t.Errorf("Load() of a missing default file error = %v", err)
+	}
+	cfg = config.Default()
+	cfg.DefaultSort = "owner"
+	if err := ApplyConfig(cfg); err == nil {
+		t.Errorf("ApplyConfig() expected an error for an invalid default sort")
+	}
+}
+
+func Test_Persistence(t *testing.T) {
+	saved, savedBlobs := user.ListUser, user.Blobs
+	defer func() {
+		user.ListUser, user.Blobs = saved, savedBlobs
+		user.Reindex()
+	}()
+
+	Register([]string{"persistuser"})
+	CreateFolder([]string{"persistuser", "Docs", `"project notes"`})
+	CreateFile([]string{"persistuser", "docs", "Plan.txt"})
+	WriteFile([]string{"persistuser", "docs", "plan.txt", `"launch on monday"`})
+	Tag([]string{"persistuser", "docs", "plan.txt", "draft"})
+	SetAttr([]string{"persistuser", "docs", "plan.txt", "owner", "ann"})
+	before, _ := user.GetUser("persistuser")
+	seq := before.Folders["docs"].Files["plan.txt"].Seq
+
+	path := filepath.Join(t.TempDir(), "data", "state.json")
+	if err := user.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	user.ListUser = make(map[string]*user.User)
+	if err := user.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	after, err := user.GetUser("PersistUser")
+	if err != nil {
+		t.Fatalf("GetUser() error = %v", err)
+	}
+	file := after.Folders["docs"].Files["plan.txt"]
+	if file.Name != "Plan.txt" || file.Content != "launch on monday" || file.Seq != seq || after.Folders["docs"].Description != `"project notes"` {
+		t.Errorf("Load() file = %+v", file)
+	}
+
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+	}{
+		{"Tag index is rebuilt", ListFiles, []string{"persistuser", "docs", "--tag", "draft"}, "Plan.txt 2023-01-01 15:00:00 persistuser draft owner=ann\n"},
+		{"Search index is rebuilt", Search, []string{"persistuser", "monday"}, "persistuser/Docs/Plan.txt file"},
+		{"Attributes are searchable", Search, []string{"persistuser", "ann"}, "persistuser/Docs/Plan.txt file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if err != nil || !strings.HasPrefix(output, tt.expectedOutput) {
+				t.Errorf("output = %v, error = %v, expectedOutput %v", output, err, tt.expectedOutput)
+			}
+		})
+	}
+
+	CreateFolder([]string{"persistuser", "later"})
+	if later := after.Folders["later"]; later.Seq <= seq {
+		t.Errorf("Seq after Load() = %d, expected more than %d", later.Seq, seq)
+	}
+}
+
+func Test_ErrorKinds(t *testing.T) {
+	Register([]string{"erroruser"})
+	CreateFolder([]string{"erroruser", "docs"})
+	CreateFile([]string{"erroruser", "docs", "plan"})
+
+	tests := []struct {
+		name       string
+		command    func([]string) (string, error)
+		args       []string
+		kind       user.Kind
+		code       string
+		exitStatus int
+	}{
+		{"Missing user", ListFolders, []string{"nobody"}, user.NotFound, "not_found", 3},
+		{"Missing attribute", GetAttr, []string{"erroruser", "docs", "color"}, user.NotFound, "not_found", 3},
+		{"Duplicate folder", CreateFolder, []string{"erroruser", "Docs"}, user.AlreadyExists, "already_exists", 4},
+		{"Invalid name", CreateFolder, []string{"erroruser", "a@b"}, user.InvalidName, "invalid_name", 5},
+		{"Reserved name", CreateFolder, []string{"erroruser", "."}, user.InvalidName, "invalid_name", 5},
+		{"Too long", Register, []string{strings.Repeat("a", 26)}, user.TooLong, "too_long", 6},
+		{"Usage", ListFolders, []string{}, user.UsageError, "usage", 2},
+		{"Bad sort key", ListFolders, []string{"erroruser", "--sort", "owner"}, user.UsageError, "usage", 2},
+		{"Bad flag value", ListFiles, []string{"erroruser", "docs", "--limit", "0"}, user.UsageError, "usage", 2},
+		{"Bad time", Find, []string{"erroruser", "--created-after", "yesterday"}, user.UsageError, "usage", 2},
+		{"Bad depth", Tree, []string{"erroruser", "--depth", "-1"}, user.UsageError, "usage", 2},
+		{"Bad revision", Cat, []string{"erroruser", "docs", "plan", "--rev", "x"}, user.UsageError, "usage", 2},
+		{"Bad output format", Set, []string{"output", "xml"}, user.UsageError, "usage", 2},
+		{"Bad conflict policy", Sync, []string{"erroruser/docs", "/tmp", "--conflict", "mine"}, user.UsageError, "usage", 2},
+		{"Bad interval", Sync, []string{"erroruser/docs", "/tmp", "--watch", "--interval", "soon"}, user.UsageError, "usage", 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.command(tt.args)
+			if !errors.Is(err, tt.kind) {
+				t.Fatalf("error = %v, expected kind %s", err, tt.kind.Code())
+			}
+			var typed *user.Error
+			if !errors.As(err, &typed) || typed.Kind != tt.kind {
+				t.Errorf("errors.As() = %v, expected a *user.Error", err)
+			}
+			if code := user.ErrorCode(err); code != tt.code {
+				t.Errorf("ErrorCode() = %v, expected %v", code, tt.code)
+			}
+			if status := user.ExitStatus(err); status != tt.exitStatus {
+				t.Errorf("ExitStatus() = %v, expected %v", status, tt.exitStatus)
+			}
+		})
+	}
+
+	// Only the usage of a command is printed without the "Error: " prefix
+	_, err := ListFiles([]string{"erroruser", "docs", "--limit", "0"})
+	if rendered := RenderError(err); rendered != "Error: the 0 is not a valid limit\n" {
+		t.Errorf("RenderError() = %q", rendered)
+	}
+	if rendered := RenderError(user.Usage("gc")); rendered != "Usage: gc\n" {
+		t.Errorf("RenderError() = %q", rendered)
+	}
+
+	// Errors outside the commands themselves are typed too
+	bad := config.Default()
+	bad.AuditKeep = -1
+	if err := ApplyConfig(bad); !errors.Is(err, user.UsageError) {
+		t.Errorf("ApplyConfig() error = %v, expected usage", err)
+	}
+	if _, _, err := parseLineRange("9", 3); !errors.Is(err, user.UsageError) {
+		t.Errorf("parseLineRange() error = %v, expected usage", err)
+	}
+
+	err = errors.New("disk full")
+	if errors.Is(err, user.UsageError) || user.ErrorCode(err) != "error" || user.ExitStatus(err) != 1 {
+		t.Errorf("untyped error = %v, code %v, status %v", err, user.ErrorCode(err), user.ExitStatus(err))
+	}
+	if user.ExitStatus(nil) != 0 {
+		t.Errorf("ExitStatus(nil) = %v, expected 0", user.ExitStatus(nil))
+	}
+}
+
+func Test_Audit(t *testing.T) {
+	dir := t.TempDir()
+	AuditLog = &audit.Log{Path: filepath.Join(dir, "audit.log"), MaxSize: 400, Keep: 2}
+	defer func() { AuditLog = nil; Actor = defaultActor() }()
+
+	clock := user.NewFakeClock(time.Date(2024, time.March, 1, 9, 0, 0, 0, time.Local))
+	user.SetClock(clock)
+	defer user.SetClock(user.NewFakeClock(testTime))
+
+	run := func(name string, command func([]string) (string, error), args ...string) {
+		clock.Advance(time.Minute)
+		_, err := command(args)
+		if err := Record(name, args, err); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	Actor = "admin"
+	Set([]string{"actor", "ann"})
+	run("register", Register, "audituser")
+	run("create-folder", CreateFolder, "audituser", "reports")
+	run("list-folders", ListFolders, "audituser")
+	Set([]string{"actor", "bob"})
+	run("delete-folder", DeleteFolder, "audituser", "reports")
+	run("delete-folder", DeleteFolder, "audituser", "reports")
+
+	tests := []struct {
+		name           string
+		args           []string
+		expectedOutput string
+	}{
+		{"Everything oldest first", []string{}, "2024-03-01 09:00:00 admin set actor ann success\n2024-03-01 09:01:00 ann register audituser success\n2024-03-01 09:02:00 ann create-folder audituser reports success\n2024-03-01 09:03:00 ann set actor bob success\n2024-03-01 09:04:00 bob delete-folder audituser reports success\n2024-03-01 09:05:00 bob delete-folder audituser reports failure not_found\n"},
+		{"Who deleted the folder", []string{"--command", "delete-folder", "--user", "AUDITUSER", "--until", `"2024-03-01 09:05:00"`}, "2024-03-01 09:04:00 bob delete-folder audituser reports success\n"},
+		{"By actor and time", []string{"--actor", "ann", "--since", `"2024-03-01 09:02:00"`}, "2024-03-01 09:02:00 ann create-folder audituser reports success\n2024-03-01 09:03:00 ann set actor bob success\n"},
+		{"Actor switches", []string{"--command", `"set actor"`}, "2024-03-01 09:00:00 admin set actor ann success\n2024-03-01 09:03:00 ann set actor bob success\n"},
+		{"Actor switches act on no user", []string{"--user", "bob"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := Audit(tt.args)
+			if err != nil {
+				t.Fatalf("Audit() error = %v", err)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("Audit() output = %v, expectedOutput %v", output, tt.expectedOutput)
+			}
+		})
+	}
+
+	if _, err := os.Stat(AuditLog.Path + ".1"); err != nil {
+		t.Errorf("expected the audit log to be rotated: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		run("create-folder", CreateFolder, "audituser", fmt.Sprintf("f%d", i))
+	}
+	if _, err := os.Stat(AuditLog.Path + ".3"); err == nil {
+		t.Errorf("expected at most 2 rotated audit logs")
+	}
+
+	if _, err := Audit([]string{"extra"}); err == nil || err.Error() != user.CommandsUsage["audit"] {
+		t.Errorf("Audit() error = %v, expectedError %v", err, user.CommandsUsage["audit"])
+	}
+}
+
+func Test_Watch(t *testing.T) {
+	var streamed strings.Builder
+	WatchOutput = &streamed
+	defer func() { WatchOutput = os.Stdout }()
+
+	Register([]string{"watchuser"})
+	Register([]string{"otherwatcher"})
+	CreateFolder([]string{"watchuser", "drafts"})
+	CreateFolder([]string{"watchuser", "notes"})
+
+	if output, err := Watch([]string{"WatchUser", "drafts"}); err != nil || output != "Watching watchuser/drafts, use unwatch to stop\n" {
+		t.Fatalf("Watch() output = %q, error = %v", output, err)
+	}
+	CreateFile([]string{"watchuser", "drafts", "a.txt"})
+	CreateFile([]string{"watchuser", "notes", "b.txt"})
+	CreateFolder([]string{"otherwatcher", "drafts"})
+	RenameFolder([]string{"watchuser", "drafts", "final"})
+	WriteFile([]string{"watchuser", "final", "a.txt", "hello"})
+	DeleteFile([]string{"watchuser", "final", "a.txt"})
+
+	stamp := "[" + formatTime(testTime) + "] "
+	expected := stamp + "file_created watchuser/drafts/a.txt\n" +
+		stamp + "folder_renamed watchuser/final (was drafts)\n" +
+		stamp + "file_written watchuser/final/a.txt\n" +
+		stamp + "file_deleted watchuser/final/a.txt\n"
+	if streamed.String() != expected {
+		t.Errorf("Watch() streamed = %q, expected %q", streamed.String(), expected)
+	}
+
+	if output, err := Unwatch([]string{}); err != nil || output != "Stopped watching\n" {
+		t.Fatalf("Unwatch() output = %q, error = %v", output, err)
+	}
+	streamed.Reset()
+	CreateFile([]string{"watchuser", "final", "c.txt"})
+	if streamed.Len() != 0 {
+		t.Errorf("Unwatch() kept streaming %q", streamed.String())
+	}
+	if _, err := Unwatch([]string{}); !errors.Is(err, user.NotFound) {
+		t.Errorf("Unwatch() error = %v, expected not_found", err)
+	}
+	if _, err := Watch([]string{"watchuser", "missing"}); !errors.Is(err, user.NotFound) {
+		t.Errorf("Watch() error = %v, expected not_found", err)
+	}
+	if _, err := Watch([]string{"a", "b", "c"}); !errors.Is(err, user.UsageError) {
+		t.Errorf("Watch() error = %v, expected usage", err)
+	}
+}
+
+func Test_EventBus(t *testing.T) {
+	Register([]string{"bususer"})
+	CreateFolder([]string{"bususer", "docs"})
+
+	var synced []user.Event
+	unsubscribe := user.Events.Subscribe(func(event user.Event) {
+		if event.Username == "bususer" {
+			synced = append(synced, event)
+		}
+	})
+	var queued []user.Event
+	unsubscribeAsync := user.Events.SubscribeAsync(func(event user.Event) {
+		if event.Username == "bususer" {
+			queued = append(queued, event)
+		}
+	})
+
+	SetAttr([]string{"bususer", "docs", "owner", "ann"})
+	RenameFolder([]string{"bususer", "docs", "Docs2"})
+	DeleteFolder([]string{"bususer", "Docs2"})
+	unsubscribe()
+	// Unsubscribing waits for the queued events to be delivered
+	unsubscribeAsync()
+	CreateFolder([]string{"bususer", "late"})
+
+	for name, events := range map[string][]user.Event{"Subscribe": synced, "SubscribeAsync": queued} {
+		if len(events) != 3 {
+			t.Fatalf("%s() received %d events, expected 3", name, len(events))
+		}
+		updated, renamed, deleted := events[0], events[1], events[2]
+		if updated.Type != user.FolderUpdated || updated.Before.Attributes["owner"] != "" || updated.After.Attributes["owner"] != "ann" {
+			t.Errorf("%s() update = %+v", name, updated)
+		}
+		if renamed.Type != user.FolderRenamed || renamed.Before.Name != "docs" || renamed.After.Name != "Docs2" {
+			t.Errorf("%s() rename = %+v", name, renamed)
+		}
+		if deleted.Type != user.FolderDeleted || deleted.Before.Name != "Docs2" || deleted.After != nil {
+			t.Errorf("%s() delete = %+v", name, deleted)
+		}
+	}
+}
+
+func Test_Webhooks(t *testing.T) {
+	previous := Webhooks
+	Webhooks = webhook.NewDispatcher()
+	Webhooks.MaxAttempts = 3
+	Webhooks.BaseDelay = time.Millisecond
+	Webhooks.MaxDelay = 4 * time.Millisecond
+	defer func() { Webhooks = previous }()
+
+	var mu sync.Mutex
+	var payloads []webhook.Payload
+	status := []int{http.StatusInternalServerError}
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhook.Verify("s3cret", body, r.Header.Get(webhook.SignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if len(status) > 0 {
+			w.WriteHeader(status[0])
+			status = status[1:]
+			return
+		}
+		var payload webhook.Payload
+		json.Unmarshal(body, &payload)
+		payloads = append(payloads, payload)
+	}))
+	defer receiver.Close()
+
+	Register([]string{"hookuser"})
+	CreateFolder([]string{"hookuser", "shared"})
+	CreateFolder([]string{"hookuser", "private"})
+
+	if output, err := AddWebhook([]string{receiver.URL, "s3cret", "HookUser", "shared"}); err != nil || output != "Add webhook wh1 for hookuser/shared successfully\n" {
+		t.Fatalf("AddWebhook() output = %q, error = %v", output, err)
+	}
+
+	// The first attempt fails and is retried
+	CreateFile([]string{"hookuser", "shared", "notes.txt"})
+	CreateFile([]string{"hookuser", "private", "diary.txt"})
+	Webhooks.Wait()
+
+	if len(payloads) != 1 || payloads[0].Event.Type != user.FileCreated || payloads[0].Event.Path() != "hookuser/shared/notes.txt" || payloads[0].Event.After == nil {
+		t.Fatalf("webhook payloads = %+v", payloads)
+	}
+	deliveries := Webhooks.Deliveries("wh1", false)
+	if len(deliveries) != 1 || deliveries[0].Status != webhook.Delivered || deliveries[0].Attempts != 2 || deliveries[0].Code != http.StatusOK {
+		t.Errorf("Deliveries() = %+v", deliveries)
+	}
+
+	// A hook with the wrong secret is rejected, which retrying can't fix
+	AddWebhook([]string{receiver.URL, "wrong", "hookuser"})
+	status = []int{http.StatusServiceUnavailable, http.StatusServiceUnavailable, http.StatusServiceUnavailable}
+	RenameFolder([]string{"hookuser", "shared", "team"})
+	Webhooks.Wait()
+
+	expected := "d2 wh1 folder_renamed hookuser/team dead 3 503 " + formatTime(testTime) + " the receiver answered 503 Service Unavailable\n" +
+		"d3 wh2 folder_renamed hookuser/team dead 1 401 " + formatTime(testTime) + " the receiver answered 401 Unauthorized\n"
+	if output, err := WebhookDeliveries([]string{"--dead"}); err != nil || output != expected {
+		t.Errorf("WebhookDeliveries() output = %q, error = %v, expected %q", output, err, expected)
+	}
+
+	if output, err := RedeliverWebhook([]string{"d2"}); err != nil || output != "Redeliver d2 successfully\n" {
+		t.Fatalf("RedeliverWebhook() output = %q, error = %v", output, err)
+	}
+	Webhooks.Wait()
+	if len(payloads) != 2 || payloads[1].Delivery != "d2" || payloads[1].Event.Before.Name != "shared" {
+		t.Errorf("redelivered payloads = %+v", payloads)
+	}
+	if _, err := RedeliverWebhook([]string{"d2"}); !errors.Is(err, user.Conflict) {
+		t.Errorf("RedeliverWebhook() error = %v, expected a conflict for a delivered entry", err)
+	}
+
+	// The hook follows its folder across the rename
+	WriteFile([]string{"hookuser", "team", "notes.txt", "agenda"})
+	Webhooks.Wait()
+	if len(payloads) != 3 || payloads[2].Event.Type != user.FileWritten {
+		t.Errorf("payloads after rename = %+v", payloads)
+	}
+
+	expected = "wh1 " + receiver.URL + " hookuser/team 3 0 0\nwh2 " + receiver.URL + " hookuser 0 0 2\n"
+	if output, err := ListWebhooks([]string{}); err != nil || output != expected {
+		t.Errorf("ListWebhooks() output = %q, error = %v, expected %q", output, err, expected)
+	}
+
+	// The hooks and dead letters are kept in the data file, the delivered
+	// history isn't
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := user.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	Webhooks = webhook.NewDispatcher()
+	Webhooks.MaxAttempts = 3
+	Webhooks.BaseDelay = time.Millisecond
+	Webhooks.MaxDelay = 4 * time.Millisecond
+	if err := user.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	expected = "wh1 " + receiver.URL + " hookuser/team 0 0 0\nwh2 " + receiver.URL + " hookuser 0 0 2\n"
+	if output, err := ListWebhooks([]string{}); err != nil || output != expected {
+		t.Errorf("ListWebhooks() after Load output = %q, error = %v, expected %q", output, err, expected)
+	}
+	if hooks := Webhooks.Hooks(); len(hooks) != 2 || hooks[0].Secret != "s3cret" || hooks[1].Secret != "wrong" {
+		t.Errorf("Hooks() after Load = %+v", hooks)
+	}
+
+	RemoveWebhook([]string{"wh1"})
+	RemoveWebhook([]string{"wh2"})
+	DeleteFile([]string{"hookuser", "team", "notes.txt"})
+	Webhooks.Wait()
+	if len(payloads) != 3 {
+		t.Errorf("removed webhook still received %+v", payloads[3:])
+	}
+
+	if _, err := RemoveWebhook([]string{"wh1"}); !errors.Is(err, user.NotFound) {
+		t.Errorf("RemoveWebhook() error = %v, expected not_found", err)
+	}
+	if _, err := AddWebhook([]string{"ftp://example.com", "s3cret"}); err == nil {
+		t.Errorf("AddWebhook() expected an error for a non http url")
+	}
+	if _, err := AddWebhook([]string{receiver.URL}); !errors.Is(err, user.UsageError) {
+		t.Errorf("AddWebhook() error = %v, expected usage", err)
+	}
+
+	// Waiting for deliveries gives up after the timeout
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	slowHook := Webhooks.Add(slow.URL, "s3cret", "", "")
+	defer Webhooks.Remove(slowHook.ID)
+	CreateFile([]string{"hookuser", "team", "slow.txt"})
+	if Webhooks.WaitTimeout(10*time.Millisecond) || Webhooks.Pending() != 1 {
+		t.Errorf("WaitTimeout() finished with %d pending, expected to give up", Webhooks.Pending())
+	}
+	if !Webhooks.WaitTimeout(2 * time.Second) {
+		t.Errorf("WaitTimeout() expected the delivery to finish")
+	}
+
+	// The secret never reaches the audit log or the history file, and the
+	// user acted on is the one the hook follows
+	AuditLog = &audit.Log{Path: filepath.Join(t.TempDir(), "audit.log")}
+	defer func() { AuditLog = nil }()
+	Record("add-webhook", []string{receiver.URL, "s3cret", "hookuser", "--output", "json"}, nil)
+	Record("remove-webhook", []string{"wh3"}, nil)
+	entries, _ := AuditLog.Query(audit.Filter{})
+	if len(entries) != 2 || !slices.Equal(entries[0].Args, []string{receiver.URL, "[redacted]", "hookuser", "--output", "json"}) || entries[0].User != "hookuser" || entries[1].User != audit.NoUser {
+		t.Errorf("audit entries = %+v", entries)
+	}
+	if line := HistoryLine("add-webhook --output json "+receiver.URL+" s3cret", []string{"add-webhook", "--output", "json", receiver.URL, "s3cret"}); line != "add-webhook --output json "+receiver.URL+" [redacted]" {
+		t.Errorf("HistoryLine() = %q", line)
+	}
+	if line := HistoryLine("list-webhooks  ", []string{"list-webhooks"}); line != "list-webhooks  " {
+		t.Errorf("HistoryLine() = %q", line)
+	}
+
+	for attempt, delay := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 4: 5 * time.Second} {
+		if got := webhook.Backoff(time.Second, 5*time.Second, attempt); got != delay {
+			t.Errorf("Backoff(%d) = %v, expected %v", attempt, got, delay)
+		}
+	}
+}
+
+func Test_Revisions(t *testing.T) {
+	defer func() { Actor = defaultActor() }()
+
+	Register([]string{"revuser"})
+	CreateFolder([]string{"revuser", "docs"})
+	CreateFile([]string{"revuser", "docs", "notes.txt"})
+
+	Set([]string{"actor", "ann"})
+	WriteFile([]string{"revuser", "docs", "notes.txt", "one\ntwo\nthree\n"})
+	Set([]string{"actor", "bob"})
+	WriteFile([]string{"revuser", "docs", "notes.txt", "one\n2\nthree\nfour\n"})
+	WriteFile([]string{"revuser", "docs", "notes.txt", "x"})
+
+	stamp := formatTime(testTime)
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"History newest first", FileHistory, []string{"revuser", "docs", "notes.txt"}, "3 bob " + stamp + " 1\n2 bob " + stamp + " 17\n1 ann " + stamp + " 14\n", nil},
+		{"Cat a revision", Cat, []string{"revuser", "docs", "notes.txt", "--rev", "1"}, "one\ntwo\nthree\n", nil},
+		{"Cat the latest", Cat, []string{"revuser", "docs", "notes.txt"}, "x\n", nil},
+		{"Diff two revisions", DiffFile, []string{"revuser", "docs", "notes.txt", "1", "2"}, "--- notes.txt@1\n+++ notes.txt@2\n@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four\n", nil},
+		{"Diff against the latest", DiffFile, []string{"revuser", "docs", "notes.txt", "2"}, "--- notes.txt@2\n+++ notes.txt@3\n@@ -1,4 +1 @@\n-one\n-2\n-three\n-four\n+x\n", nil},
+		{"Revert", RevertFile, []string{"revuser", "docs", "notes.txt", "1"}, "Revert notes.txt to revision 1 as revision 4 successfully\n", nil},
+		{"Reverted content", Cat, []string{"revuser", "docs", "notes.txt"}, "one\ntwo\nthree\n", nil},
+		{"Equal revisions", DiffFile, []string{"revuser", "docs", "notes.txt", "1", "4"}, "", nil},
+		{"Keep two revisions", SetRetention, []string{"revuser", "docs", "notes.txt", "2"}, "Keep 2 revisions of notes.txt successfully\n", nil},
+		{"Pruned history", FileHistory, []string{"revuser", "docs", "notes.txt"}, "4 bob " + stamp + " 14\n3 bob " + stamp + " 1\n", nil},
+		{"Pruned revision", Cat, []string{"revuser", "docs", "notes.txt", "--rev", "1"}, "", user.Errorf(user.NotFound, "the revision 1 of notes.txt doesn't exist")},
+		{"Invalid revision", DiffFile, []string{"revuser", "docs", "notes.txt", "zero"}, "", fmt.Errorf("the zero is not a valid revision")},
+		{"Missing revision argument", RevertFile, []string{"revuser", "docs", "notes.txt"}, "", user.Usage("revert-file")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil || tt.expectedError != nil) && (err == nil || tt.expectedError == nil || err.Error() != tt.expectedError.Error()) {
+				t.Fatalf("error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("output = %q, expectedOutput %q", output, tt.expectedOutput)
+			}
+		})
+	}
+
+	var from, to []string
+	for i := 1; i <= 20; i++ {
+		from = append(from, fmt.Sprintf("line %d", i))
+		to = append(to, fmt.Sprintf("line %d", i))
+	}
+	to[1], to[17] = "second", "eighteenth"
+	expected := "--- a\n+++ b\n" +
+		"@@ -1,5 +1,5 @@\n line 1\n-line 2\n+second\n line 3\n line 4\n line 5\n" +
+		"@@ -15,6 +15,6 @@\n line 15\n line 16\n line 17\n-line 18\n+eighteenth\n line 19\n line 20\n"
+	if diff := utils.UnifiedDiff("a", "b", strings.Join(from, "\n"), strings.Join(to, "\n")); diff != expected {
+		t.Errorf("UnifiedDiff() = %q, expected %q", diff, expected)
+	}
+	if diff := utils.UnifiedDiff("a", "b", "", "new\nfile\n"); diff != "--- a\n+++ b\n@@ -0,0 +1,2 @@\n+new\n+file\n" {
+		t.Errorf("UnifiedDiff() from empty = %q", diff)
+	}
+}
+
+func Test_Snapshots(t *testing.T) {
+	Register([]string{"snapuser"})
+	CreateFolder([]string{"snapuser", "docs"})
+	CreateFolder([]string{"snapuser", "notes"})
+	CreateFolder([]string{"snapuser", "untouched"})
+	CreateFile([]string{"snapuser", "docs", "a.txt"})
+	CreateFile([]string{"snapuser", "docs", "b.txt"})
+	WriteFile([]string{"snapuser", "docs", "a.txt", "v1"})
+	Tag([]string{"snapuser", "docs", "a.txt", "draft"})
+
+	if output, err := Snapshot([]string{"create", "snapuser", "before"}); err != nil || output != "Create snapshot before of snapuser successfully\n" {
+		t.Fatalf("Snapshot() output = %q, error = %v", output, err)
+	}
+	u, _ := user.GetUser("snapuser")
+	snapshot, _ := u.GetSnapshot("before")
+	if snapshot.Folders["docs"] != u.Folders["docs"] {
+		t.Errorf("a new snapshot should share the live folders")
+	}
+
+	WriteFile([]string{"snapuser", "docs", "a.txt", "v2"})
+	Untag([]string{"snapuser", "docs", "a.txt", "draft"})
+	DeleteFile([]string{"snapuser", "docs", "b.txt"})
+	CreateFile([]string{"snapuser", "docs", "c.txt"})
+	RenameFolder([]string{"snapuser", "docs", "papers"})
+	DeleteFolder([]string{"snapuser", "notes"})
+
+	if snapshot.Folders["untouched"] != u.Folders["untouched"] {
+		t.Errorf("unchanged folders should stay shared with the snapshot")
+	}
+
+	stamp := formatTime(testTime)
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"List a snapshot", ListFiles, []string{"snapuser", "docs", "--snapshot", "before"}, "a.txt " + stamp + " snapuser draft\nb.txt " + stamp + " snapuser\n", nil},
+		{"Filter a snapshot by its own tags", ListFiles, []string{"snapuser", "docs", "--snapshot", "before", "--tag", "draft"}, "a.txt " + stamp + " snapuser draft\n", nil},
+		{"Cat a snapshot", Cat, []string{"snapuser", "docs", "a.txt", "--snapshot", "before"}, "v1\n", nil},
+		{"Live tree moved on", ListFiles, []string{"snapuser", "papers"}, "a.txt " + stamp + " snapuser\nc.txt " + stamp + " snapuser\n", nil},
+		{"Live content", Cat, []string{"snapuser", "papers", "a.txt"}, "v2\n", nil},
+		{"List snapshots", Snapshot, []string{"list", "snapuser"}, "before " + stamp + " 3 2\n", nil},
+		{"Missing folder in snapshot", Cat, []string{"snapuser", "papers", "a.txt", "--snapshot", "before"}, "", user.Errorf(user.NotFound, "the papers doesn't exist in snapshot before")},
+		{"Duplicate snapshot", Snapshot, []string{"create", "snapuser", "BEFORE"}, "", user.Errorf(user.AlreadyExists, "the snapshot BEFORE has already existed")},
+		{"Restore", Snapshot, []string{"restore", "snapuser", "before"}, "Restore snapuser from snapshot before successfully\n", nil},
+		{"Restored files", ListFiles, []string{"snapuser", "docs", "--tag", "draft"}, "a.txt " + stamp + " snapuser draft\n", nil},
+		{"Restored content", Cat, []string{"snapuser", "docs", "a.txt"}, "v1\n", nil},
+		{"Renamed folder is gone", ListFiles, []string{"snapuser", "papers"}, "", user.Errorf(user.NotFound, "the papers doesn't exist")},
+		{"Unknown action", Snapshot, []string{"rename", "snapuser", "before"}, "", user.Usage("snapshot")},
+	}
+	var restored []string
+	unsubscribe := user.Events.Subscribe(func(event user.Event) {
+		if event.Username == "snapuser" {
+			restored = append(restored, string(event.Type)+" "+event.Path())
+		}
+	})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if (err != nil || tt.expectedError != nil) && (err == nil || tt.expectedError == nil || err.Error() != tt.expectedError.Error()) {
+				t.Fatalf("error = %v, expectedError %v", err, tt.expectedError)
+			}
+			if output != tt.expectedOutput {
+				t.Errorf("output = %q, expectedOutput %q", output, tt.expectedOutput)
+			}
+		})
+	}
+	unsubscribe()
+
+	// The restore publishes the folders it replaced, the shared untouched one didn't change
+	if expected := "folder_created snapuser/docs,folder_created snapuser/notes,folder_deleted snapuser/papers"; strings.Join(restored, ",") != expected {
+		t.Errorf("restore events = %v, expected %v", restored, expected)
+	}
+
+	// Changes after a restore don't leak into the snapshot either
+	WriteFile([]string{"snapuser", "docs", "a.txt", "v3"})
+	if output, _ := Cat([]string{"snapuser", "docs", "a.txt", "--snapshot", "before"}); output != "v1\n" {
+		t.Errorf("Cat() snapshot after restore = %q", output)
+	}
+
+	err := ReadOnly("write-file", []string{"snapuser", "docs", "a.txt", "x", "--snapshot", "before"})
+	if !errors.Is(err, user.PermissionDenied) || user.ExitStatus(err) != 7 {
+		t.Errorf("ReadOnly() error = %v, expected permission_denied", err)
+	}
+	if err := ReadOnly("cat", []string{"snapuser", "docs", "a.txt", "--snapshot", "before"}); err != nil {
+		t.Errorf("ReadOnly() error = %v for a read", err)
+	}
+
+	if output, err := Snapshot([]string{"delete", "snapuser", "before"}); err != nil || output != "Delete snapshot before successfully\n" {
+		t.Fatalf("Snapshot() output = %q, error = %v", output, err)
+	}
+	if _, err := Cat([]string{"snapuser", "docs", "a.txt", "--snapshot", "before"}); !errors.Is(err, user.NotFound) {
+		t.Errorf("Cat() error = %v, expected not_found", err)
+	}
+}
+
+func Test_ImportDir(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "proj")
+	mtime := time.Date(2020, time.May, 1, 10, 0, 0, 0, time.Local)
+	for path, content := range map[string]string{
+		"README.md":     "HI",
+		"readme.md":     "duplicate",
+		"bad?name.txt":  "x",
+		".env":          "secret",
+		"src/main.go":   "package main",
+		"sub dir/x.txt": "x",
+	} {
+		path = filepath.Join(root, path)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte(content), 0o644)
+		os.Chtimes(path, mtime, mtime)
+	}
+
+	Register([]string{"importuser"})
+
+	expected := "folder . proj\n" +
+		"file README.md proj/README.md 2 bytes\n" +
+		"file bad?name.txt proj/bad_name.txt 1 byte\n" +
+		"folder src proj.src\n" +
+		"file src/main.go proj.src/main.go 12 bytes\n" +
+		"folder sub dir proj.sub_dir\n" +
+		"file sub dir/x.txt proj.sub_dir/x.txt 1 byte\n" +
+		"skip .env hidden, use --hidden to import it\n" +
+		"skip readme.md the file proj/readme.md is already imported from README.md\n" +
+		"Dry run: would import 3 folders and 4 files into importuser, 2 skipped\n"
+	if output, err := ImportDir([]string{root, "importuser", "--dry-run"}); err != nil || output != expected {
+		t.Fatalf("ImportDir() output = %q, error = %v, expected %q", output, err, expected)
+	}
+	u, _ := user.GetUser("importuser")
+	if len(u.Folders) != 0 {
+		t.Fatalf("a dry run imported %d folders", len(u.Folders))
+	}
+
+	expected = "folder . proj\n" +
+		"file README.md proj/README.md 2 bytes\n" +
+		"folder src proj.src\n" +
+		"file src/main.go proj.src/main.go 12 bytes\n" +
+		"skip .env hidden, use --hidden to import it\n" +
+		"skip bad?name.txt the bad?name.txt is not a valid name\n" +
+		"skip readme.md the file proj/readme.md is already imported from README.md\n" +
+		"skip sub dir the proj.sub dir is not a valid name\n" +
+		"Dry run: would import 2 folders and 2 files into importuser, 4 skipped\n"
+	if output, err := ImportDir([]string{root, "importuser", "--dry-run", "--sanitize", "skip"}); err != nil || output != expected {
+		t.Fatalf("ImportDir() output = %q, error = %v, expected %q", output, err, expected)
+	}
+
+	if output, err := ImportDir([]string{root, "importuser", "project"}); err != nil || !strings.HasSuffix(output, "Import 3 folders and 4 files into importuser successfully, 2 skipped\n") {
+		t.Fatalf("ImportDir() output = %q, error = %v", output, err)
+	}
+	folder, err := u.GetFolder("project.sub_dir")
+	if err != nil {
+		t.Fatalf("GetFolder() error = %v", err)
+	}
+	file, _ := folder.GetFile("x.txt")
+	if file == nil || file.Content != "x" || !file.CreatedAt.Equal(mtime) {
+		t.Errorf("imported file = %+v, expected the host content and mtime", file)
+	}
+	if output, _ := Cat([]string{"importuser", "project", "README.md"}); output != "HI\n" {
+		t.Errorf("Cat() imported = %q", output)
+	}
+
+	// Importing again adds nothing over the existing files
+	output, err := ImportDir([]string{root, "importuser", "project"})
+	if err != nil || !strings.HasSuffix(output, "Import 3 folders and 0 files into importuser successfully, 6 skipped\n") || !strings.Contains(output, "skip src/main.go the file project.src/main.go already exists\n") {
+		t.Errorf("ImportDir() again output = %q, error = %v", output, err)
+	}
+
+	if _, err := ImportDir([]string{filepath.Join(root, "missing"), "importuser"}); !errors.Is(err, user.NotFound) {
+		t.Errorf("ImportDir() error = %v, expected not_found", err)
+	}
+	if _, err := ImportDir([]string{root, "importuser", "--sanitize", "drop"}); err == nil || err.Error() != "the drop is not a sanitize mode, use one of replace, skip" {
+		t.Errorf("ImportDir() error = %v", err)
+	}
+}
+
+func Test_Export(t *testing.T) {
+	created := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.UTC)
+	modified := time.Date(2022, time.August, 9, 10, 11, 12, 0, time.UTC)
+
+	Register([]string{"exportuser"})
+	u, _ := user.GetUser("exportuser")
+	u.CreateFolder("docs", "Project docs")
+	u.CreateFolder("empty", "")
+	docs, _ := u.GetFolder("docs")
+	docs.SetAttr("owner", "ann")
+	u.TagFolder("docs", "work")
+	docs.CreateFile("notes.txt", "Meeting notes")
+	notes, _ := docs.GetFile("notes.txt")
+	notes.Write("hello\nworld\n", "ann")
+	notes.SetAttr("lang", "en")
+	u.TagFile("docs", "notes.txt", "draft")
+	notes.SetTimes(created, modified)
+	docs.SetTimes(created, modified)
+
+	// describe lists what an export has to keep, in a stable order
+	describe := func(u *user.User) string {
+		var lines []string
+		stamp := func(t time.Time) string { return t.UTC().Format(time.RFC3339Nano) }
+		for _, folder := range u.Folders {
+			lines = append(lines, fmt.Sprintf("%s|%s|%v|%v|%s|%s", folder.Name, folder.Description, folder.ListAttrs(), folder.Tags, stamp(folder.CreatedAt), stamp(folder.ModifiedAt)))
+			for _, file := range folder.Files {
+				lines = append(lines, fmt.Sprintf("%s/%s|%s|%q|%v|%v|%s|%s", folder.Name, file.Name, file.Description, file.Content, file.ListAttrs(), file.Tags, stamp(file.CreatedAt), stamp(file.ModifiedAt)))
+			}
+		}
+		slices.Sort(lines)
+		return strings.Join(lines, "\n")
+	}
+	expected := describe(u)
+
+	dir := t.TempDir()
+	for i, format := range ExportFormats {
+		target := filepath.Join(dir, "export")
+		if format != "dir" {
+			target += "." + format
+		}
+
+		output, err := Export([]string{"exportuser", target, "--format", format})
+		if want := fmt.Sprintf("Export 2 folders and 1 file of exportuser to %s successfully\n", target); err != nil || output != want {
+			t.Fatalf("Export(%s) output = %q, error = %v, expected %q", format, output, err, want)
+		}
+
+		username := fmt.Sprintf("roundtrip%d", i)
+		Register([]string{username})
+		if _, err := ImportDir([]string{target, username}); err != nil {
+			t.Fatalf("ImportDir(%s) error = %v", format, err)
+		}
+		imported, _ := user.GetUser(username)
+		if got := describe(imported); got != expected {
+			t.Errorf("ImportDir(%s) imported\n%s\nexpected\n%s", format, got, expected)
+		}
+	}
+
+	if _, err := Export([]string{"exportuser", filepath.Join(dir, "export")}); !errors.Is(err, user.AlreadyExists) {
+		t.Errorf("Export() error = %v, expected already_exists", err)
+	}
+	if _, err := Export([]string{"exportuser", filepath.Join(dir, "out"), "--format", "rar"}); err == nil || err.Error() != "the rar is not an export format, use one of dir, tar, tar.gz, zip" {
+		t.Errorf("Export() error = %v", err)
+	}
+	if _, err := ImportDir([]string{filepath.Join(dir, "export"), "exportuser", "docs"}); err == nil {
+		t.Error("ImportDir() into a named folder of an export succeeded")
+	}
+
+	// Archive entries may not point outside the directory they are extracted to
+	evil := filepath.Join(dir, "evil.tar")
+	out, _ := os.Create(evil)
+	tw := tar.NewWriter(out)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: "../evil.txt", Mode: 0o644, Size: 1})
+	tw.Write([]byte("x"))
+	tw.Close()
+	out.Close()
+	if _, err := ImportDir([]string{evil, "exportuser"}); err == nil || !strings.Contains(err.Error(), "points outside the archive") {
+		t.Errorf("ImportDir() error = %v, expected the entry to be refused", err)
+	}
+}
+
+// lockedBuffer collects output written from another goroutine
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf strings.Builder
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func Test_Sync(t *testing.T) {
+	dir := t.TempDir()
+	hostWrite := func(name string, content string, modTime time.Time) {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(content), 0o644)
+		os.Chtimes(path, modTime, modTime)
+	}
+	hostRead := func(name string) string {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "<" + err.Error() + ">"
+		}
+		return string(content)
+	}
+	summary := func(vfs [3]int, host [3]int, conflicts string) string {
+		return fmt.Sprintf("Sync syncuser/docs with %s: %d created, %d updated, %d deleted in the VFS, %d created, %d updated, %d deleted on the host, %s\n",
+			dir, vfs[0], vfs[1], vfs[2], host[0], host[1], host[2], conflicts)
+	}
+
+	Register([]string{"syncuser"})
+	CreateFolder([]string{"syncuser", "docs"})
+	CreateFolder([]string{"syncuser", "other"})
+	CreateFile([]string{"syncuser", "docs", "a.txt"})
+	WriteFile([]string{"syncuser", "docs", "a.txt", "vfs a"})
+	hostWrite("b.txt", "host b", testTime)
+	hostWrite(".hidden", "ignored", testTime)
+	hostWrite("bad?name", "ignored", testTime)
+
+	tests := []struct {
+		name           string
+		prepare        func()
+		args           []string
+		expectedOutput string
+	}{
+		{
+			name:    "first pass copies each side to the other",
+			prepare: func() {},
+			args:    []string{"syncuser/docs", dir},
+			expectedOutput: "skip host bad?name not a valid file name\n" +
+				"created host a.txt 5 bytes\n" +
+				"created vfs b.txt 6 bytes\n" +
+				summary([3]int{1, 0, 0}, [3]int{1, 0, 0}, "0 conflicts"),
+		},
+		{
+			name:           "nothing changed",
+			prepare:        func() {},
+			args:           []string{"SyncUser/Docs", dir},
+			expectedOutput: "skip host bad?name not a valid file name\n" + summary([3]int{}, [3]int{}, "0 conflicts"),
+		},
+		{
+			name: "changes on both sides",
+			prepare: func() {
+				WriteFile([]string{"syncuser", "docs", "a.txt", "vfs a2"})
+				hostWrite("b.txt", "host b2", testTime.Add(time.Hour))
+			},
+			args: []string{"syncuser/docs", dir},
+			expectedOutput: "skip host bad?name not a valid file name\n" +
+				"updated host a.txt 6 bytes\n" +
+				"updated vfs b.txt 7 bytes\n" +
+				summary([3]int{0, 1, 0}, [3]int{0, 1, 0}, "0 conflicts"),
+		},
+		{
+			name: "deletions on both sides",
+			prepare: func() {
+				os.Remove(filepath.Join(dir, "a.txt"))
+				os.Remove(filepath.Join(dir, "bad?name"))
+				DeleteFile([]string{"syncuser", "docs", "b.txt"})
+			},
+			args:           []string{"syncuser/docs", dir},
+			expectedOutput: "deleted vfs a.txt\ndeleted host b.txt\n" + summary([3]int{0, 0, 1}, [3]int{0, 0, 1}, "0 conflicts"),
+		},
+		{
+			name: "conflicts are left alone by default",
+			prepare: func() {
+				CreateFile([]string{"syncuser", "docs", "c.txt"})
+				WriteFile([]string{"syncuser", "docs", "c.txt", "vfs c"})
+				hostWrite("c.txt", "host c", testTime)
+			},
+			args:           []string{"syncuser/docs", dir},
+			expectedOutput: "conflict c.txt changed on both sides, use --conflict vfs, host or newer to resolve it\n" + summary([3]int{}, [3]int{}, "1 conflict"),
+		},
+		{
+			name:           "conflicts resolved for the host",
+			prepare:        func() {},
+			args:           []string{"syncuser/docs", dir, "--conflict", "host"},
+			expectedOutput: "updated vfs c.txt 6 bytes\n" + summary([3]int{0, 1, 0}, [3]int{}, "0 conflicts"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.prepare()
+			output, err := Sync(test.args)
+			if err != nil || output != test.expectedOutput {
+				t.Errorf("Sync() output = %q, error = %v, expected %q", output, err, test.expectedOutput)
+			}
+		})
+	}
+
+	if output, _ := Cat([]string{"syncuser", "docs", "c.txt"}); output != "host c\n" {
+		t.Errorf("Cat() after sync = %q, expected the host content", output)
+	}
+	if content := hostRead("b.txt"); !strings.Contains(content, "no such file") {
+		t.Errorf("b.txt on the host = %q, expected it deleted", content)
+	}
+
+	// Watching picks up later changes until unsync, saving the data file and
+	// recording each pass that changed something
+	streamed := &lockedBuffer{}
+	WatchOutput = streamed
+	dataFile := filepath.Join(t.TempDir(), "state.json")
+	savedConfig := config.Current
+	config.Current.DataFile = dataFile
+	AuditLog = &audit.Log{Path: filepath.Join(t.TempDir(), "audit.log")}
+	defer func() { WatchOutput = os.Stdout; config.Current = savedConfig; AuditLog = nil }()
+
+	output, err := Sync([]string{"syncuser/docs", dir, "--watch", "--interval", "10ms"})
+	if err != nil || !strings.HasSuffix(output, fmt.Sprintf("Syncing syncuser/docs with %s every 10ms, use unsync to stop\n", dir)) {
+		t.Fatalf("Sync() output = %q, error = %v", output, err)
+	}
+	hostWrite("d.txt", "host d", testTime)
+	for deadline := time.Now().Add(2 * time.Second); !strings.Contains(streamed.String(), "created vfs d.txt") && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if output, err := Unsync([]string{"syncuser/docs"}); err != nil || output != "Stopped syncing syncuser/docs\n" {
+		t.Fatalf("Unsync() output = %q, error = %v", output, err)
+	}
+	if !strings.Contains(streamed.String(), "created vfs d.txt 6 bytes\n"+summary([3]int{1, 0, 0}, [3]int{}, "0 conflicts")) {
+		t.Errorf("Sync() streamed = %q, expected d.txt to be created", streamed.String())
+	}
+	if data, err := os.ReadFile(dataFile); err != nil || !strings.Contains(string(data), `"d.txt"`) {
+		t.Errorf("data file after a background pass = %q, error = %v, expected d.txt", data, err)
+	}
+	entries, _ := AuditLog.Query(audit.Filter{Command: "sync"})
+	if len(entries) == 0 || !slices.Equal(entries[0].Args, []string{"syncuser/docs", dir, "--watch"}) || entries[0].Outcome != audit.Success {
+		t.Errorf("audit entries of the background pass = %+v", entries)
+	}
+	if _, err := Unsync([]string{"syncuser/docs"}); !errors.Is(err, user.NotFound) {
+		t.Errorf("Unsync() error = %v, expected not_found", err)
+	}
+
+	// Deleting the synced folder stops the sync after one failed pass
+	CreateFolder([]string{"syncuser", "gone"})
+	goneDir := t.TempDir()
+	if _, err := Sync([]string{"syncuser/gone", goneDir, "--watch", "--interval", "10ms"}); err != nil {
+		t.Fatalf("Sync() error = %v", err)
+	}
+	Session.Lock()
+	DeleteFolder([]string{"syncuser", "gone"})
+	Session.Unlock()
+	for deadline := time.Now().Add(2 * time.Second); !strings.Contains(streamed.String(), "Stopped syncing syncuser/gone\n") && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	Session.Lock()
+	_, stillSyncing := syncing["syncuser/gone"]
+	Session.Unlock()
+	entries, _ = AuditLog.Query(audit.Filter{Command: "sync"})
+	var failed []audit.Entry
+	for _, entry := range entries {
+		if entry.Outcome == audit.Failure {
+			failed = append(failed, entry)
+		}
+	}
+	if stillSyncing || len(failed) != 1 || failed[0].Code != "not_found" {
+		t.Errorf("sync of a deleted folder kept running = %v, failed entries = %+v, streamed %q", stillSyncing, failed, streamed.String())
+	}
+
+	if _, err := Sync([]string{"syncuser/other", dir}); err == nil || !strings.Contains(err.Error(), "is synced with syncuser/docs") {
+		t.Errorf("Sync() error = %v, expected the directory to belong to docs", err)
+	}
+	if _, err := Sync([]string{"syncuser/docs", dir, "--conflict", "mine"}); err == nil || err.Error() != "the mine is not a conflict policy, use one of skip, vfs, host, newer" {
+		t.Errorf("Sync() error = %v", err)
+	}
+	if _, err := Sync([]string{"syncuser", dir}); !errors.Is(err, user.UsageError) {
+		t.Errorf("Sync() error = %v, expected usage", err)
+	}
+}
+
+func Test_Blobs(t *testing.T) {
+	Register([]string{"blobuser"})
+	Register([]string{"blobuser2"})
+	CreateFolder([]string{"blobuser", "docs"})
+	CreateFolder([]string{"blobuser2", "docs"})
+	CreateFile([]string{"blobuser", "docs", "a.txt", "first"})
+	CreateFile([]string{"blobuser2", "docs", "b.txt"})
+	Tag([]string{"blobuser", "docs", "a.txt", "shared"})
+	WriteFile([]string{"blobuser", "docs", "a.txt", "blob shared content"})
+	WriteFile([]string{"blobuser2", "docs", "b.txt", "blob shared content"})
+
+	a, _ := getFile("blobuser", "docs", "a.txt")
+	b, _ := getFile("blobuser2", "docs", "b.txt")
+	hash := a.ContentHash()
+	if hash != user.HashContent("blob shared content") || b.ContentHash() != hash {
+		t.Fatalf("ContentHash() = %s and %s, expected both %s", hash, b.ContentHash(), user.HashContent("blob shared content"))
+	}
+	refs := func(expected int) {
+		t.Helper()
+		if got := user.Blobs.Refs(hash); got != expected {
+			t.Errorf("Refs() = %d, expected %d", got, expected)
+		}
+	}
+	refs(2)
+
+	tests := []struct {
+		name           string
+		command        func([]string) (string, error)
+		args           []string
+		expectedOutput string
+		expectedError  error
+	}{
+		{"Copy a file", CopyFile, []string{"blobuser", "docs", "a.txt", "docs", "c.txt"}, "Copy blobuser/docs/a.txt to blobuser/docs/c.txt successfully\n", nil},
+		{"Copy a folder", CopyFolder, []string{"blobuser", "docs", "docs2"}, "Copy blobuser/docs with 2 files to blobuser/docs2 successfully\n", nil},
+		{"Copy a folder to a taken name", CopyFolder, []string{"blobuser", "docs", "--to-user", "blobuser2"}, "", user.AlreadyExists},
+		{"Copy a file to another user", CopyFile, []string{"blobuser", "docs", "a.txt", "docs", "--to-user", "blobuser2"}, "Copy blobuser/docs/a.txt to blobuser2/docs/a.txt successfully\n", nil},
+		{"Copy a missing file", CopyFile, []string{"blobuser", "docs", "missing.txt", "docs"}, "", user.NotFound},
+		{"Copy without a target", CopyFile, []string{"blobuser", "docs", "a.txt"}, "", user.UsageError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.command(tt.args)
+			if output != tt.expectedOutput || !errors.Is(err, tt.expectedError) {
+				t.Errorf("output = %q, error = %v, expected %q, %v", output, err, tt.expectedOutput, tt.expectedError)
+			}
+		})
+	}
+	refs(6)
+
+	copied, _ := getFile("blobuser", "docs2", "c.txt")
+	if copied.Content != "blob shared content" || copied.Description != "first" || len(copied.Revisions) != 1 || copied.ContentHash() != hash {
+		t.Errorf("copied file = %+v", copied)
+	}
+	if output, _ := ListFiles([]string{"blobuser", "docs2", "--tag", "shared"}); !strings.Contains(output, "a.txt") || !strings.Contains(output, "c.txt") {
+		t.Errorf("ListFiles() of copied tags = %q", output)
+	}
+
+	// Writes only drop a reference once the old revision is pruned
+	WriteFile([]string{"blobuser", "docs", "a.txt", "changed"})
+	refs(6)
+	SetRetention([]string{"blobuser", "docs", "a.txt", "1"})
+	refs(5)
+	DeleteFolder([]string{"blobuser", "docs2"})
+	refs(3)
+	RevertFile([]string{"blobuser2", "docs", "b.txt", "1"})
+	refs(4)
+
+	// A file held by a snapshot keeps its references until the snapshot goes
+	Snapshot([]string{"create", "blobuser", "before"})
+	DeleteFile([]string{"blobuser", "docs", "c.txt"})
+	refs(4)
+	WriteFile([]string{"blobuser2", "docs", "b.txt", "changed"})
+	Snapshot([]string{"delete", "blobuser", "before"})
+	refs(3)
+
+	DeleteFile([]string{"blobuser2", "docs", "a.txt"})
+	DeleteFile([]string{"blobuser2", "docs", "b.txt"})
+	refs(0)
+	if _, exists := user.Blobs.Get(hash); !exists {
+		t.Fatalf("Get() lost an unreferenced blob before gc")
+	}
+	if output, err := GC([]string{}); err != nil || !strings.HasPrefix(output, "Reclaim ") {
+		t.Errorf("GC() output = %q, error = %v", output, err)
+	}
+	if _, exists := user.Blobs.Get(hash); exists {
+		t.Errorf("GC() kept an unreferenced blob")
+	}
+	if content, _ := Cat([]string{"blobuser", "docs", "a.txt"}); content != "changed\n" {
+		t.Errorf("Cat() after GC() = %q", content)
+	}
+}
+
+func Test_BlobPersistence(t *testing.T) {
+	saved, savedBlobs := user.ListUser, user.Blobs
+	defer func() {
+		user.ListUser, user.Blobs = saved, savedBlobs
+		user.Reindex()
+	}()
+	dir := t.TempDir()
+
+	// Files saved before the blob store hold their contents inline
+	legacy := filepath.Join(dir, "legacy.json")
+	os.WriteFile(legacy, []byte(`{"legacyuser": {"Username": "legacyuser", "Folders": {"docs": {"Name": "docs", "Files": {
+		"a.txt": {"Name": "a.txt", "Content": "same"},
+		"b.txt": {"Name": "b.txt", "Content": "same", "Revisions": [{"Number": 1, "Content": "same"}]}}}}}}`), 0o644)
+	if err := user.Load(legacy); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for _, name := range []string{"a.txt", "b.txt"} {
+		file, _ := getFile("legacyuser", "docs", name)
+		if file == nil || file.Content != "same" || file.ContentHash() != user.HashContent("same") {
+			t.Errorf("Load() legacy %s = %+v", name, file)
+		}
+	}
+	if stats := user.Blobs.Stats(); stats.Blobs != 1 || user.Blobs.Refs(user.HashContent("same")) != 2 {
+		t.Errorf("Load() legacy stats = %+v, refs = %d", stats, user.Blobs.Refs(user.HashContent("same")))
+	}
+
+	// Equal contents are saved once
+	path := filepath.Join(dir, "state.json")
+	if err := user.Save(path); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Count(string(data), `"same"`) != 1 {
+		t.Errorf("Save() stored the content %d times", strings.Count(string(data), `"same"`))
+	}
+	if err := user.Load(path); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if output, _ := Cat([]string{"legacyuser", "docs", "b.txt"}); output != "same\n" {
+		t.Errorf("Cat() after Load() = %q", output)
+	}
+}
+
+func Test_Stat(t *testing.T) {
+	savedAdmins := Admins
+	defer func() { Admins = savedAdmins }()
+	Admins = map[string]bool{"statadmin": true, "statuser": true}
+
+	Register([]string{"statuser"})
+	CreateFolder([]string{"statuser", "Docs", `"team docs"`})
+	CreateFolder([]string{"statuser", "empty"})
+	CreateFile([]string{"statuser", "docs", "notes.txt"})
+	WriteFile([]string{"statuser", "docs", "notes.txt", "hello"})
+	Tag([]string{"statuser", "docs", "notes.txt", "draft"})
+	SetAttr([]string{"statuser", "docs", "notes.txt", "owner", "ann"})
+	SetAttr([]string{"statuser", "docs", "notes.txt", "lang", "en"})
+
+	stamp := formatTime(testTime)
+	expected := "name:        notes.txt\n" +
+		"type:        file\n" +
+		"path:        statuser/Docs/notes.txt\n" +
+		"owner:       statuser\n" +
+		"description: -\n" +
+		"created_at:  " + stamp + "\n" +
+		"modified_at: " + stamp + "\n" +
+		"accessed_at: " + stamp + "\n" +
+		"size:        5 bytes\n" +
+		"tags:        draft\n" +
+		"attributes:  lang=en, owner=ann\n" +
+		"revisions:   1\n" +
+		"hash:        sha256:" + user.HashContent("hello") + "\n" +
+		"acl:         statuser:rw, statadmin:r\n"
+	if output, err := Stat([]string{"StatUser/docs/NOTES.txt"}); err != nil || output != expected {
+		t.Errorf("Stat() output = %q, error = %v, expected %q", output, err, expected)
+	}
+
+	if output, _ := Stat([]string{"statuser"}); !strings.Contains(output, "type:        user\n") || !strings.Contains(output, "size:        5 bytes\nfolders:     2\nfiles:       1\n") {
+		t.Errorf("Stat() of a user = %q", output)
+	}
+
+	Output = "json"
+	output, err := Stat([]string{"statuser/docs"})
+	Output = "plain"
+	var info map[string]any
+	if err != nil || json.Unmarshal([]byte(output), &info) != nil {
+		t.Fatalf("Stat() json output = %q, error = %v", output, err)
+	}
+	if info["type"] != "folder" || info["description"] != "team docs" || info["files"] != float64(1) || info["size"] != float64(5) || info["hash"] != nil {
+		t.Errorf("Stat() json = %v", info)
+	}
+	if acl, _ := info["acl"].([]any); len(acl) != 2 || acl[0].(map[string]any)["principal"] != "statuser" || acl[1].(map[string]any)["access"] != "r" {
+		t.Errorf("Stat() json acl = %v", info["acl"])
+	}
+
+	for _, args := range [][]string{{}, {"statuser//notes.txt"}, {"statuser", "docs"}} {
+		if _, err := Stat(args); !errors.Is(err, user.UsageError) {
+			t.Errorf("Stat(%q) error = %v, expected usage", args, err)
+		}
+	}
+	if _, err := Stat([]string{"statuser/docs/missing.txt"}); !errors.Is(err, user.NotFound) {
+		t.Errorf("Stat() error = %v, expected not_found", err)
+	}
+}
+
+func Test_Edit(t *testing.T) {
+	savedInput, savedOutput, savedEditor := Input, EditorOutput, runEditor
+	defer func() { Input, EditorOutput, runEditor = savedInput, savedOutput, savedEditor }()
+	var shown strings.Builder
+	EditorOutput = &shown
+
+	Register([]string{"edituser"})
+	CreateFolder([]string{"edituser", "docs"})
+	CreateFile([]string{"edituser", "docs", "notes.txt"})
+	file, _ := getFile("edituser", "docs", "notes.txt")
+	file.Write("one\ntwo\nthree\n", "test")
+
+	// Without $EDITOR the line editor reads its commands from Input
+	t.Setenv("EDITOR", "")
+	tests := []struct {
+		name           string
+		input          string
+		expectedOutput string
+		expectedText   string
+	}{
+		{"Quit without saving", "c 1\nchanged\n.\nq\n", "Discard the changes to notes.txt\n", "one\ntwo\nthree\n"},
+		{"End of input", "d 1\n", "Discard the changes to notes.txt\n", "one\ntwo\nthree\n"},
+		{"Save unchanged", "p\nw\n", "No changes to notes.txt\n", "one\ntwo\nthree\n"},
+		{
+			"Edit and save",
+			"c 2\nsecond line\n.\na\nfourth\n.\nd 9\nd 1\ni 1\nzero\n.\nw\n",
+			"Write 30 bytes to notes.txt in edituser/docs as revision 2 successfully\n",
+			"zero\nsecond line\nthree\nfourth\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Input = bufio.NewReader(strings.NewReader(tt.input))
+			output, err := Edit([]string{"edituser", "docs", "notes.txt"})
+			if err != nil || output != tt.expectedOutput || file.Content != tt.expectedText {
+				t.Errorf("Edit() output = %q, error = %v, content = %q, expected %q and %q", output, err, file.Content, tt.expectedOutput, tt.expectedText)
+			}
+		})
+	}
+	if !strings.Contains(shown.String(), "the 9 is not a line between 1 and 4, type p to see the lines\n") || !strings.Contains(shown.String(), "1  one\n2  two\n3  three\n") {
+		t.Errorf("Edit() showed %q", shown.String())
+	}
+
+	// i inserts into an empty file and after the last line with one past it
+	CreateFile([]string{"edituser", "docs", "empty.txt"})
+	Input = bufio.NewReader(strings.NewReader("i 2\ni 1\nfirst\n.\ni 2\nlast\n.\nw\n"))
+	if output, err := Edit([]string{"edituser", "docs", "empty.txt"}); err != nil || output != "Write 11 bytes to empty.txt in edituser/docs as revision 1 successfully\n" {
+		t.Errorf("Edit() output = %q, error = %v", output, err)
+	}
+	if output, _ := Cat([]string{"edituser", "docs", "empty.txt"}); output != "first\nlast\n" {
+		t.Errorf("Cat() after inserting = %q", output)
+	}
+	if !strings.Contains(shown.String(), "the 2 is not a line between 1 and 1, type p to see the lines\n") {
+		t.Errorf("Edit() showed %q", shown.String())
+	}
+
+	// $EDITOR runs on a temporary copy of the content
+	script := filepath.Join(t.TempDir(), "editor.sh")
+	os.WriteFile(script, []byte("#!/bin/sh\nprintf 'from editor\\n' >> \"$1\"\n"), 0o755)
+	t.Setenv("EDITOR", script)
+	runEditor = savedEditor
+	if output, err := Edit([]string{"edituser", "docs", "notes.txt"}); err != nil || output != "Write 42 bytes to notes.txt in edituser/docs as revision 3 successfully\n" || !strings.HasSuffix(file.Content, "fourth\nfrom editor\n") {
+		t.Errorf("Edit() output = %q, error = %v, content = %q", output, err, file.Content)
+	}
+
+	// A change made while editing is never overwritten, the edit is kept aside
+	t.Setenv("EDITOR", "fake")
+	LockSession()
+	runEditor = func(editor string, path string) error {
+		if !Session.TryLock() {
+			t.Error("Edit() held the session while editing")
+		} else {
+			Session.Unlock()
+		}
+		file.Write("changed meanwhile", "sync")
+		return os.WriteFile(path, []byte("mine"), 0o644)
+	}
+	_, err := Edit([]string{"edituser", "docs", "notes.txt"})
+	if !sessionHeld {
+		t.Error("Edit() didn't take the session back")
+	}
+	UnlockSession()
+	if !errors.Is(err, user.Conflict) || !strings.Contains(err.Error(), "was changed while editing") || file.Content != "changed meanwhile" {
+		t.Fatalf("Edit() error = %v, content = %q, expected a conflict", err, file.Content)
+	}
+	kept := err.Error()[strings.LastIndex(err.Error(), " ")+1:]
+	defer os.Remove(kept)
+	if content, _ := os.ReadFile(kept); string(content) != "mine" {
+		t.Errorf("Edit() kept %q in %s", content, kept)
+	}
+
+	// Writing the same content meanwhile is a change too
+	runEditor = func(editor string, path string) error {
+		file.Write(file.Content, "sync")
+		return os.WriteFile(path, []byte("mine"), 0o644)
+	}
+	if _, err := Edit([]string{"edituser", "docs", "notes.txt"}); !errors.Is(err, user.Conflict) || file.Content != "changed meanwhile" {
+		t.Errorf("Edit() error = %v, content = %q, expected a conflict", err, file.Content)
+	} else {
+		os.Remove(err.Error()[strings.LastIndex(err.Error(), " ")+1:])
+	}
+
+	runEditor = func(editor string, path string) error {
+		DeleteFile([]string{"edituser", "docs", "notes.txt"})
+		return nil
+	}
+	if _, err := Edit([]string{"edituser", "docs", "notes.txt"}); !errors.Is(err, user.Conflict) || !strings.Contains(err.Error(), "was deleted while editing") {
+		t.Errorf("Edit() error = %v, expected a conflict", err)
+	} else {
+		os.Remove(err.Error()[strings.LastIndex(err.Error(), " ")+1:])
+	}
+
+	if _, err := Edit([]string{"edituser", "docs"}); !errors.Is(err, user.UsageError) {
+		t.Errorf("Edit() error = %v, expected usage", err)
+	}
+	if _, err := Edit([]string{"edituser", "docs", "missing.txt"}); !errors.Is(err, user.NotFound) {
+		t.Errorf("Edit() error = %v, expected not_found", err)
+	}
+}
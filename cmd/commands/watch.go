@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"repl-cli-iscoollab/internal/user"
+	"strings"
+)
+
+var (
+	// WatchOutput receives the events streamed by watch
+	WatchOutput io.Writer = os.Stdout
+
+	// stopWatch unsubscribes the running watch, nil when nothing is watched
+	stopWatch func()
+)
+
+// Watch streams every change of the user, or of one of its folders, to
+// WatchOutput while the session goes on, replacing any running watch.
+// Without arguments every user is watched
+func Watch(args []string) (string, error) {
+	if len(args) > 2 {
+		return "", user.Usage("watch")
+	}
+
+	var username, folderName string
+	target := "every user"
+	if len(args) > 0 {
+		u, err := user.GetUser(args[0])
+		if err != nil {
+			return "", err
+		}
+		username, target = u.Username, u.Username
+	}
+	if len(args) > 1 {
+		u, _ := user.GetUser(username)
+		folder, err := u.GetFolder(args[1])
+		if err != nil {
+			return "", err
+		}
+		folderName = folder.Name
+		target += "/" + folder.Name
+	}
+
+	if stopWatch != nil {
+		stopWatch()
+	}
+	stopWatch = user.Events.Subscribe(func(event user.Event) {
+		if username != "" && !strings.EqualFold(event.Username, username) {
+			return
+		}
+		// Follow the folder across renames
+		if folderName != "" {
+			if !watchesFolder(event, folderName) {
+				return
+			}
+			if event.Type == user.FolderRenamed {
+				folderName = event.Folder
+			}
+		}
+		fmt.Fprint(WatchOutput, formatEvent(event))
+	})
+
+	return renderMessage(fmt.Sprintf("Watching %s, use unwatch to stop", target)), nil
+}
+
+// Unwatch stops the running watch
+func Unwatch(args []string) (string, error) {
+	if len(args) != 0 {
+		return "", user.Usage("unwatch")
+	}
+	if stopWatch == nil {
+		return "", user.Errorf(user.NotFound, "nothing is being watched")
+	}
+
+	stopWatch()
+	stopWatch = nil
+	return renderMessage("Stopped watching"), nil
+}
+
+func watchesFolder(event user.Event, folderName string) bool {
+	if strings.EqualFold(event.Folder, folderName) {
+		return true
+	}
+	return event.Type == user.FolderRenamed && event.Before != nil && strings.EqualFold(event.Before.Name, folderName)
+}
+
+// formatEvent renders an event as one line, a JSON object in json mode
+func formatEvent(event user.Event) string {
+	if Output == "json" {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return fmt.Sprintf("{\"status\":\"error\",\"error\":%q}\n", err.Error())
+		}
+		return string(data) + "\n"
+	}
+
+	line := fmt.Sprintf("[%s] %s %s", formatTime(event.Time), event.Type, event.Path())
+	if event.Type == user.FolderRenamed && event.Before != nil {
+		line += " (was " + event.Before.Name + ")"
+	}
+	return line + "\n"
+}
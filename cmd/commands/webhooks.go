@@ -0,0 +1,160 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"repl-cli-iscoollab/internal/user"
+	"repl-cli-iscoollab/internal/webhook"
+	"strconv"
+)
+
+// Webhooks posts changes to the registered hooks
+var Webhooks = webhook.NewDispatcher()
+
+// The hooks and the deliveries not delivered yet are kept in the data file
+func init() {
+	user.Sections["webhooks"] = user.Section{
+		Save: func() (json.RawMessage, error) {
+			return json.Marshal(Webhooks.State())
+		},
+		Load: func(data json.RawMessage) error {
+			var state webhook.State
+			if err := json.Unmarshal(data, &state); err != nil {
+				return err
+			}
+			Webhooks.Restore(state)
+			return nil
+		},
+	}
+}
+
+// AddWebhook registers a receiver for the changes of every user, of one
+// user or of one of its folders
+func AddWebhook(args []string) (string, error) {
+	if len(args) < 2 || len(args) > 4 {
+		return "", user.Usage("add-webhook")
+	}
+
+	target, secret := unquote(args[0]), unquote(args[1])
+	if parsed, err := url.Parse(target); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", user.Errorf(user.UsageError, "the %s is not a valid webhook url, use http:// or https://", target)
+	}
+	if secret == "" {
+		return "", user.Errorf(user.UsageError, "the webhook secret can't be empty")
+	}
+
+	var username, folderName string
+	if len(args) > 2 {
+		u, err := user.GetUser(args[2])
+		if err != nil {
+			return "", err
+		}
+		username = u.Username
+
+		if len(args) > 3 {
+			folder, err := u.GetFolder(args[3])
+			if err != nil {
+				return "", err
+			}
+			folderName = folder.Name
+		}
+	}
+
+	hook := Webhooks.Add(target, secret, username, folderName)
+	return renderMessage(fmt.Sprintf("Add webhook %s for %s successfully", hook.ID, hook.Scope())), nil
+}
+
+// ListWebhooks shows each hook with how many of its deliveries succeeded,
+// are still being attempted and ended as dead letters
+func ListWebhooks(args []string) (string, error) {
+	if len(args) != 0 {
+		return "", user.Usage("list-webhooks")
+	}
+
+	rows := make([][]string, 0)
+	for _, hook := range Webhooks.Hooks() {
+		counts := make(map[string]int)
+		for _, delivery := range Webhooks.Deliveries(hook.ID, false) {
+			counts[delivery.Status]++
+		}
+		rows = append(rows, []string{
+			hook.ID,
+			hook.URL,
+			hook.Scope(),
+			strconv.Itoa(counts[webhook.Delivered]),
+			strconv.Itoa(counts[webhook.Pending]),
+			strconv.Itoa(counts[webhook.Dead]),
+		})
+	}
+
+	return renderTable([]string{"id", "url", "scope", "delivered", "pending", "dead"}, rows), nil
+}
+
+func RemoveWebhook(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", user.Usage("remove-webhook")
+	}
+
+	if err := Webhooks.Remove(args[0]); err != nil {
+		return "", err
+	}
+	return renderMessage(fmt.Sprintf("Remove webhook %s successfully", args[0])), nil
+}
+
+// WebhookDeliveries shows the status of the deliveries of one or every
+// hook, --dead restricts it to the dead letters
+func WebhookDeliveries(args []string) (string, error) {
+	var dead bool
+	rest := make([]string, 0, len(args))
+	for _, arg := range args {
+		if arg == "--dead" {
+			dead = true
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+	args = rest
+
+	if len(args) > 1 {
+		return "", user.Usage("webhook-deliveries")
+	}
+
+	var hookID string
+	if len(args) == 1 {
+		hookID = args[0]
+	}
+
+	rows := make([][]string, 0)
+	for _, delivery := range Webhooks.Deliveries(hookID, dead) {
+		code := ""
+		if delivery.Code != 0 {
+			code = strconv.Itoa(delivery.Code)
+		}
+		rows = append(rows, []string{
+			delivery.ID,
+			delivery.Hook,
+			string(delivery.Event.Type),
+			delivery.Event.Path(),
+			delivery.Status,
+			strconv.Itoa(delivery.Attempts),
+			code,
+			formatTime(delivery.UpdatedAt),
+			delivery.Error,
+		})
+	}
+
+	return renderTable([]string{"id", "webhook", "event", "path", "status", "attempts", "code", "updated_at", "error"}, rows), nil
+}
+
+// RedeliverWebhook sends a dead letter again
+func RedeliverWebhook(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", user.Usage("redeliver-webhook")
+	}
+
+	if err := Webhooks.Redeliver(args[0]); err != nil {
+		return "", err
+	}
+	return renderMessage(fmt.Sprintf("Redeliver %s successfully", args[0])), nil
+}
@@ -1,3 +1,5 @@
 module repl-cli-iscoollab
 
 go 1.23.1
+
+require golang.org/x/text v0.28.0
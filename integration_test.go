@@ -2,6 +2,7 @@ package main
 
 import (
 	"repl-cli-iscoollab/cmd/commands"
+	"repl-cli-iscoollab/internal/user"
 	"strings"
 	"testing"
 	"time"
@@ -11,7 +12,13 @@ import (
 // using the example usage provided in the assignment PDF.
 // This is not an exhaustive test but demonstrates the basic
 // functionality and interaction between different commands.
+// The clock is frozen and advanced by 10 seconds before every
+// command so the listed timestamps are known exactly.
 func Test_Integration(t *testing.T) {
+	clock := user.NewFakeClock(time.Date(2023, time.January, 1, 15, 0, 0, 0, time.Local))
+	user.SetClock(clock)
+	defer user.SetClock(nil)
+
 	tests := []struct {
 		name     string
 		input    string
@@ -23,19 +30,23 @@ func Test_Integration(t *testing.T) {
 		{"Create folder1 for user2", "create-folder user2 folder1", "Create folder1 successfully"},
 		{"Attempt to create existing folder", "create-folder user1 folder1", "Error: the folder1 has already existed"},
 		{"Create folder2 with description for user1", "create-folder user1 folder2 this-is-folder-2", "Create folder2 successfully"},
-		{"List folders for user1 sorted by name", "list-folders user1 --sort-name asc", "folder1 " + time.Now().Format("2006-01-02 15:04:05") + " user1\nfolder2 this-is-folder-2 " + time.Now().Format("2006-01-02 15:04:05") + " user1"},
-		{"List folders for user2", "list-folders user2", "folder1 " + time.Now().Format("2006-01-02 15:04:05") + " user2"},
+		{"List folders for user1 sorted by name", "list-folders user1 --sort-name asc", "folder1 2023-01-01 15:00:30 user1\nfolder2 this-is-folder-2 2023-01-01 15:01:00 user1\n"},
+		{"List folders for user2", "list-folders user2", "folder1 2023-01-01 15:00:40 user2\n"},
+		{"List folders for user1 sorted by created desc", "list-folders user1 --sort-created desc", "folder2 this-is-folder-2 2023-01-01 15:01:00 user1\nfolder1 2023-01-01 15:00:30 user1\n"},
 		{"Create file1 for user1 in folder1", "create-file user1 folder1 file1 this-is-file1", "Create file1 in user1/folder1 successfully"},
 		{"Create config file for user1 in folder1", "create-file user1 folder1 config a-config-file", "Create config in user1/folder1 successfully"},
 		{"Attempt to create existing file", "create-file user1 folder1 config a-config-file", "Error: the config has already existed"},
 		{"Attempt to create file for unregistered user", "create-file user-abc folder-abc config a-config-file", "Error: the user-abc doesn't exist"},
 		{"Attempt unsupported command", "list data", "Error: Unrecognized command"},
-		{"Attempt to list files with incorrect flags", "list-files user1 folder1 --sort a", "Usage: list-files [username] [foldername] [--sort-name|--sort-created] [asc|desc]"},
-		{"List files sorted by name desc", "list-files user1 folder1 --sort-name desc", "file1 this-is-file1 " + time.Now().Format("2006-01-02 15:04:05") + " user1\nconfig a-config-file " + time.Now().Format("2006-01-02 15:04:05") + " user1"},
+		{"Attempt to list files with incorrect flags", "list-files user1 folder1 --sort a", "Usage: list-files [username] [foldername] [--sort-name|--sort-created|--sort-modified] [asc|desc]"},
+		{"List files sorted by name desc", "list-files user1 folder1 --sort-name desc", "file1 this-is-file1 2023-01-01 15:01:40 user1\nconfig a-config-file 2023-01-01 15:01:50 user1\n"},
+		{"List files sorted by created desc", "list-files user1 folder1 --sort-created desc", "config a-config-file 2023-01-01 15:01:50 user1\nfile1 this-is-file1 2023-01-01 15:01:40 user1\n"},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(10 * time.Second)
+
 			var output string
 			var err error
 
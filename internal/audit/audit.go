@@ -0,0 +1,183 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is one command recorded in the audit log
+type Entry struct {
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Command string    `json:"command"`
+	Args    []string  `json:"args"`
+	// User is the user acted on when it isn't the first argument, NoUser
+	// when the command acts on none
+	User    string `json:"user,omitempty"`
+	Outcome string `json:"outcome"`
+	Code    string `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// NoUser marks entries of commands acting on no user, such as switching
+// the session actor
+const NoUser = "-"
+
+// username is the user the command acted on, audited commands name it
+// first unless recorded in User
+func (e Entry) username() string {
+	if e.User == NoUser {
+		return ""
+	}
+	if e.User != "" {
+		return e.User
+	}
+	if len(e.Args) > 0 {
+		return e.Args[0]
+	}
+	return ""
+}
+
+// Outcomes of a recorded command
+const (
+	Success = "success"
+	Failure = "failure"
+)
+
+// Log is an append-only JSON lines file, once it would grow past MaxSize
+// it is renamed to Path.1, older files shifting up to Path.Keep
+type Log struct {
+	Path    string
+	MaxSize int64
+	Keep    int
+
+	mu sync.Mutex
+}
+
+// Append writes the entry as one line, rotating the file first when needed
+func (l *Log) Append(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if l.MaxSize > 0 {
+		if info, err := os.Stat(l.Path); err == nil && info.Size() > 0 && info.Size()+int64(len(line)) > l.MaxSize {
+			if err := l.rotate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(l.Path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// rotate shifts Path.n to Path.n+1, dropping the oldest beyond Keep
+func (l *Log) rotate() error {
+	if l.Keep <= 0 {
+		return os.Remove(l.Path)
+	}
+
+	os.Remove(l.rotated(l.Keep))
+	for n := l.Keep - 1; n >= 1; n-- {
+		if err := os.Rename(l.rotated(n), l.rotated(n+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(l.Path, l.rotated(1))
+}
+
+func (l *Log) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", l.Path, n)
+}
+
+// Filter selects audit entries, zero values match everything
+type Filter struct {
+	Actor   string
+	User    string
+	Command string
+	Since   time.Time
+	Until   time.Time
+}
+
+func (f Filter) matches(entry Entry) bool {
+	if f.Actor != "" && !strings.EqualFold(f.Actor, entry.Actor) {
+		return false
+	}
+	if f.User != "" && !strings.EqualFold(f.User, entry.username()) {
+		return false
+	}
+	if f.Command != "" && f.Command != entry.Command {
+		return false
+	}
+	if !f.Since.IsZero() && entry.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !entry.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// Query returns the matching entries of the log and its rotated files, oldest first
+func (l *Log) Query(filter Filter) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var entries []Entry
+	paths := []string{l.Path}
+	for n := 1; n <= l.Keep; n++ {
+		paths = append(paths, l.rotated(n))
+	}
+
+	for i := len(paths) - 1; i >= 0; i-- {
+		file, err := os.Open(paths[i])
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			var entry Entry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				file.Close()
+				return nil, fmt.Errorf("the %s has an invalid entry: %v", paths[i], err)
+			}
+			if filter.matches(entry) {
+				entries = append(entries, entry)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
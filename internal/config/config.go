@@ -0,0 +1,196 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the environment variables overriding settings,
+// max_username_length is overridden by VFS_MAX_USERNAME_LENGTH
+const EnvPrefix = "VFS_"
+
+// Config holds the settings a deployment can change without rebuilding
+type Config struct {
+	MaxUsernameLength   int    `json:"max_username_length"`
+	MaxFolderNameLength int    `json:"max_folder_name_length"`
+	MaxFileNameLength   int    `json:"max_file_name_length"`
+	NamePolicy          string `json:"name_policy"`
+	// NameForbidden and NameReserved add characters and comma separated names
+	// to those the name policy rejects, NameLengthUnit measures name lengths
+	// in runes or bytes, empty keeping the unit of the policy
+	NameForbidden  string `json:"name_forbidden"`
+	NameReserved   string `json:"name_reserved"`
+	NameLengthUnit string `json:"name_length_unit"`
+	TimeFormat     string `json:"time_format"`
+	TimeZone       string `json:"timezone"`
+	DefaultSort    string `json:"default_sort"`
+	Output         string `json:"output"`
+	Prompt         string `json:"prompt"`
+	// HistoryFile, DataFile and AuditFile are disabled when empty
+	HistoryFile string `json:"history_file"`
+	DataFile    string `json:"data_file"`
+	AuditFile   string `json:"audit_file"`
+	// AuditMaxSize is the size in bytes the audit log is rotated at,
+	// AuditKeep how many rotated files are kept
+	AuditMaxSize int `json:"audit_max_size"`
+	AuditKeep    int `json:"audit_keep"`
+	// RevisionRetention is how many revisions a file keeps by default
+	RevisionRetention int `json:"revision_retention"`
+	// Admins lists the actors, comma separated, allowed to act across users
+	// such as with find --all-users
+	Admins string `json:"admins"`
+
+	// Path is the file the settings were read from, empty when none was found
+	Path string `json:"-"`
+	// sources records for each setting whether it came from the file or the environment
+	sources map[string]string
+}
+
+// Current is the configuration the session was started with
+var Current = Default()
+
+// Default returns the settings used when nothing overrides them
+func Default() Config {
+	return Config{
+		MaxUsernameLength:   25,
+		MaxFolderNameLength: 255,
+		MaxFileNameLength:   255,
+		NamePolicy:          "unicode",
+		TimeFormat:          "datetime",
+		TimeZone:            "Local",
+		DefaultSort:         "name",
+		Output:              "plain",
+		Prompt:              "> ",
+		AuditFile:           DefaultStatePath("audit.log"),
+		AuditMaxSize:        10 << 20,
+		AuditKeep:           5,
+		RevisionRetention:   20,
+	}
+}
+
+// DefaultPath is where the configuration is looked up when --config is not given,
+// $XDG_CONFIG_HOME/repl-cli-iscoollab/config.json or its ~/.config fallback
+func DefaultPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "repl-cli-iscoollab", "config.json")
+}
+
+// DefaultStatePath is where a state file is kept by default,
+// $XDG_STATE_HOME/repl-cli-iscoollab/name or its ~/.local/state fallback
+func DefaultStatePath(name string) string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "repl-cli-iscoollab", name)
+}
+
+// Load reads the defaults, then the JSON file at path, then the environment.
+// A missing file is only an error when required, i.e. passed with --config
+func Load(path string, required bool) (Config, error) {
+	cfg := Default()
+	cfg.sources = make(map[string]string)
+
+	if path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := cfg.decode(data); err != nil {
+				return cfg, fmt.Errorf("the %s is not a valid config file: %v", path, err)
+			}
+			cfg.Path = path
+		case errors.Is(err, fs.ErrNotExist) && !required:
+		default:
+			return cfg, fmt.Errorf("the %s can't be read: %v", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return cfg, err
+	}
+
+	return cfg, nil
+}
+
+// decode overlays the settings present in the file on the defaults
+func (c *Config) decode(data []byte) error {
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(data, &present); err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(c); err != nil {
+		return err
+	}
+
+	for key := range present {
+		c.sources[key] = "file"
+	}
+	return nil
+}
+
+// applyEnv overrides settings from VFS_* environment variables
+func (c *Config) applyEnv(lookup func(string) (string, bool)) error {
+	v := reflect.ValueOf(c).Elem()
+	for _, field := range reflect.VisibleFields(v.Type()) {
+		key := field.Tag.Get("json")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		name := EnvPrefix + strings.ToUpper(key)
+		value, found := lookup(name)
+		if !found {
+			continue
+		}
+
+		switch field.Type.Kind() {
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("the %s of %s is not a number", value, name)
+			}
+			v.FieldByIndex(field.Index).SetInt(int64(n))
+		case reflect.String:
+			v.FieldByIndex(field.Index).SetString(value)
+		}
+		c.sources[key] = "env"
+	}
+	return nil
+}
+
+// Settings lists every setting with its value and where the value came from
+func (c Config) Settings() [][3]string {
+	var settings [][3]string
+	v := reflect.ValueOf(c)
+	for _, field := range reflect.VisibleFields(v.Type()) {
+		key := field.Tag.Get("json")
+		if key == "" || key == "-" {
+			continue
+		}
+
+		source := c.sources[key]
+		if source == "" {
+			source = "default"
+		}
+		settings = append(settings, [3]string{key, fmt.Sprint(v.FieldByIndex(field.Index).Interface()), source})
+	}
+	return settings
+}
@@ -0,0 +1,153 @@
+package user
+
+import (
+	"sort"
+)
+
+const MaxAttributeKeyLength = 64
+
+// Attributes holds arbitrary key/value metadata attached to a folder or file
+type Attributes map[string]string
+
+// Entry is implemented by folders and files so their metadata can be edited alike
+type Entry interface {
+	SetDescription(description string)
+	SetAttr(key string, value string) error
+	GetAttr(key string) (string, error)
+	UnsetAttr(key string) error
+	ListAttrs() [][2]string
+}
+
+func (a Attributes) set(key string, value string) error {
+	key, err := validateName(key, "attribute key", MaxAttributeKeyLength)
+	if err != nil {
+		return err
+	}
+
+	a[key] = value
+	return nil
+}
+
+func (a Attributes) get(key string) (string, error) {
+	value, exists := a[lookupName(key)]
+	if !exists {
+		return "", Errorf(NotFound, "the %s attribute doesn't exist", key)
+	}
+	return value, nil
+}
+
+func (a Attributes) unset(key string) error {
+	if _, exists := a[lookupName(key)]; !exists {
+		return Errorf(NotFound, "the %s attribute doesn't exist", key)
+	}
+
+	delete(a, lookupName(key))
+	return nil
+}
+
+// list returns the key/value pairs sorted by key
+func (a Attributes) list() [][2]string {
+	pairs := make([][2]string, 0, len(a))
+	for key, value := range a {
+		pairs = append(pairs, [2]string{key, value})
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i][0] < pairs[j][0]
+	})
+	return pairs
+}
+
+func (f *Folder) SetDescription(description string) {
+	f.preserve()
+	before := folderState(f)
+	f.Description = description
+	f.ModifiedAt = clock.Now()
+	f.reindex()
+	publishFolder(FolderUpdated, f, before, folderState(f))
+}
+
+func (f *Folder) SetAttr(key string, value string) error {
+	if f.Attributes == nil {
+		f.Attributes = make(Attributes)
+	}
+
+	f.preserve()
+	before := folderState(f)
+	if err := f.Attributes.set(key, value); err != nil {
+		return err
+	}
+
+	f.ModifiedAt = clock.Now()
+	f.reindex()
+	publishFolder(FolderUpdated, f, before, folderState(f))
+	return nil
+}
+
+func (f *Folder) GetAttr(key string) (string, error) {
+	return f.Attributes.get(key)
+}
+
+func (f *Folder) UnsetAttr(key string) error {
+	f.preserve()
+	before := folderState(f)
+	if err := f.Attributes.unset(key); err != nil {
+		return err
+	}
+
+	f.ModifiedAt = clock.Now()
+	f.reindex()
+	publishFolder(FolderUpdated, f, before, folderState(f))
+	return nil
+}
+
+func (f *Folder) ListAttrs() [][2]string {
+	return f.Attributes.list()
+}
+
+func (f *File) SetDescription(description string) {
+	f.preserve()
+	before := fileState(f)
+	f.Description = description
+	f.ModifiedAt = clock.Now()
+	f.reindex()
+	publishFile(FileUpdated, f, before, fileState(f))
+}
+
+func (f *File) SetAttr(key string, value string) error {
+	if f.Attributes == nil {
+		f.Attributes = make(Attributes)
+	}
+
+	f.preserve()
+	before := fileState(f)
+	if err := f.Attributes.set(key, value); err != nil {
+		return err
+	}
+
+	f.ModifiedAt = clock.Now()
+	f.reindex()
+	publishFile(FileUpdated, f, before, fileState(f))
+	return nil
+}
+
+func (f *File) GetAttr(key string) (string, error) {
+	return f.Attributes.get(key)
+}
+
+func (f *File) UnsetAttr(key string) error {
+	f.preserve()
+	before := fileState(f)
+	if err := f.Attributes.unset(key); err != nil {
+		return err
+	}
+
+	f.ModifiedAt = clock.Now()
+	f.reindex()
+	publishFile(FileUpdated, f, before, fileState(f))
+	return nil
+}
+
+func (f *File) ListAttrs() [][2]string {
+	return f.Attributes.list()
+}
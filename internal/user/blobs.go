@@ -0,0 +1,196 @@
+package user
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// BlobStore keeps every distinct content once under its SHA-256, counting
+// the revisions referring to it. A blob nothing refers to any more stays
+// until GC reclaims it
+type BlobStore struct {
+	blobs map[string]*blob
+}
+
+type blob struct {
+	content string
+	refs    int
+}
+
+// Blobs holds the contents of every file of every user
+var Blobs = NewBlobStore()
+
+func NewBlobStore() *BlobStore {
+	return &BlobStore{blobs: make(map[string]*blob)}
+}
+
+// HashContent is the address content is stored under
+func HashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// EmptyHash is the hash of the content of a file never written to
+var EmptyHash = HashContent("")
+
+// put stores content, or finds the copy already stored, and takes a
+// reference to it. The stored copy is returned so equal contents share memory
+func (s *BlobStore) put(content string) (string, string) {
+	hash := HashContent(content)
+	b, exists := s.blobs[hash]
+	if !exists {
+		b = &blob{content: content}
+		s.blobs[hash] = b
+	}
+	b.refs++
+	return hash, b.content
+}
+
+// retain takes another reference to a stored blob
+func (s *BlobStore) retain(hash string) {
+	if b, exists := s.blobs[hash]; exists {
+		b.refs++
+	}
+}
+
+// release drops a reference, the blob itself waits for GC
+func (s *BlobStore) release(hash string) {
+	if b, exists := s.blobs[hash]; exists && b.refs > 0 {
+		b.refs--
+	}
+}
+
+// Get returns the content stored under hash
+func (s *BlobStore) Get(hash string) (string, bool) {
+	b, exists := s.blobs[hash]
+	if !exists {
+		return "", false
+	}
+	return b.content, true
+}
+
+// Refs is the number of revisions referring to the blob
+func (s *BlobStore) Refs(hash string) int {
+	if b, exists := s.blobs[hash]; exists {
+		return b.refs
+	}
+	return 0
+}
+
+// BlobStats sums up the blobs of a store
+type BlobStats struct {
+	Blobs int
+	Bytes int
+	// Unreferenced counts the blobs GC would reclaim
+	Unreferenced      int
+	UnreferencedBytes int
+}
+
+func (s *BlobStore) Stats() BlobStats {
+	var stats BlobStats
+	for _, b := range s.blobs {
+		stats.Blobs++
+		stats.Bytes += len(b.content)
+		if b.refs == 0 {
+			stats.Unreferenced++
+			stats.UnreferencedBytes += len(b.content)
+		}
+	}
+	return stats
+}
+
+// GC recounts the references of every blob from the revisions of all users
+// and their snapshots, and drops the blobs left without any. It returns how
+// many blobs and bytes were reclaimed
+func GC() (int, int) {
+	for _, b := range Blobs.blobs {
+		b.refs = 0
+	}
+	eachFile(func(file *File) {
+		for _, revision := range file.Revisions {
+			Blobs.retain(revision.Hash)
+		}
+	})
+
+	count, bytes := 0, 0
+	for hash, b := range Blobs.blobs {
+		if b.refs == 0 {
+			count++
+			bytes += len(b.content)
+			delete(Blobs.blobs, hash)
+		}
+	}
+	return count, bytes
+}
+
+// eachFile calls fn once for every file of every user, live or held by
+// a snapshot only
+func eachFile(fn func(*File)) {
+	seen := make(map[*File]bool)
+	visit := func(folders map[string]*Folder) {
+		for _, folder := range folders {
+			for _, file := range folder.Files {
+				if !seen[file] {
+					seen[file] = true
+					fn(file)
+				}
+			}
+		}
+	}
+	for _, u := range ListUser {
+		visit(u.Folders)
+		for _, snapshot := range u.Snapshots {
+			visit(snapshot.Folders)
+		}
+	}
+}
+
+// ContentHash is the address of the current content of the file
+func (f *File) ContentHash() string {
+	if len(f.Revisions) == 0 {
+		return EmptyHash
+	}
+	return f.Revisions[len(f.Revisions)-1].Hash
+}
+
+// retainBlobs takes a reference for every revision of a new copy of a file
+func (f *File) retainBlobs() {
+	for _, revision := range f.Revisions {
+		Blobs.retain(revision.Hash)
+	}
+}
+
+// releaseBlobs drops the references of a file once nothing holds it, a file
+// still in the live folders or a snapshot keeps them
+func (f *File) releaseBlobs() {
+	if f.folder != nil && f.folder.owner != nil && f.folder.owner.holds(f) {
+		return
+	}
+	for _, revision := range f.Revisions {
+		Blobs.release(revision.Hash)
+	}
+}
+
+// holds reports whether the live folders or a snapshot of the user still
+// contain the file
+func (u *User) holds(f *File) bool {
+	key := nameKey(f.Name)
+	contains := func(folders map[string]*Folder) bool {
+		for _, folder := range folders {
+			if folder.Files[key] == f {
+				return true
+			}
+		}
+		return false
+	}
+
+	if contains(u.Folders) {
+		return true
+	}
+	for _, snapshot := range u.Snapshots {
+		if contains(snapshot.Folders) {
+			return true
+		}
+	}
+	return false
+}
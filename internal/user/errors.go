@@ -0,0 +1,108 @@
+package user
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Kind classifies an error, errors.Is(err, NotFound) reports whether err is
+// of that kind and errors.As extracts the *Error carrying it
+type Kind int
+
+const (
+	NotFound Kind = iota + 1
+	AlreadyExists
+	InvalidName
+	TooLong
+	UsageError
+	PermissionDenied
+	Conflict
+)
+
+// kinds holds the code and exit status of each kind, both are part of the
+// interface scripts rely on so they must never change
+var kinds = map[Kind]struct {
+	code   string
+	status int
+}{
+	NotFound:         {"not_found", 3},
+	AlreadyExists:    {"already_exists", 4},
+	InvalidName:      {"invalid_name", 5},
+	TooLong:          {"too_long", 6},
+	UsageError:       {"usage", 2},
+	PermissionDenied: {"permission_denied", 7},
+	Conflict:         {"conflict", 8},
+}
+
+func (k Kind) Error() string {
+	return k.Code()
+}
+
+// Code is the stable name of the kind shown in structured output
+func (k Kind) Code() string {
+	return kinds[k].code
+}
+
+// ExitStatus is the process exit status for an error of this kind
+func (k Kind) ExitStatus() int {
+	return kinds[k].status
+}
+
+// Error is an error of a known kind with its message
+type Error struct {
+	Kind    Kind
+	Message string
+
+	// usage marks a message that is the usage of a command
+	usage bool
+}
+
+// Errorf builds an error of the given kind
+func Errorf(kind Kind, format string, args ...any) error {
+	return &Error{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// Usage is the usage error of a command, its message is the command usage
+func Usage(command string) error {
+	return &Error{Kind: UsageError, Message: CommandsUsage[command], usage: true}
+}
+
+// IsUsage reports whether err is the usage of a command, which is shown as
+// is rather than as an error message
+func IsUsage(err error) bool {
+	e, ok := asError(err)
+	return ok && e.usage
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// ErrorCode is the stable code of err, "error" when it has no kind
+func ErrorCode(err error) string {
+	if e, ok := asError(err); ok {
+		return e.Kind.Code()
+	}
+	return "error"
+}
+
+// ExitStatus is the process exit status for err, 0 for nil and 1 when it has no kind
+func ExitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	if e, ok := asError(err); ok {
+		return e.Kind.ExitStatus()
+	}
+	return 1
+}
+
+func asError(err error) (*Error, bool) {
+	var e *Error
+	ok := errors.As(err, &e)
+	return e, ok
+}
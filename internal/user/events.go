@@ -0,0 +1,267 @@
+package user
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// EventType names the change an event reports
+type EventType string
+
+const (
+	UserRegistered EventType = "user_registered"
+	FolderCreated  EventType = "folder_created"
+	FolderRenamed  EventType = "folder_renamed"
+	FolderDeleted  EventType = "folder_deleted"
+	FolderUpdated  EventType = "folder_updated"
+	FileCreated    EventType = "file_created"
+	FileDeleted    EventType = "file_deleted"
+	FileWritten    EventType = "file_written"
+	FileUpdated    EventType = "file_updated"
+)
+
+// State is a copy of a user, folder or file taken when an event is published,
+// later changes to the entry don't affect it
+type State struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ModifiedAt  time.Time         `json:"modified_at"`
+	Attributes  map[string]string `json:"attributes,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Size        int               `json:"size"`
+	// Revision is the latest revision of a file, 0 before its first write
+	Revision int `json:"revision,omitempty"`
+}
+
+// Event reports one change, Before is nil for created entries and After is
+// nil for deleted ones. Folder and File name the entry after the change,
+// or before it when it was deleted
+type Event struct {
+	Type     EventType `json:"type"`
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	Folder   string    `json:"folder,omitempty"`
+	File     string    `json:"file,omitempty"`
+	Before   *State    `json:"before,omitempty"`
+	After    *State    `json:"after,omitempty"`
+}
+
+// Path is the username, folder and file of the event joined by slashes
+func (e Event) Path() string {
+	path := e.Username
+	if e.Folder != "" {
+		path += "/" + e.Folder
+	}
+	if e.File != "" {
+		path += "/" + e.File
+	}
+	return path
+}
+
+// Handler receives published events
+type Handler func(Event)
+
+// Bus delivers events to its subscribers. Synchronous subscribers run before
+// the change that published the event returns, asynchronous ones receive
+// events in order on their own goroutine without ever stalling a change
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[int]*subscriber
+	next        int
+}
+
+type subscriber struct {
+	handler Handler
+	// queue is only used by asynchronous subscribers
+	queue *eventQueue
+}
+
+// Events is the bus every change of the user package is published on
+var Events = &Bus{}
+
+// Subscribe calls handler synchronously for every event until unsubscribed
+func (b *Bus) Subscribe(handler Handler) (unsubscribe func()) {
+	return b.add(&subscriber{handler: handler})
+}
+
+// SubscribeAsync calls handler for every event on a goroutine of its own,
+// unsubscribing waits for the events already queued to be handled
+func (b *Bus) SubscribeAsync(handler Handler) (unsubscribe func()) {
+	queue := newEventQueue()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			event, ok := queue.pop()
+			if !ok {
+				return
+			}
+			handler(event)
+		}
+	}()
+
+	remove := b.add(&subscriber{handler: handler, queue: queue})
+	return func() {
+		remove()
+		queue.close()
+		<-done
+	}
+}
+
+func (b *Bus) add(s *subscriber) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]*subscriber)
+	}
+	id := b.next
+	b.next++
+	b.subscribers[id] = s
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, id)
+		})
+	}
+}
+
+// Publish delivers the event to every subscriber, in subscription order
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	ids := make([]int, 0, len(b.subscribers))
+	for id := range b.subscribers {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	subscribers := make([]*subscriber, 0, len(ids))
+	for _, id := range ids {
+		subscribers = append(subscribers, b.subscribers[id])
+	}
+	b.mu.RUnlock()
+
+	for _, s := range subscribers {
+		if s.queue != nil {
+			s.queue.push(event)
+		} else {
+			s.handler(event)
+		}
+	}
+}
+
+// eventQueue is an unbounded FIFO so publishing never blocks on a slow subscriber
+type eventQueue struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	events []Event
+	closed bool
+}
+
+func newEventQueue() *eventQueue {
+	q := &eventQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+func (q *eventQueue) push(event Event) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.closed {
+		q.events = append(q.events, event)
+		q.cond.Signal()
+	}
+}
+
+// pop waits for the next event, reporting false once closed and drained
+func (q *eventQueue) pop() (Event, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for len(q.events) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if len(q.events) == 0 {
+		return Event{}, false
+	}
+	event := q.events[0]
+	q.events = q.events[1:]
+	return event, true
+}
+
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+func userState(u *User) *State {
+	return &State{Name: u.Username, CreatedAt: u.CreatedAt, ModifiedAt: u.ModifiedAt}
+}
+
+func folderState(f *Folder) *State {
+	return &State{
+		Name:        f.Name,
+		Description: f.Description,
+		CreatedAt:   f.CreatedAt,
+		ModifiedAt:  f.ModifiedAt,
+		Attributes:  cloneAttributes(f.Attributes),
+		Tags:        slices.Clone(f.Tags),
+		Size:        f.Size(),
+	}
+}
+
+func fileState(f *File) *State {
+	return &State{
+		Name:        f.Name,
+		Description: f.Description,
+		CreatedAt:   f.CreatedAt,
+		ModifiedAt:  f.ModifiedAt,
+		Attributes:  cloneAttributes(f.Attributes),
+		Tags:        slices.Clone(f.Tags),
+		Size:        f.Size(),
+		Revision:    f.LastRevision(),
+	}
+}
+
+func cloneAttributes(a Attributes) map[string]string {
+	if len(a) == 0 {
+		return nil
+	}
+	clone := make(map[string]string, len(a))
+	for key, value := range a {
+		clone[key] = value
+	}
+	return clone
+}
+
+// publishUser reports the registration of the user
+func publishUser(eventType EventType, u *User) {
+	Events.Publish(Event{Type: eventType, Time: clock.Now(), Username: u.Username, After: userState(u)})
+}
+
+// publishFolder reports a change of the folder, before is nil when it was
+// created and after is nil when it was deleted
+func publishFolder(eventType EventType, folder *Folder, before *State, after *State) {
+	event := Event{Type: eventType, Time: clock.Now(), Folder: folder.Name, Before: before, After: after}
+	if folder.owner != nil {
+		event.Username = folder.owner.Username
+	}
+	Events.Publish(event)
+}
+
+// publishFile reports a change of the file like publishFolder
+func publishFile(eventType EventType, file *File, before *State, after *State) {
+	event := Event{Type: eventType, Time: clock.Now(), File: file.Name, Before: before, After: after}
+	if file.folder != nil {
+		event.Folder = file.folder.Name
+		if file.folder.owner != nil {
+			event.Username = file.folder.owner.Username
+		}
+	}
+	Events.Publish(event)
+}
@@ -0,0 +1,127 @@
+package user
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// FindOptions describes which files a search matches, zero values match everything
+type FindOptions struct {
+	Name          string
+	Regex         *regexp.Regexp
+	Description   string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	MinSize       int
+	MaxSize       int
+	HasMaxSize    bool
+	Tags          TagQuery
+	Attrs         map[string]string
+}
+
+// Match is a file found by a search together with where it lives
+type Match struct {
+	User   *User
+	Folder *Folder
+	File   *File
+}
+
+func (m Match) Path() string {
+	return m.User.Username + "/" + m.Folder.Name + "/" + m.File.Name
+}
+
+// Find walks every folder of the user and returns the files matching opts
+func (u *User) Find(opts FindOptions) ([]Match, error) {
+	if opts.Name != "" {
+		if _, err := path.Match(opts.Name, ""); err != nil {
+			return nil, Errorf(UsageError, "the %s is not a valid name pattern", opts.Name)
+		}
+	}
+
+	// Narrow the walk with the tag index instead of checking every file
+	var tagged map[tagRef]bool
+	if opts.Tags != nil {
+		tagged = u.match(opts.Tags, func(ref tagRef) bool { return ref.file != "" })
+	}
+
+	var matches []Match
+	for folderName, folder := range u.Folders {
+		for fileName, file := range folder.Files {
+			if tagged != nil && !tagged[tagRef{folder: folderName, file: fileName}] {
+				continue
+			}
+			if opts.matches(file) {
+				matches = append(matches, Match{User: u, Folder: folder, File: file})
+			}
+		}
+	}
+
+	return matches, nil
+}
+
+// FindAll searches the files of every registered user
+func FindAll(opts FindOptions) ([]Match, error) {
+	var matches []Match
+	for _, u := range ListUser {
+		found, err := u.Find(opts)
+		if err != nil {
+			return nil, err
+		}
+		matches = append(matches, found...)
+	}
+	return matches, nil
+}
+
+func (opts FindOptions) matches(file *File) bool {
+	if opts.Name != "" {
+		if matched, _ := path.Match(opts.Name, nameKey(file.Name)); !matched {
+			return false
+		}
+	}
+
+	if opts.Regex != nil && !opts.Regex.MatchString(file.Name) {
+		return false
+	}
+
+	if opts.Description != "" && !strings.Contains(strings.ToLower(file.Description), strings.ToLower(opts.Description)) {
+		return false
+	}
+
+	if !opts.CreatedAfter.IsZero() && !file.CreatedAt.After(opts.CreatedAfter) {
+		return false
+	}
+
+	if !opts.CreatedBefore.IsZero() && !file.CreatedAt.Before(opts.CreatedBefore) {
+		return false
+	}
+
+	if file.Size() < opts.MinSize || (opts.HasMaxSize && file.Size() > opts.MaxSize) {
+		return false
+	}
+
+	for key, value := range opts.Attrs {
+		actual, exists := file.Attributes[key]
+		if !exists || (value != "" && actual != value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// SortMatches orders matches on the given keys like ListFilesPage, the
+// full path stands in for the name
+func SortMatches(matches []Match, keys []SortKey) {
+	sort.SliceStable(matches, func(i, j int) bool {
+		return compareEntries(matchKey(matches[i]), matchKey(matches[j]), keys) < 0
+	})
+}
+
+func matchKey(match Match) entryKey {
+	key := fileKey(match.File)
+	key.Name = match.Path()
+	return key
+}
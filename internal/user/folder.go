@@ -1,92 +1,184 @@
 package user
 
 import (
-	"fmt"
-	"repl-cli-iscoollab/internal/utils"
-	"sort"
 	"time"
 )
 
 type Folder struct {
 	Name        string
 	Description string
-	CreatedAt   string
-	Files       map[string]*File
+	CreatedAt   time.Time
+	ModifiedAt  time.Time
+	AccessedAt  time.Time
+	Attributes  Attributes
+	Tags        []string
+	// Files is keyed by the lowercased file name, see nameKey
+	Files map[string]*File
+	// Seq records creation order, breaking ties between otherwise equal entries
+	Seq uint64
+
+	owner *User
 }
 
 type File struct {
 	Name        string
-	CreatedAt   string
+	CreatedAt   time.Time
+	ModifiedAt  time.Time
+	AccessedAt  time.Time
 	Description string
+	// Content is the stored copy of the latest revision, see Blobs
+	Content    string `json:"-"`
+	Attributes Attributes
+	Tags       []string
+	// Seq records creation order, breaking ties between otherwise equal entries
+	Seq uint64
+	// Revisions holds the retained versions of Content, oldest first
+	Revisions []Revision
+	// Retention overrides RevisionRetention for this file when positive
+	Retention int
+
+	folder *Folder
 }
 
 func (f *Folder) CreateFile(fileName string, description string) error {
-	if !utils.ValidateString(fileName) {
-		return fmt.Errorf("the %s contain invalid chars", fileName)
+	fileName, err := validateName(fileName, "filename", MaxFileNameLength)
+	if err != nil {
+		return err
 	}
 
-	if _, exists := f.Files[fileName]; exists {
-		return fmt.Errorf("the %s has already existed", fileName)
-	}
-
-	if len(fileName) > MaxFileNameLength {
-		return fmt.Errorf("filename is too long, max length allowed is %d", MaxFileNameLength)
+	if _, exists := f.Files[nameKey(fileName)]; exists {
+		return Errorf(AlreadyExists, "the %s has already existed", fileName)
 	}
 
+	now := clock.Now()
 	file := &File{
 		Name:        fileName,
 		Description: description,
-		CreatedAt:   time.Now().Format("2006-01-02 15:04:05"),
+		CreatedAt:   now,
+		ModifiedAt:  now,
+		AccessedAt:  now,
+		Attributes:  make(Attributes),
+		Seq:         nextSeq(),
+		folder:      f,
 	}
 
-	f.Files[fileName] = file
+	f.preserve()
+	f.Files[nameKey(fileName)] = file
+	f.ModifiedAt = now
+	fullText.add(document{folder: f, file: file})
+	publishFile(FileCreated, file, nil, fileState(file))
 
 	return nil
 }
 
+// CopyFile adds a copy of src, which may belong to any folder of any user,
+// named fileName. The copy shares the stored content of src and starts its
+// own history with it as revision 1 by author, so no content is copied
+func (f *Folder) CopyFile(src *File, fileName string, author string) error {
+	if err := f.CreateFile(fileName, src.Description); err != nil {
+		return err
+	}
+	file := f.Files[nameKey(fileName)]
+
+	for key, value := range src.Attributes {
+		file.Attributes[key] = value
+	}
+	file.reindex()
+	for _, tag := range src.Tags {
+		file.Tags, _ = addTag(file.Tags, tag)
+		if f.owner != nil {
+			f.owner.index(tag, tagRef{folder: nameKey(f.Name), file: nameKey(file.Name)})
+		}
+	}
+	if len(src.Revisions) > 0 {
+		Blobs.retain(src.ContentHash())
+		file.writeBlob(src.ContentHash(), src.Content, author)
+	}
+	return nil
+}
+
 func (f *Folder) DeleteFile(fileName string) error {
-	if _, exists := f.Files[fileName]; exists {
-		delete(f.Files, fileName)
+	if file, exists := f.Files[nameKey(fileName)]; exists {
+		if f.owner != nil {
+			for _, tag := range file.Tags {
+				f.owner.unindex(tag, tagRef{folder: nameKey(f.Name), file: nameKey(fileName)})
+			}
+		}
+		fullText.remove(document{folder: f, file: file})
+		f.preserve()
+		delete(f.Files, nameKey(fileName))
+		file.releaseBlobs()
+		f.ModifiedAt = clock.Now()
+		publishFile(FileDeleted, file, fileState(file), nil)
 		return nil
 	}
 
-	return fmt.Errorf("the %s doesn't exist", fileName)
+	return Errorf(NotFound, "the %s doesn't exist", fileName)
+}
+
+func (f *Folder) GetFile(fileName string) (*File, error) {
+	file, exists := f.Files[nameKey(fileName)]
+	if !exists {
+		return nil, Errorf(NotFound, "the %s doesn't exist", fileName)
+	}
+	return file, nil
 }
 
 func (f *Folder) ListFiles(sortBy string, sortOrder string) ([]*File, error) {
-	var isAsc bool
-	switch sortOrder {
-	case "asc":
-		isAsc = true
-	case "desc":
-		isAsc = false
-	default:
-		return nil, fmt.Errorf(CommandsUsage["list-files"])
+	page, err := f.ListFilesPage(ListOptions{SortBy: sortBy, SortOrder: sortOrder})
+	if err != nil {
+		return nil, err
 	}
 
-	files := make([]*File, 0, len(f.Files))
-	for _, file := range f.Files {
-		files = append(files, file)
+	return page.Items, nil
+}
+
+// reindex refreshes the folder in the search index after its text changed
+func (f *Folder) reindex() {
+	if f.owner != nil {
+		fullText.add(document{folder: f})
 	}
+}
 
-	switch sortBy {
-	case "--sort-name":
-		sort.Slice(files, func(i, j int) bool {
-			if isAsc {
-				return files[i].Name < files[j].Name
-			}
-			return files[i].Name > files[j].Name
-		})
-	case "--sort-created":
-		sort.Slice(files, func(i, j int) bool {
-			if isAsc {
-				return files[i].CreatedAt < files[j].CreatedAt
-			}
-			return files[i].CreatedAt > files[j].CreatedAt
-		})
-	default:
-		return nil, fmt.Errorf(CommandsUsage["list-files"])
+// reindex refreshes the file in the search index after its text changed
+func (f *File) reindex() {
+	if f.folder != nil {
+		fullText.add(document{folder: f.folder, file: f})
 	}
+}
+
+// Read returns the content of the file and records the access
+func (f *File) Read() string {
+	f.preserve()
+	f.AccessedAt = clock.Now()
+	return f.Content
+}
 
-	return files, nil
+// Size is the length of the content in bytes
+func (f *File) Size() int {
+	return len(f.Content)
+}
+
+// SetTimes overrides when the folder was created and modified, for folders
+// brought in from outside the VFS that keep their original times
+func (f *Folder) SetTimes(createdAt time.Time, modifiedAt time.Time) {
+	f.preserve()
+	f.CreatedAt = createdAt
+	f.ModifiedAt = modifiedAt
+}
+
+// SetTimes overrides when the file was created and modified like Folder.SetTimes
+func (f *File) SetTimes(createdAt time.Time, modifiedAt time.Time) {
+	f.preserve()
+	f.CreatedAt = createdAt
+	f.ModifiedAt = modifiedAt
+}
+
+// Size is the total size of the files in the folder
+func (f *Folder) Size() int {
+	size := 0
+	for _, file := range f.Files {
+		size += file.Size()
+	}
+	return size
 }
@@ -0,0 +1,179 @@
+package user
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ListOptions filters, sorts and pages the entries returned by ListFoldersPage
+// and ListFilesPage, zero values keep every entry
+type ListOptions struct {
+	SortBy    string
+	SortOrder string
+	// Sort takes precedence over SortBy and SortOrder when set
+	Sort          []SortKey
+	NamePrefix    string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	Tags          TagQuery
+	Offset        int
+	Limit         int
+	// Cursor continues after the last entry of a previous page, it stays
+	// valid when entries are added or removed in between
+	Cursor string
+}
+
+// Page is one page of a listing together with the number of matching entries
+type Page[T any] struct {
+	Items      []T
+	Total      int
+	Start      int
+	NextCursor string
+}
+
+// entryKey holds the fields entries are sorted on and is what cursors encode
+type entryKey struct {
+	Name        string    `json:"n"`
+	CreatedAt   time.Time `json:"c"`
+	ModifiedAt  time.Time `json:"m"`
+	Size        int       `json:"s,omitempty"`
+	Description string    `json:"d,omitempty"`
+	Seq         uint64    `json:"q,omitempty"`
+}
+
+func encodeCursor(key entryKey) string {
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(cursor string) (entryKey, error) {
+	var key entryKey
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, &key)
+	}
+	if err != nil {
+		return key, Errorf(UsageError, "the %s is not a valid cursor", cursor)
+	}
+	return key, nil
+}
+
+// paginate filters, sorts and slices entries according to opts
+func paginate[T any](entries []T, key func(T) entryKey, keep func(T) bool, opts ListOptions, usage string) (Page[T], error) {
+	var page Page[T]
+	keys := opts.Sort
+	if keys == nil {
+		var err error
+		keys, err = LegacySortKeys(opts.SortBy, opts.SortOrder, usage)
+		if err != nil {
+			return page, err
+		}
+	}
+
+	prefix := strings.ToLower(opts.NamePrefix)
+	filtered := make([]T, 0, len(entries))
+	for _, entry := range entries {
+		k := key(entry)
+		if prefix != "" && !strings.HasPrefix(strings.ToLower(k.Name), prefix) {
+			continue
+		}
+		if !opts.CreatedAfter.IsZero() && !k.CreatedAt.After(opts.CreatedAfter) {
+			continue
+		}
+		if !opts.CreatedBefore.IsZero() && !k.CreatedAt.Before(opts.CreatedBefore) {
+			continue
+		}
+		if keep != nil && !keep(entry) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+
+	sort.SliceStable(filtered, func(i, j int) bool {
+		return compareEntries(key(filtered[i]), key(filtered[j]), keys) < 0
+	})
+
+	start := 0
+	if opts.Cursor != "" {
+		after, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return page, err
+		}
+		start = sort.Search(len(filtered), func(i int) bool {
+			return compareEntries(after, key(filtered[i]), keys) < 0
+		})
+	}
+	start = min(start+opts.Offset, len(filtered))
+
+	end := len(filtered)
+	if opts.Limit > 0 {
+		end = min(start+opts.Limit, len(filtered))
+	}
+
+	page.Items = filtered[start:end]
+	page.Total = len(filtered)
+	page.Start = start
+	if end < len(filtered) && end > start {
+		page.NextCursor = encodeCursor(key(filtered[end-1]))
+	}
+	return page, nil
+}
+
+func folderKey(folder *Folder) entryKey {
+	return entryKey{Name: folder.Name, CreatedAt: folder.CreatedAt, ModifiedAt: folder.ModifiedAt, Size: folder.Size(), Description: folder.Description, Seq: folder.Seq}
+}
+
+func fileKey(file *File) entryKey {
+	return entryKey{Name: file.Name, CreatedAt: file.CreatedAt, ModifiedAt: file.ModifiedAt, Size: file.Size(), Description: file.Description, Seq: file.Seq}
+}
+
+// ListFoldersPage returns the folders of the user matching opts
+func (u *User) ListFoldersPage(opts ListOptions) (Page[*Folder], error) {
+	folders := make([]*Folder, 0, len(u.Folders))
+	for _, folder := range u.Folders {
+		folders = append(folders, folder)
+	}
+
+	var keep func(*Folder) bool
+	if opts.Tags != nil {
+		tagged := u.TaggedFolders(opts.Tags)
+		keep = func(folder *Folder) bool { return tagged[nameKey(folder.Name)] }
+	}
+
+	page, err := paginate(folders, folderKey, keep, opts, "list-folders")
+	if err != nil {
+		return page, err
+	}
+
+	u.AccessedAt = clock.Now()
+	return page, nil
+}
+
+// ListFilesPage returns the files of the folder matching opts
+func (f *Folder) ListFilesPage(opts ListOptions) (Page[*File], error) {
+	files := make([]*File, 0, len(f.Files))
+	for _, file := range f.Files {
+		files = append(files, file)
+	}
+
+	var keep func(*File) bool
+	if opts.Tags != nil {
+		tagged := make(map[string]bool)
+		if f.owner != nil {
+			tagged = f.owner.TaggedFiles(f.Name, opts.Tags)
+		}
+		keep = func(file *File) bool { return tagged[nameKey(file.Name)] }
+	}
+
+	page, err := paginate(files, fileKey, keep, opts, "list-files")
+	if err != nil {
+		return page, err
+	}
+
+	f.preserve()
+	f.AccessedAt = clock.Now()
+	return page, nil
+}
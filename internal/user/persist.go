@@ -0,0 +1,209 @@
+package user
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// dataVersion is the layout Save writes, files written before it are a
+// plain map of users holding their contents inline
+const dataVersion = 2
+
+type dataFile struct {
+	Version int              `json:"version"`
+	Users   map[string]*User `json:"users"`
+	// Blobs holds each content once by its hash
+	Blobs map[string]string `json:"blobs"`
+	// Sections holds the state of the registered Sections by name
+	Sections map[string]json.RawMessage `json:"sections,omitempty"`
+}
+
+// Section is state another package keeps in the data file, saved and
+// loaded along with the users
+type Section struct {
+	Save func() (json.RawMessage, error)
+	Load func(json.RawMessage) error
+}
+
+// Sections are saved under their names, a section missing from the data
+// file is loaded from null
+var Sections = make(map[string]Section)
+
+// Save writes every user with their folders, files, attributes and tags,
+// every stored content once and the Sections to path as JSON, replacing the
+// file atomically
+func Save(path string) error {
+	blobs := make(map[string]string, len(Blobs.blobs))
+	for hash, b := range Blobs.blobs {
+		blobs[hash] = b.content
+	}
+
+	sections := make(map[string]json.RawMessage, len(Sections))
+	for name, section := range Sections {
+		state, err := section.Save()
+		if err != nil {
+			return err
+		}
+		sections[name] = state
+	}
+
+	data, err := json.MarshalIndent(dataFile{Version: dataVersion, Users: ListUser, Blobs: blobs, Sections: sections}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load replaces the users and contents with the ones saved at path and
+// rebuilds the tag and search indexes, a missing file leaves no users
+func Load(path string) error {
+	file := dataFile{Users: make(map[string]*User)}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err == nil {
+		if err := decodeDataFile(data, &file); err != nil {
+			return fmt.Errorf("the %s is not a valid data file: %v", path, err)
+		}
+	}
+	users := file.Users
+
+	blobs := NewBlobStore()
+	for hash, content := range file.Blobs {
+		blobs.blobs[hash] = &blob{content: content}
+	}
+
+	var lastSeq uint64
+	for _, u := range users {
+		if u.Folders == nil {
+			u.Folders = make(map[string]*Folder)
+		}
+		for key, folder := range u.Folders {
+			if folder.Files == nil {
+				folder.Files = make(map[string]*File)
+			}
+			u.indexFolder(folder, key)
+			lastSeq = max(lastSeq, folder.Seq)
+			for _, file := range folder.Files {
+				lastSeq = max(lastSeq, file.Seq)
+			}
+		}
+	}
+
+	ListUser = users
+	Blobs = blobs
+	if err := loadContents(); err != nil {
+		return fmt.Errorf("the %s is not a valid data file: %v", path, err)
+	}
+	sequence.Store(max(sequence.Load(), lastSeq))
+	Reindex()
+
+	for name, section := range Sections {
+		state := file.Sections[name]
+		if state == nil {
+			state = json.RawMessage("null")
+		}
+		if err := section.Load(state); err != nil {
+			return fmt.Errorf("the %s is not a valid data file: the %s can't be loaded: %v", path, name, err)
+		}
+	}
+	return nil
+}
+
+// decodeDataFile reads the current layout, or the earlier plain map of
+// users whose contents are picked up by loadContents
+func decodeDataFile(data []byte, file *dataFile) error {
+	var probe map[string]json.RawMessage
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return err
+	}
+	if version, exists := probe["version"]; exists && len(version) > 0 && version[0] != '{' {
+		return json.Unmarshal(data, file)
+	}
+	return json.Unmarshal(data, &file.Users)
+}
+
+// loadContents points the revisions of every file at their stored blobs and
+// counts the references. Contents saved inline before the blob store are
+// stored, and a file written before revisions gets its content as revision 1
+func loadContents() error {
+	var missing error
+	eachFile(func(file *File) {
+		if len(file.Revisions) == 0 && file.Content != "" {
+			file.Revisions = []Revision{{Number: 1, Content: file.Content, CreatedAt: file.ModifiedAt}}
+		}
+		for i := range file.Revisions {
+			revision := &file.Revisions[i]
+			if revision.Hash == "" {
+				revision.Hash, revision.Content = Blobs.put(revision.Content)
+				continue
+			}
+			content, exists := Blobs.Get(revision.Hash)
+			if !exists && missing == nil {
+				missing = fmt.Errorf("the content %s of %s is missing", revision.Hash, file.Name)
+			}
+			revision.Content = content
+			Blobs.retain(revision.Hash)
+		}
+
+		file.Content = ""
+		if len(file.Revisions) > 0 {
+			file.Content = file.Revisions[len(file.Revisions)-1].Content
+		}
+	})
+	return missing
+}
+
+// UnmarshalJSON also reads the inline content of files saved before the blob
+// store, loadContents stores it
+func (f *File) UnmarshalJSON(data []byte) error {
+	type plain File
+	saved := struct {
+		*plain
+		Content string
+	}{plain: (*plain)(f)}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	f.Content = saved.Content
+	return nil
+}
+
+// UnmarshalJSON also reads the inline content of revisions saved before the
+// blob store like File.UnmarshalJSON
+func (r *Revision) UnmarshalJSON(data []byte) error {
+	type plain Revision
+	saved := struct {
+		*plain
+		Content string
+	}{plain: (*plain)(r)}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return err
+	}
+	r.Content = saved.Content
+	return nil
+}
@@ -0,0 +1,104 @@
+package user
+
+import (
+	"time"
+)
+
+// Revision is one immutable version of a file's content, numbered from 1
+// in the order they were written. The content is stored once in Blobs under
+// Hash, Content is that stored copy
+type Revision struct {
+	Number    int
+	Hash      string
+	Content   string `json:"-"`
+	Author    string
+	CreatedAt time.Time
+}
+
+// RevisionRetention is how many revisions a file keeps unless it sets its
+// own retention, the oldest are dropped first
+var RevisionRetention = 20
+
+// Write replaces the content of the file, recording it as a new revision by
+// author, content already stored by any file is shared rather than stored again
+func (f *File) Write(content string, author string) Revision {
+	hash, content := Blobs.put(content)
+	return f.writeBlob(hash, content, author)
+}
+
+// writeBlob records a new revision of a blob the caller took a reference to
+func (f *File) writeBlob(hash string, content string, author string) Revision {
+	f.preserve()
+	before := fileState(f)
+	now := clock.Now()
+
+	revision := Revision{Number: f.LastRevision() + 1, Hash: hash, Content: content, Author: author, CreatedAt: now}
+	f.Revisions = append(f.Revisions, revision)
+	f.prune()
+
+	f.Content = content
+	f.ModifiedAt = now
+	f.reindex()
+	publishFile(FileWritten, f, before, fileState(f))
+	return revision
+}
+
+// Revision returns the numbered revision while it is still retained
+func (f *File) Revision(number int) (Revision, error) {
+	for _, revision := range f.Revisions {
+		if revision.Number == number {
+			return revision, nil
+		}
+	}
+	return Revision{}, Errorf(NotFound, "the revision %d of %s doesn't exist", number, f.Name)
+}
+
+// Revert writes the content of an earlier revision as a new revision, so the
+// history itself is never rewritten
+func (f *File) Revert(number int, author string) (Revision, error) {
+	revision, err := f.Revision(number)
+	if err != nil {
+		return Revision{}, err
+	}
+	Blobs.retain(revision.Hash)
+	return f.writeBlob(revision.Hash, revision.Content, author), nil
+}
+
+// SetRetention changes how many revisions the file keeps, 0 restores RevisionRetention
+func (f *File) SetRetention(count int) error {
+	if count < 0 {
+		return Errorf(UsageError, "the %d is not a valid retention", count)
+	}
+	f.preserve()
+	f.Retention = count
+	f.prune()
+	return nil
+}
+
+// retention is the number of revisions the file keeps
+func (f *File) retention() int {
+	if f.Retention > 0 {
+		return f.Retention
+	}
+	return max(RevisionRetention, 1)
+}
+
+// LastRevision is the number of the current revision, 0 before the first
+// write. Numbers only grow, so a file written since holds a higher one even
+// when the content is the same
+func (f *File) LastRevision() int {
+	if len(f.Revisions) == 0 {
+		return 0
+	}
+	return f.Revisions[len(f.Revisions)-1].Number
+}
+
+// prune drops the oldest revisions beyond the retention
+func (f *File) prune() {
+	if excess := len(f.Revisions) - f.retention(); excess > 0 {
+		for _, revision := range f.Revisions[:excess] {
+			Blobs.release(revision.Hash)
+		}
+		f.Revisions = append([]Revision(nil), f.Revisions[excess:]...)
+	}
+}
@@ -0,0 +1,465 @@
+package user
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters used to rank search results
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// document is a searchable folder, or a file when file is set. Pointers keep
+// the key stable across renames, the path is resolved when results are shown
+type document struct {
+	folder *Folder
+	file   *File
+}
+
+// text is what the document is indexed by, attribute values included so
+// searching for "acme" finds the files with client=acme
+func (d document) text() string {
+	if d.file != nil {
+		return d.file.Name + " " + d.file.Description + " " + attributeText(d.file.Attributes) + " " + d.file.Content
+	}
+	return d.folder.Name + " " + d.folder.Description + " " + attributeText(d.folder.Attributes)
+}
+
+func attributeText(attributes Attributes) string {
+	values := make([]string, 0, len(attributes))
+	for _, pair := range attributes.list() {
+		values = append(values, pair[1])
+	}
+	return strings.Join(values, " ")
+}
+
+// searchIndex is an inverted index from terms to the positions they occur at
+// in every document, kept up to date on each create, delete, rename and edit
+type searchIndex struct {
+	postings map[string]map[document][]int
+	lengths  map[document]int
+	terms    map[document][]string
+	total    int
+}
+
+var fullText = newSearchIndex()
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		postings: make(map[string]map[document][]int),
+		lengths:  make(map[document]int),
+		terms:    make(map[document][]string),
+	}
+}
+
+// tokenize lowercases text and splits it on anything but letters and digits
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// add indexes the document, replacing whatever was indexed for it before
+func (idx *searchIndex) add(doc document) {
+	idx.remove(doc)
+
+	tokens := tokenize(doc.text())
+	var unique []string
+	for position, token := range tokens {
+		if idx.postings[token] == nil {
+			idx.postings[token] = make(map[document][]int)
+		}
+		if _, seen := idx.postings[token][doc]; !seen {
+			unique = append(unique, token)
+		}
+		idx.postings[token][doc] = append(idx.postings[token][doc], position)
+	}
+
+	idx.lengths[doc] = len(tokens)
+	idx.terms[doc] = unique
+	idx.total += len(tokens)
+}
+
+func (idx *searchIndex) remove(doc document) {
+	length, exists := idx.lengths[doc]
+	if !exists {
+		return
+	}
+
+	for _, term := range idx.terms[doc] {
+		delete(idx.postings[term], doc)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+
+	delete(idx.lengths, doc)
+	delete(idx.terms, doc)
+	idx.total -= length
+}
+
+// removeFolder drops the folder and every file inside it
+func (idx *searchIndex) removeFolder(folder *Folder) {
+	idx.remove(document{folder: folder})
+	for _, file := range folder.Files {
+		idx.remove(document{folder: folder, file: file})
+	}
+}
+
+// Reindex rebuilds the search index from every registered user, for state
+// that was loaded without going through the usual create and edit paths
+func Reindex() int {
+	fullText = newSearchIndex()
+	for _, u := range ListUser {
+		for _, folder := range u.Folders {
+			folder.owner = u
+			fullText.add(document{folder: folder})
+			for _, file := range folder.Files {
+				file.folder = folder
+				fullText.add(document{folder: folder, file: file})
+			}
+		}
+	}
+	return len(fullText.lengths)
+}
+
+// SearchResult is a folder or file matching a search query with its BM25 score
+type SearchResult struct {
+	User   *User
+	Folder *Folder
+	File   *File
+	Score  float64
+}
+
+func (r SearchResult) Path() string {
+	if r.File != nil {
+		return r.User.Username + "/" + r.Folder.Name + "/" + r.File.Name
+	}
+	return r.User.Username + "/" + r.Folder.Name
+}
+
+// Search runs a query over the folders and files of the user, or of every user
+// when u is nil, and returns the matches ranked by relevance.
+//
+// Words are ANDed by default, OR and NOT combine clauses, parentheses group
+// them, "quoted words" match a phrase and a trailing * matches a prefix.
+func Search(u *User, query string) ([]SearchResult, error) {
+	p := &queryParser{tokens: lexQuery(query)}
+	if len(p.tokens) == 0 {
+		return nil, Usage("search")
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, Errorf(UsageError, "unexpected %s in search query", p.tokens[p.pos])
+	}
+
+	matches := expr.eval(fullText)
+	terms := expr.terms(fullText)
+
+	results := make([]SearchResult, 0, len(matches))
+	for doc := range matches {
+		owner := doc.folder.owner
+		if owner == nil || (u != nil && owner != u) {
+			continue
+		}
+		results = append(results, SearchResult{User: owner, Folder: doc.folder, File: doc.file, Score: fullText.score(doc, terms)})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path() < results[j].Path()
+	})
+	return results, nil
+}
+
+// score sums the BM25 weight of every positive query term found in the document
+func (idx *searchIndex) score(doc document, terms []string) float64 {
+	n := float64(len(idx.lengths))
+	if n == 0 {
+		return 0
+	}
+	avgLength := float64(idx.total) / n
+	length := float64(idx.lengths[doc])
+
+	var score float64
+	for _, term := range terms {
+		frequency := float64(len(idx.postings[term][doc]))
+		if frequency == 0 {
+			continue
+		}
+		containing := float64(len(idx.postings[term]))
+		idf := math.Log(1 + (n-containing+0.5)/(containing+0.5))
+		score += idf * frequency * (bm25K1 + 1) / (frequency + bm25K1*(1-bm25B+bm25B*length/avgLength))
+	}
+	return score
+}
+
+// queryExpr is a node of a parsed search query
+type queryExpr interface {
+	eval(idx *searchIndex) map[document]bool
+	// terms lists the index terms that count towards the score
+	terms(idx *searchIndex) []string
+}
+
+type termExpr struct {
+	term   string
+	prefix bool
+}
+
+func (e termExpr) expand(idx *searchIndex) []string {
+	if !e.prefix {
+		return []string{e.term}
+	}
+
+	var terms []string
+	for term := range idx.postings {
+		if strings.HasPrefix(term, e.term) {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func (e termExpr) eval(idx *searchIndex) map[document]bool {
+	docs := make(map[document]bool)
+	for _, term := range e.expand(idx) {
+		for doc := range idx.postings[term] {
+			docs[doc] = true
+		}
+	}
+	return docs
+}
+
+func (e termExpr) terms(idx *searchIndex) []string {
+	return e.expand(idx)
+}
+
+type phraseExpr struct {
+	words []string
+}
+
+func (e phraseExpr) eval(idx *searchIndex) map[document]bool {
+	docs := make(map[document]bool)
+	for doc, positions := range idx.postings[e.words[0]] {
+		for _, start := range positions {
+			if idx.phraseAt(doc, e.words[1:], start+1) {
+				docs[doc] = true
+				break
+			}
+		}
+	}
+	return docs
+}
+
+func (idx *searchIndex) phraseAt(doc document, words []string, position int) bool {
+	for i, word := range words {
+		found := false
+		for _, p := range idx.postings[word][doc] {
+			if p == position+i {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (e phraseExpr) terms(idx *searchIndex) []string {
+	return e.words
+}
+
+type andExpr struct {
+	left, right queryExpr
+}
+
+func (e andExpr) eval(idx *searchIndex) map[document]bool {
+	left := e.left.eval(idx)
+	docs := make(map[document]bool)
+	for doc := range e.right.eval(idx) {
+		if left[doc] {
+			docs[doc] = true
+		}
+	}
+	return docs
+}
+
+func (e andExpr) terms(idx *searchIndex) []string {
+	return append(e.left.terms(idx), e.right.terms(idx)...)
+}
+
+type orExpr struct {
+	left, right queryExpr
+}
+
+func (e orExpr) eval(idx *searchIndex) map[document]bool {
+	docs := e.left.eval(idx)
+	for doc := range e.right.eval(idx) {
+		docs[doc] = true
+	}
+	return docs
+}
+
+func (e orExpr) terms(idx *searchIndex) []string {
+	return append(e.left.terms(idx), e.right.terms(idx)...)
+}
+
+type notExpr struct {
+	expr queryExpr
+}
+
+func (e notExpr) eval(idx *searchIndex) map[document]bool {
+	excluded := e.expr.eval(idx)
+	docs := make(map[document]bool)
+	for doc := range idx.lengths {
+		if !excluded[doc] {
+			docs[doc] = true
+		}
+	}
+	return docs
+}
+
+func (e notExpr) terms(idx *searchIndex) []string {
+	return nil
+}
+
+// lexQuery splits a query into words, quoted phrases, parentheses and operators
+func lexQuery(query string) []string {
+	var tokens []string
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; {
+		case c == '"' || c == '\'':
+			flush()
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			tokens = append(tokens, `"`+query[i+1:i+1+end]+`"`)
+			i += end + 1
+		case c == '(' || c == ')':
+			flush()
+			tokens = append(tokens, string(c))
+		case c == ' ' || c == '\t':
+			flush()
+		default:
+			current.WriteByte(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+type queryParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *queryParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *queryParser) parseOr() (queryExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "OR" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseAnd() (queryExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for next := p.peek(); next != "" && next != "OR" && next != ")"; next = p.peek() {
+		if next == "AND" {
+			p.pos++
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *queryParser) parseUnary() (queryExpr, error) {
+	token := p.peek()
+	switch {
+	case token == "":
+		return nil, Errorf(UsageError, "unexpected end of search query")
+	case token == "NOT":
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	case token == "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, Errorf(UsageError, "missing ) in search query")
+		}
+		p.pos++
+		return expr, nil
+	case token == ")" || token == "AND" || token == "OR":
+		return nil, Errorf(UsageError, "unexpected %s in search query", token)
+	case strings.HasPrefix(token, `"`):
+		p.pos++
+		words := tokenize(strings.Trim(token, `"`))
+		if len(words) == 0 {
+			return nil, Errorf(UsageError, "empty phrase in search query")
+		}
+		if len(words) == 1 {
+			return termExpr{term: words[0]}, nil
+		}
+		return phraseExpr{words: words}, nil
+	default:
+		p.pos++
+		prefix := strings.HasSuffix(token, "*")
+		words := tokenize(strings.TrimSuffix(token, "*"))
+		if len(words) == 0 {
+			return nil, Errorf(UsageError, "the %s is not a searchable term", token)
+		}
+		if len(words) > 1 {
+			return phraseExpr{words: words}, nil
+		}
+		return termExpr{term: words[0], prefix: prefix}, nil
+	}
+}
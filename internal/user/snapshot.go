@@ -0,0 +1,252 @@
+package user
+
+import (
+	"maps"
+	"slices"
+	"sort"
+	"time"
+)
+
+// Snapshot is a read-only point-in-time copy of a user's folders. Taking one
+// only shares the live folder map, the first change to the map, a folder or
+// a file afterwards moves a copy of its old state into every snapshot still
+// sharing it, so unchanged entries are never copied
+type Snapshot struct {
+	Name      string
+	CreatedAt time.Time
+	// Folders is keyed by the lowercased folder name like User.Folders
+	Folders map[string]*Folder
+	Seq     uint64
+
+	// shared reports whether Folders is still the live map of the user
+	shared bool
+}
+
+// CreateSnapshot freezes the current folders of the user under name
+func (u *User) CreateSnapshot(name string) (*Snapshot, error) {
+	name, err := validateName(name, "snapshot name", MaxFolderNameLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, exists := u.Snapshots[nameKey(name)]; exists {
+		return nil, Errorf(AlreadyExists, "the snapshot %s has already existed", name)
+	}
+
+	snapshot := &Snapshot{
+		Name:      name,
+		CreatedAt: clock.Now(),
+		Folders:   u.Folders,
+		Seq:       nextSeq(),
+		shared:    true,
+	}
+	if u.Snapshots == nil {
+		u.Snapshots = make(map[string]*Snapshot)
+	}
+	u.Snapshots[nameKey(name)] = snapshot
+	return snapshot, nil
+}
+
+func (u *User) GetSnapshot(name string) (*Snapshot, error) {
+	snapshot, exists := u.Snapshots[nameKey(name)]
+	if !exists {
+		return nil, Errorf(NotFound, "the snapshot %s doesn't exist", name)
+	}
+	return snapshot, nil
+}
+
+// ListSnapshots returns the snapshots of the user, oldest first
+func (u *User) ListSnapshots() []*Snapshot {
+	snapshots := slices.Collect(maps.Values(u.Snapshots))
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Seq < snapshots[j].Seq
+	})
+	return snapshots
+}
+
+func (u *User) DeleteSnapshot(name string) error {
+	snapshot, err := u.GetSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	delete(u.Snapshots, nameKey(name))
+	releaseFolders(snapshot.Folders)
+	return nil
+}
+
+// releaseFolders drops the blob references of the files of folders no
+// longer held, files still held elsewhere keep theirs
+func releaseFolders(folders map[string]*Folder) {
+	for _, folder := range folders {
+		for _, file := range folder.Files {
+			file.releaseBlobs()
+		}
+	}
+}
+
+// RestoreSnapshot replaces the folders of the user with the ones of the
+// snapshot, which keeps sharing them until either side changes
+func (u *User) RestoreSnapshot(name string) error {
+	snapshot, err := u.GetSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	// The live map is replaced rather than changed, so snapshots sharing it
+	// simply keep it
+	for _, other := range u.Snapshots {
+		other.shared = false
+	}
+	for key, folder := range u.Folders {
+		u.unindexFolder(folder, key)
+	}
+
+	replaced := u.Folders
+	before := make(map[string]*State, len(replaced))
+	for key, folder := range replaced {
+		before[key] = folderState(folder)
+	}
+	u.Folders = maps.Clone(snapshot.Folders)
+	releaseFolders(replaced)
+	for key, folder := range u.Folders {
+		u.indexFolder(folder, key)
+	}
+	u.ModifiedAt = clock.Now()
+	Reindex()
+
+	// Folders the live tree still shares with the snapshot didn't change
+	keys := slices.Collect(maps.Keys(replaced))
+	for key := range u.Folders {
+		if _, exists := replaced[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		old, restored := replaced[key], u.Folders[key]
+		switch {
+		case restored == nil:
+			publishFolder(FolderDeleted, old, before[key], nil)
+		case old == nil:
+			publishFolder(FolderCreated, restored, nil, folderState(restored))
+		case old != restored:
+			publishFolder(FolderUpdated, restored, before[key], folderState(restored))
+		}
+	}
+	return nil
+}
+
+func (s *Snapshot) GetFolder(folderName string) (*Folder, error) {
+	folder, exists := s.Folders[nameKey(folderName)]
+	if !exists {
+		return nil, Errorf(NotFound, "the %s doesn't exist in snapshot %s", folderName, s.Name)
+	}
+	return folder, nil
+}
+
+// FileCount is the number of files across the folders of the snapshot
+func (s *Snapshot) FileCount() int {
+	count := 0
+	for _, folder := range s.Folders {
+		count += len(folder.Files)
+	}
+	return count
+}
+
+// ListFilesPage returns the files of a folder of the snapshot matching opts,
+// reading a snapshot never records an access
+func (s *Snapshot) ListFilesPage(folderName string, opts ListOptions) (Page[*File], error) {
+	folder, err := s.GetFolder(folderName)
+	if err != nil {
+		return Page[*File]{}, err
+	}
+
+	files := slices.Collect(maps.Values(folder.Files))
+
+	var keep func(*File) bool
+	if opts.Tags != nil {
+		// The tag index follows the live tree, snapshots match their own tags
+		keep = func(file *File) bool { return opts.Tags.Matches(file.Tags) }
+	}
+
+	return paginate(files, fileKey, keep, opts, "list-files")
+}
+
+// preserveFolders gives snapshots sharing the live folder map a copy of it,
+// call it before adding, removing or renaming folders
+func (u *User) preserveFolders() {
+	for _, snapshot := range u.Snapshots {
+		if snapshot.shared {
+			snapshot.Folders = maps.Clone(u.Folders)
+			snapshot.shared = false
+		}
+	}
+}
+
+// preserve gives snapshots still holding the folder a copy of its current
+// state, call it before changing the folder or its file map
+func (f *Folder) preserve() {
+	if f.owner == nil || len(f.owner.Snapshots) == 0 {
+		return
+	}
+
+	key := nameKey(f.Name)
+	var clone *Folder
+	for _, snapshot := range f.owner.Snapshots {
+		if snapshot.Folders[key] != f {
+			continue
+		}
+		if snapshot.shared {
+			f.owner.preserveFolders()
+		}
+		if clone == nil {
+			clone = f.clone()
+		}
+		snapshot.Folders[key] = clone
+	}
+}
+
+// preserve gives snapshots still holding the file a copy of its current
+// state, call it before changing the file
+func (f *File) preserve() {
+	if f.folder == nil || f.folder.owner == nil || len(f.folder.owner.Snapshots) == 0 {
+		return
+	}
+
+	// Afterwards no snapshot holds the live folder, so only copies are changed
+	f.folder.preserve()
+
+	key := nameKey(f.Name)
+	var clone *File
+	for _, snapshot := range f.folder.owner.Snapshots {
+		// The folder may have been renamed since, so look in every folder
+		for _, folder := range snapshot.Folders {
+			if folder.Files[key] != f {
+				continue
+			}
+			if clone == nil {
+				clone = f.clone()
+			}
+			folder.Files[key] = clone
+		}
+	}
+}
+
+func (f *Folder) clone() *Folder {
+	clone := *f
+	clone.Files = maps.Clone(f.Files)
+	clone.Attributes = maps.Clone(f.Attributes)
+	clone.Tags = slices.Clone(f.Tags)
+	return &clone
+}
+
+// clone copies the file, contents and revisions are immutable so they are
+// shared, the copy taking its own references to their blobs
+func (f *File) clone() *File {
+	clone := *f
+	clone.Attributes = maps.Clone(f.Attributes)
+	clone.Tags = slices.Clone(f.Tags)
+	clone.retainBlobs()
+	return &clone
+}
@@ -0,0 +1,106 @@
+package user
+
+import (
+	"cmp"
+	"repl-cli-iscoollab/internal/utils"
+	"strings"
+	"sync/atomic"
+)
+
+// SortFields are the fields a listing can be sorted on with --sort
+var SortFields = []string{"name", "created", "modified", "size", "description"}
+
+// SortKey is one field of a multi-key sort with its own direction
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+// sequence numbers folders and files in creation order, it breaks ties
+// between entries equal on every sort key so sorting stays stable
+var sequence atomic.Uint64
+
+func nextSeq() uint64 {
+	return sequence.Add(1)
+}
+
+// ParseSortKeys reads a comma separated list such as "created:desc,name",
+// every key is ascending unless followed by :desc
+func ParseSortKeys(spec string) ([]SortKey, error) {
+	var keys []SortKey
+	for _, part := range strings.Split(strings.ToLower(spec), ",") {
+		field, direction, _ := strings.Cut(strings.TrimSpace(part), ":")
+
+		valid := false
+		for _, f := range SortFields {
+			if f == field {
+				valid = true
+			}
+		}
+		if !valid {
+			return nil, Errorf(UsageError, "the %s is not a sort field, use one of %s", field, strings.Join(SortFields, ", "))
+		}
+
+		switch direction {
+		case "", "asc":
+			keys = append(keys, SortKey{Field: field})
+		case "desc":
+			keys = append(keys, SortKey{Field: field, Desc: true})
+		default:
+			return nil, Errorf(UsageError, "the %s is not a sort direction, use asc or desc", direction)
+		}
+	}
+	return keys, nil
+}
+
+// LegacySortKeys maps the --sort-name, --sort-created and --sort-modified
+// flags to sort keys, timestamps fall back to the name in the same direction
+func LegacySortKeys(sortBy string, sortOrder string, usage string) ([]SortKey, error) {
+	var desc bool
+	switch sortOrder {
+	case "", "asc":
+		desc = false
+	case "desc":
+		desc = true
+	default:
+		return nil, Usage(usage)
+	}
+
+	switch sortBy {
+	case "", "--sort-name":
+		return []SortKey{{"name", desc}}, nil
+	case "--sort-created":
+		return []SortKey{{"created", desc}, {"name", desc}}, nil
+	case "--sort-modified":
+		return []SortKey{{"modified", desc}, {"name", desc}}, nil
+	default:
+		return nil, Usage(usage)
+	}
+}
+
+// compareEntries orders two entries on each key in turn, names and
+// descriptions compare numbers by value and the creation order breaks ties
+func compareEntries(a entryKey, b entryKey, keys []SortKey) int {
+	for _, key := range keys {
+		var c int
+		switch key.Field {
+		case "name":
+			c = utils.NaturalCompare(a.Name, b.Name)
+		case "created":
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		case "modified":
+			c = a.ModifiedAt.Compare(b.ModifiedAt)
+		case "size":
+			c = cmp.Compare(a.Size, b.Size)
+		case "description":
+			c = utils.NaturalCompare(a.Description, b.Description)
+		}
+		if key.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(a.Seq, b.Seq)
+}
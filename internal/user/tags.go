@@ -0,0 +1,284 @@
+package user
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const MaxTagLength = 64
+
+// tagRef points at a tagged folder, or a file inside it when file is set,
+// both hold the lowercased names used as map keys
+type tagRef struct {
+	folder string
+	file   string
+}
+
+// TagQuery matches entries carrying every tag of at least one of its groups,
+// "a+b,c" parses to [[a b] [c]] meaning (a AND b) OR c
+type TagQuery [][]string
+
+// Matches reports whether the tags satisfy the query
+func (q TagQuery) Matches(tags []string) bool {
+	for _, group := range q {
+		all := true
+		for _, tag := range group {
+			if !slices.Contains(tags, tag) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+func ParseTagQuery(query string) (TagQuery, error) {
+	var q TagQuery
+	for _, group := range strings.Split(strings.ToLower(query), ",") {
+		var tags []string
+		for _, tag := range strings.Split(group, "+") {
+			tag, err := normalizeName(tag)
+			if err != nil {
+				return nil, err
+			}
+			tags = append(tags, tag)
+		}
+		q = append(q, tags)
+	}
+	return q, nil
+}
+
+func validateTag(tag string) (string, error) {
+	return validateName(tag, "tag", MaxTagLength)
+}
+
+// addTag inserts tag into the sorted tags, reporting false when already present
+func addTag(tags []string, tag string) ([]string, bool) {
+	i := sort.SearchStrings(tags, tag)
+	if i < len(tags) && tags[i] == tag {
+		return tags, false
+	}
+	tags = append(tags, "")
+	copy(tags[i+1:], tags[i:])
+	tags[i] = tag
+	return tags, true
+}
+
+// removeTag deletes tag from the sorted tags, reporting false when missing
+func removeTag(tags []string, tag string) ([]string, bool) {
+	i := sort.SearchStrings(tags, tag)
+	if i >= len(tags) || tags[i] != tag {
+		return tags, false
+	}
+	return append(tags[:i], tags[i+1:]...), true
+}
+
+func (u *User) index(tag string, ref tagRef) {
+	if u.tagIndex == nil {
+		u.tagIndex = make(map[string]map[tagRef]bool)
+	}
+	if u.tagIndex[tag] == nil {
+		u.tagIndex[tag] = make(map[tagRef]bool)
+	}
+	u.tagIndex[tag][ref] = true
+}
+
+func (u *User) unindex(tag string, ref tagRef) {
+	delete(u.tagIndex[tag], ref)
+	if len(u.tagIndex[tag]) == 0 {
+		delete(u.tagIndex, tag)
+	}
+}
+
+// unindexFolder drops the folder and every file inside it from the tag index
+func (u *User) unindexFolder(folder *Folder, folderName string) {
+	for _, tag := range folder.Tags {
+		u.unindex(tag, tagRef{folder: folderName})
+	}
+	for fileName, file := range folder.Files {
+		for _, tag := range file.Tags {
+			u.unindex(tag, tagRef{folder: folderName, file: fileName})
+		}
+	}
+}
+
+func (u *User) indexFolder(folder *Folder, folderName string) {
+	for _, tag := range folder.Tags {
+		u.index(tag, tagRef{folder: folderName})
+	}
+	for fileName, file := range folder.Files {
+		for _, tag := range file.Tags {
+			u.index(tag, tagRef{folder: folderName, file: fileName})
+		}
+	}
+}
+
+func (u *User) TagFolder(folderName string, tag string) error {
+	folder, err := u.GetFolder(folderName)
+	if err != nil {
+		return err
+	}
+
+	tag, err = validateTag(tag)
+	if err != nil {
+		return err
+	}
+
+	folder.preserve()
+	before := folderState(folder)
+	tags, added := addTag(folder.Tags, tag)
+	if !added {
+		return Errorf(AlreadyExists, "the %s is already tagged %s", folderName, tag)
+	}
+
+	folder.Tags = tags
+	folder.ModifiedAt = clock.Now()
+	u.index(tag, tagRef{folder: nameKey(folderName)})
+	publishFolder(FolderUpdated, folder, before, folderState(folder))
+	return nil
+}
+
+func (u *User) UntagFolder(folderName string, tag string) error {
+	folder, err := u.GetFolder(folderName)
+	if err != nil {
+		return err
+	}
+
+	tag = lookupName(tag)
+	folder.preserve()
+	before := folderState(folder)
+	tags, removed := removeTag(folder.Tags, tag)
+	if !removed {
+		return Errorf(NotFound, "the %s is not tagged %s", folderName, tag)
+	}
+
+	folder.Tags = tags
+	folder.ModifiedAt = clock.Now()
+	u.unindex(tag, tagRef{folder: nameKey(folderName)})
+	publishFolder(FolderUpdated, folder, before, folderState(folder))
+	return nil
+}
+
+func (u *User) TagFile(folderName string, fileName string, tag string) error {
+	folder, err := u.GetFolder(folderName)
+	if err != nil {
+		return err
+	}
+
+	file, err := folder.GetFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	tag, err = validateTag(tag)
+	if err != nil {
+		return err
+	}
+
+	file.preserve()
+	before := fileState(file)
+	tags, added := addTag(file.Tags, tag)
+	if !added {
+		return Errorf(AlreadyExists, "the %s is already tagged %s", fileName, tag)
+	}
+
+	file.Tags = tags
+	file.ModifiedAt = clock.Now()
+	u.index(tag, tagRef{folder: nameKey(folderName), file: nameKey(fileName)})
+	publishFile(FileUpdated, file, before, fileState(file))
+	return nil
+}
+
+func (u *User) UntagFile(folderName string, fileName string, tag string) error {
+	folder, err := u.GetFolder(folderName)
+	if err != nil {
+		return err
+	}
+
+	file, err := folder.GetFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	tag = lookupName(tag)
+	file.preserve()
+	before := fileState(file)
+	tags, removed := removeTag(file.Tags, tag)
+	if !removed {
+		return Errorf(NotFound, "the %s is not tagged %s", fileName, tag)
+	}
+
+	file.Tags = tags
+	file.ModifiedAt = clock.Now()
+	u.unindex(tag, tagRef{folder: nameKey(folderName), file: nameKey(fileName)})
+	publishFile(FileUpdated, file, before, fileState(file))
+	return nil
+}
+
+// ListTags returns every tag used by the user with the number of tagged entries
+func (u *User) ListTags() [][2]string {
+	tags := make([][2]string, 0, len(u.tagIndex))
+	for tag, refs := range u.tagIndex {
+		tags = append(tags, [2]string{tag, fmt.Sprint(len(refs))})
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i][0] < tags[j][0]
+	})
+	return tags
+}
+
+// match resolves the query against the tag index, keeping refs accepted by keep
+func (u *User) match(query TagQuery, keep func(tagRef) bool) map[tagRef]bool {
+	matches := make(map[tagRef]bool)
+	for _, group := range query {
+		// Start from the smallest set so the intersection stays cheap, on a
+		// copy so the query of the caller keeps its order
+		group = slices.Clone(group)
+		sort.Slice(group, func(i, j int) bool {
+			return len(u.tagIndex[group[i]]) < len(u.tagIndex[group[j]])
+		})
+
+		for ref := range u.tagIndex[group[0]] {
+			if !keep(ref) {
+				continue
+			}
+
+			all := true
+			for _, tag := range group[1:] {
+				if !u.tagIndex[tag][ref] {
+					all = false
+					break
+				}
+			}
+			if all {
+				matches[ref] = true
+			}
+		}
+	}
+	return matches
+}
+
+// TaggedFolders returns the keys of the folders matching the query
+func (u *User) TaggedFolders(query TagQuery) map[string]bool {
+	folders := make(map[string]bool)
+	for ref := range u.match(query, func(ref tagRef) bool { return ref.file == "" }) {
+		folders[ref.folder] = true
+	}
+	return folders
+}
+
+// TaggedFiles returns the keys of the files inside folderName matching the query
+func (u *User) TaggedFiles(folderName string, query TagQuery) map[string]bool {
+	files := make(map[string]bool)
+	folderKey := nameKey(folderName)
+	for ref := range u.match(query, func(ref tagRef) bool { return ref.folder == folderKey && ref.file != "" }) {
+		files[ref.file] = true
+	}
+	return files
+}
@@ -1,164 +1,285 @@
 package user
 
 import (
-	"fmt"
 	"repl-cli-iscoollab/internal/utils"
-	"sort"
+	"strings"
 	"time"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 var (
+	// ListUser maps the lowercased username to the user, see nameKey
 	ListUser = make(map[string]*User)
 
 	CommandsUsage = map[string]string{
-		"list-files":    "Usage: list-files [username] [foldername] [--sort-name|--sort-created] [asc|desc]",
-		"create-file":   "Usage: create-file [username] [foldername] [filename] [description]?",
-		"delete-file":   "Usage: delete-file [username] [foldername] [filename]",
-		"register":      "Usage: register [username]",
-		"create-folder": "Usage: create-folder [username] [foldername] [description]?",
-		"list-folders":  "Usage: list-folders [username] [--sort-name|--sort-created] [asc|desc]",
-		"delete-folder": "Usage: delete-folder [username] [foldername]",
-		"rename-folder": "Usage: rename-folder [username] [foldername] [new-folder-name]",
-		"help":          "Usage: help",
-		"exit":          "Usage: exit",
+		"list-files":         "Usage: list-files [username] [foldername] [--sort-name|--sort-created|--sort-modified] [asc|desc] [--sort key[:asc|desc],...]? [--tag tag1+tag2,tag3]? [--name-prefix prefix]? [--created-after time]? [--created-before time]? [--limit n]? [--offset n]? [--cursor token]? [--snapshot name]?",
+		"create-file":        "Usage: create-file [username] [foldername] [filename] [description]?",
+		"delete-file":        "Usage: delete-file [username] [foldername] [filename]",
+		"write-file":         "Usage: write-file [username] [foldername] [filename] [content]",
+		"cat":                "Usage: cat [username] [foldername] [filename] [--rev n]? [--snapshot name]?",
+		"edit":               "Usage: edit [username] [foldername] [filename]",
+		"file-history":       "Usage: file-history [username] [foldername] [filename]",
+		"diff-file":          "Usage: diff-file [username] [foldername] [filename] [rev1] [rev2]?",
+		"revert-file":        "Usage: revert-file [username] [foldername] [filename] [rev]",
+		"set-retention":      "Usage: set-retention [username] [foldername] [filename] [count]",
+		"stat":               "Usage: stat [username][/foldername]?[/filename]?",
+		"copy-file":          "Usage: copy-file [username] [foldername] [filename] [to-foldername] [to-filename]? [--to-user username]?",
+		"copy-folder":        "Usage: copy-folder [username] [foldername] [to-foldername]? [--to-user username]?",
+		"gc":                 "Usage: gc",
+		"snapshot":           "Usage: snapshot [create|list|restore|delete] [username] [name]?",
+		"import-dir":         "Usage: import-dir [host-path] [username] [foldername]? [--dry-run]? [--sanitize replace|skip]? [--hidden]?",
+		"export":             "Usage: export [username] [host-path] [--format dir|tar|tar.gz|zip]?",
+		"sync":               "Usage: sync [username]/[foldername] [host-dir] [--conflict skip|vfs|host|newer]? [--watch]? [--interval d]?",
+		"unsync":             "Usage: unsync [username]/[foldername]",
+		"find":               "Usage: find [username|--all-users] [--sort-name|--sort-created|--sort-modified] [asc|desc] [--sort key[:asc|desc],...]? [--name glob]? [--regex pattern]? [--description text]? [--created-after time]? [--created-before time]? [--min-size bytes]? [--max-size bytes]? [--tag tags]? [--attr key[=value]]?",
+		"register":           "Usage: register [username]",
+		"create-folder":      "Usage: create-folder [username] [foldername] [description]?",
+		"list-folders":       "Usage: list-folders [username] [--sort-name|--sort-created|--sort-modified] [asc|desc] [--sort key[:asc|desc],...]? [--tag tag1+tag2,tag3]? [--name-prefix prefix]? [--created-after time]? [--created-before time]? [--limit n]? [--offset n]? [--cursor token]?",
+		"delete-folder":      "Usage: delete-folder [username] [foldername]",
+		"rename-folder":      "Usage: rename-folder [username] [foldername] [new-folder-name]",
+		"tag":                "Usage: tag [username] [foldername] [filename]? [tag]",
+		"untag":              "Usage: untag [username] [foldername] [filename]? [tag]",
+		"list-tags":          "Usage: list-tags [username] [foldername]? [filename]?",
+		"tree":               "Usage: tree [username] [foldername]? [--depth n]? [--description]? [--size]? [--time]? [--ascii]?",
+		"search":             "Usage: search [username|--all-users] [query]",
+		"reindex":            "Usage: reindex",
+		"set":                "Usage: set [output|time-format|timezone|actor] [value]",
+		"audit":              "Usage: audit [--actor name]? [--user username]? [--command name]? [--since time]? [--until time]?",
+		"config":             "Usage: config show",
+		"watch":              "Usage: watch [username]? [foldername]?",
+		"unwatch":            "Usage: unwatch",
+		"add-webhook":        "Usage: add-webhook [url] [secret] [username]? [foldername]?",
+		"list-webhooks":      "Usage: list-webhooks",
+		"remove-webhook":     "Usage: remove-webhook [webhook-id]",
+		"webhook-deliveries": "Usage: webhook-deliveries [webhook-id]? [--dead]?",
+		"redeliver-webhook":  "Usage: redeliver-webhook [delivery-id]",
+		"set-description":    "Usage: set-description [username] [foldername] [filename]? [description]",
+		"clear-description":  "Usage: clear-description [username] [foldername] [filename]?",
+		"set-attr":           "Usage: set-attr [username] [foldername] [filename]? [key] [value]",
+		"get-attr":           "Usage: get-attr [username] [foldername] [filename]? [key]",
+		"list-attrs":         "Usage: list-attrs [username] [foldername] [filename]?",
+		"unset-attr":         "Usage: unset-attr [username] [foldername] [filename]? [key]",
+		"help":               "Usage: help",
+		"exit":               "Usage: exit",
 	}
 )
 
-const (
+// Name length limits, counted as the name policy measures lengths, a
+// deployment can change them in its config file
+var (
 	MaxUsernameLength   = 25
 	MaxFolderNameLength = 255
 	MaxFileNameLength   = 255
 )
 
 type User struct {
-	Username string
-	Folders  map[string]*Folder
+	Username   string
+	CreatedAt  time.Time
+	ModifiedAt time.Time
+	AccessedAt time.Time
+	// Folders is keyed by the lowercased folder name, see nameKey
+	Folders map[string]*Folder
+	// Snapshots is keyed by the lowercased snapshot name
+	Snapshots map[string]*Snapshot `json:",omitempty"`
+
+	// tagIndex maps each tag to the folders and files carrying it
+	tagIndex map[string]map[tagRef]bool
 }
 
-func (u *User) CreateFolder(folderName string, description string) error {
-	if !utils.ValidateString(folderName) {
-		return fmt.Errorf("the %s contain invalid chars", folderName)
+// nameKey is the key users, folders and files are stored under, names keep
+// their casing for display while lookups ignore it
+func nameKey(name string) string {
+	return strings.ToLower(norm.NFC.String(name))
+}
+
+// validateName normalizes name under the name policy in effect and checks
+// its length, label names what is validated in the error
+func validateName(name string, label string, maxLength int) (string, error) {
+	name, err := normalizeName(name)
+	if err != nil {
+		return "", err
+	}
+
+	if utils.Policy.Length(name) > maxLength {
+		return "", Errorf(TooLong, "%s is too long, max length allowed is %d", label, maxLength)
 	}
 
-	if _, exists := u.Folders[folderName]; exists {
-		return fmt.Errorf("the %s has already existed", folderName)
+	return name, nil
+}
+
+// lookupName is name as validateName stores it, a name the policy rejects
+// can't be stored and is looked up as typed
+func lookupName(name string) string {
+	if normalized, err := normalizeName(name); err == nil {
+		return normalized
+	}
+	return name
+}
+
+// normalizeName applies the name policy, reporting rejected names as InvalidName
+func normalizeName(name string) (string, error) {
+	name, err := utils.Policy.Normalize(name)
+	if err != nil {
+		return "", &Error{Kind: InvalidName, Message: err.Error()}
 	}
+	return name, nil
+}
 
-	if len(folderName) > MaxFolderNameLength {
-		return fmt.Errorf("foldername is too long, max length allowed is %d", MaxFolderNameLength)
+func (u *User) CreateFolder(folderName string, description string) error {
+	folderName, err := validateName(folderName, "foldername", MaxFolderNameLength)
+	if err != nil {
+		return err
 	}
 
+	if _, exists := u.Folders[nameKey(folderName)]; exists {
+		return Errorf(AlreadyExists, "the %s has already existed", folderName)
+	}
+
+	now := clock.Now()
 	folder := &Folder{
 		Name:        folderName,
-		CreatedAt:   time.Now().Format("2006-01-02 15:04:05"),
+		CreatedAt:   now,
+		ModifiedAt:  now,
+		AccessedAt:  now,
 		Description: description,
+		Attributes:  make(Attributes),
 		Files:       make(map[string]*File),
+		Seq:         nextSeq(),
+		owner:       u,
 	}
 
-	u.Folders[folderName] = folder
+	u.preserveFolders()
+	u.Folders[nameKey(folderName)] = folder
+	u.ModifiedAt = now
+	fullText.add(document{folder: folder})
+	publishFolder(FolderCreated, folder, nil, folderState(folder))
 
 	return nil
 }
 
+// CopyFolder adds a copy of src, which may belong to any user, named
+// folderName, copying every file like Folder.CopyFile
+func (u *User) CopyFolder(src *Folder, folderName string, author string) error {
+	if err := u.CreateFolder(folderName, src.Description); err != nil {
+		return err
+	}
+	folder := u.Folders[nameKey(folderName)]
+
+	for key, value := range src.Attributes {
+		folder.Attributes[key] = value
+	}
+	folder.reindex()
+	for _, tag := range src.Tags {
+		folder.Tags, _ = addTag(folder.Tags, tag)
+		u.index(tag, tagRef{folder: nameKey(folder.Name)})
+	}
+	for _, file := range src.Files {
+		if err := folder.CopyFile(file, file.Name, author); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (u *User) DeleteFolder(folderName string) error {
-	if _, exists := u.Folders[folderName]; exists {
-		delete(u.Folders, folderName)
+	if folder, exists := u.Folders[nameKey(folderName)]; exists {
+		u.unindexFolder(folder, nameKey(folderName))
+		fullText.removeFolder(folder)
+		u.preserveFolders()
+		delete(u.Folders, nameKey(folderName))
+		for _, file := range folder.Files {
+			file.releaseBlobs()
+		}
+		u.ModifiedAt = clock.Now()
+		publishFolder(FolderDeleted, folder, folderState(folder), nil)
 		return nil
 	}
 
-	return fmt.Errorf("the %s doesn't exist", folderName)
+	return Errorf(NotFound, "the %s doesn't exist", folderName)
 }
 
 func (u *User) ListFolders(sortBy string, sortOrder string) ([]*Folder, error) {
-	var isAsc bool
-	switch sortOrder {
-	case "asc":
-		isAsc = true
-	case "desc":
-		isAsc = false
-	default:
-		return nil, fmt.Errorf(CommandsUsage["list-folders"])
-	}
-
-	folders := make([]*Folder, 0, len(u.Folders))
-	for _, folder := range u.Folders {
-		folders = append(folders, folder)
-	}
-
-	switch sortBy {
-	case "--sort-name":
-		sort.Slice(folders, func(i, j int) bool {
-			if isAsc {
-				return folders[i].Name < folders[j].Name
-			}
-			return folders[i].Name > folders[j].Name
-		})
-	case "--sort-created":
-		sort.Slice(folders, func(i, j int) bool {
-			if isAsc {
-				return folders[i].CreatedAt < folders[j].CreatedAt
-			}
-			return folders[i].CreatedAt > folders[j].CreatedAt
-		})
-	default:
-		return nil, fmt.Errorf(CommandsUsage["list-folders"])
-	}
-
-	return folders, nil
+	page, err := u.ListFoldersPage(ListOptions{SortBy: sortBy, SortOrder: sortOrder})
+	if err != nil {
+		return nil, err
+	}
+
+	return page.Items, nil
 }
 
+// RenameFolder moves the folder to a new name, renaming to a name that only
+// differs in case keeps the folder in place and updates its casing
 func (u *User) RenameFolder(folderName string, newFolderName string) error {
-	folder, exists := u.Folders[folderName]
+	oldKey, newKey := nameKey(folderName), nameKey(newFolderName)
+	folder, exists := u.Folders[oldKey]
 	if !exists {
-		return fmt.Errorf("the %s doesn't exist", folderName)
+		return Errorf(NotFound, "the %s doesn't exist", folderName)
 	}
 
-	if _, exists := u.Folders[newFolderName]; exists {
-		return fmt.Errorf("the %s already exists", newFolderName)
+	newFolderName, err := validateName(newFolderName, "foldername", MaxFolderNameLength)
+	if err != nil {
+		return err
 	}
 
+	if _, exists := u.Folders[newKey]; exists && newKey != oldKey {
+		return Errorf(AlreadyExists, "the %s already exists", newFolderName)
+	}
+
+	folder.preserve()
+	u.preserveFolders()
+	before := folderState(folder)
+	now := clock.Now()
+	u.unindexFolder(folder, oldKey)
 	folder.Name = newFolderName
-	u.Folders[newFolderName] = folder
-	delete(u.Folders, folderName)
+	folder.ModifiedAt = now
+	delete(u.Folders, oldKey)
+	u.Folders[newKey] = folder
+	u.indexFolder(folder, newKey)
+	fullText.add(document{folder: folder})
+	u.ModifiedAt = now
+	publishFolder(FolderRenamed, folder, before, folderState(folder))
 
 	return nil
 }
 
 func (u *User) GetFolder(folderName string) (*Folder, error) {
-	folder, exists := u.Folders[folderName]
+	folder, exists := u.Folders[nameKey(folderName)]
 	if !exists {
-		return nil, fmt.Errorf("the %s doesn't exist", folderName)
+		return nil, Errorf(NotFound, "the %s doesn't exist", folderName)
 	}
 	return folder, nil
 }
 
 func RegisterUser(username string) error {
-	if _, exists := ListUser[username]; exists {
-		return fmt.Errorf("the %s has already existed", username)
-	}
-
-	if !utils.ValidateString(username) {
-		return fmt.Errorf("the %s contain invalid chars", username)
+	if _, exists := ListUser[nameKey(username)]; exists {
+		return Errorf(AlreadyExists, "the %s has already existed", username)
 	}
 
-	if len(username) > MaxUsernameLength {
-		return fmt.Errorf("username is too long, max length allowed is %d", MaxUsernameLength)
+	username, err := validateName(username, "username", MaxUsernameLength)
+	if err != nil {
+		return err
 	}
 
+	now := clock.Now()
 	newUser := &User{
-		Username: username,
-		Folders:  make(map[string]*Folder),
+		Username:   username,
+		CreatedAt:  now,
+		ModifiedAt: now,
+		AccessedAt: now,
+		Folders:    make(map[string]*Folder),
 	}
 
-	ListUser[username] = newUser
+	ListUser[nameKey(username)] = newUser
+	publishUser(UserRegistered, newUser)
 
 	return nil
 }
 
 func GetUser(username string) (*User, error) {
-	if user, exists := ListUser[username]; exists {
+	if user, exists := ListUser[nameKey(username)]; exists {
 		return user, nil
 	}
 
-	return nil, fmt.Errorf("the %s doesn't exist", username)
+	return nil, Errorf(NotFound, "the %s doesn't exist", username)
 }
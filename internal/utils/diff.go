@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change
+const diffContext = 3
+
+type diffLine struct {
+	// op is ' ' for a kept line, '-' for a removed one and '+' for an added one
+	op   byte
+	text string
+	// from and to count the lines of each side before this one
+	from, to int
+}
+
+// UnifiedDiff compares two texts line by line and returns the changes in
+// unified diff format with three lines of context, empty when they are equal
+func UnifiedDiff(fromName string, toName string, from string, to string) string {
+	lines := diffLines(splitLines(from), splitLines(to))
+
+	var output strings.Builder
+	for start := 0; start < len(lines); {
+		for start < len(lines) && lines[start].op == ' ' {
+			start++
+		}
+		if start == len(lines) {
+			break
+		}
+
+		// Changes separated by few enough kept lines share a hunk
+		last := start
+		for i := start + 1; i < len(lines); i++ {
+			if lines[i].op == ' ' {
+				continue
+			}
+			if i-last-1 > 2*diffContext {
+				break
+			}
+			last = i
+		}
+		first := max(start-diffContext, 0)
+		end := min(last+diffContext+1, len(lines))
+
+		if output.Len() == 0 {
+			fmt.Fprintf(&output, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		writeHunk(&output, lines[first:end])
+		start = end
+	}
+	return output.String()
+}
+
+func writeHunk(output *strings.Builder, hunk []diffLine) {
+	fromCount, toCount := 0, 0
+	for _, line := range hunk {
+		if line.op != '+' {
+			fromCount++
+		}
+		if line.op != '-' {
+			toCount++
+		}
+	}
+
+	fmt.Fprintf(output, "@@ -%s +%s @@\n", hunkRange(hunk[0].from, fromCount), hunkRange(hunk[0].to, toCount))
+	for _, line := range hunk {
+		output.WriteByte(line.op)
+		output.WriteString(line.text)
+		output.WriteByte('\n')
+	}
+}
+
+// hunkRange is the start,count of a hunk side, an empty side starts at the
+// line before it
+func hunkRange(before int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines turns from into to through the longest common subsequence of
+// their lines, removals before additions where both are possible
+func diffLines(from []string, to []string) []diffLine {
+	common := make([][]int, len(from)+1)
+	for i := range common {
+		common[i] = make([]int, len(to)+1)
+	}
+	for i := len(from) - 1; i >= 0; i-- {
+		for j := len(to) - 1; j >= 0; j-- {
+			if from[i] == to[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+
+	lines := make([]diffLine, 0, len(from)+len(to))
+	i, j := 0, 0
+	for i < len(from) || j < len(to) {
+		switch {
+		case i < len(from) && j < len(to) && from[i] == to[j]:
+			lines = append(lines, diffLine{' ', from[i], i, j})
+			i++
+			j++
+		case j == len(to) || (i < len(from) && common[i+1][j] >= common[i][j+1]):
+			lines = append(lines, diffLine{'-', from[i], i, j})
+			i++
+		default:
+			lines = append(lines, diffLine{'+', to[j], i, j})
+			j++
+		}
+	}
+	return lines
+}
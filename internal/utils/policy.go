@@ -0,0 +1,161 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NamePolicy decides which usernames, folder names, file names, tags and
+// attribute keys are accepted and how their length is measured
+type NamePolicy struct {
+	// ASCII restricts letters and digits to a-z, A-Z and 0-9
+	ASCII bool
+	// Punctuation lists the characters allowed besides letters and digits
+	Punctuation string
+	// Forbidden lists characters rejected even when they would be allowed
+	Forbidden string
+	// Reserved names can't be used at all, compared after normalization and
+	// ignoring case
+	Reserved []string
+	// CountBytes measures lengths in UTF-8 bytes instead of runes
+	CountBytes bool
+}
+
+var (
+	// UnicodePolicy accepts letters and digits of any script
+	UnicodePolicy = NamePolicy{Punctuation: "._-", Reserved: []string{".", ".."}}
+	// ASCIIPolicy only accepts the characters names were historically limited to
+	ASCIIPolicy = NamePolicy{ASCII: true, Punctuation: "._-", Reserved: []string{".", ".."}, CountBytes: true}
+
+	// NamePolicies are the policies a deployment can select by name
+	NamePolicies = map[string]NamePolicy{"unicode": UnicodePolicy, "ascii": ASCIIPolicy}
+
+	// Policy is the policy in effect
+	Policy = UnicodePolicy
+)
+
+// SetNamePolicy selects one of NamePolicies
+func SetNamePolicy(name string) error {
+	policy, err := LookupNamePolicy(name)
+	if err != nil {
+		return err
+	}
+	Policy = policy
+	return nil
+}
+
+// LookupNamePolicy returns one of NamePolicies without selecting it
+func LookupNamePolicy(name string) (NamePolicy, error) {
+	policy, exists := NamePolicies[strings.ToLower(name)]
+	if !exists {
+		return policy, fmt.Errorf("the %s is not a name policy, use unicode or ascii", name)
+	}
+	return policy, nil
+}
+
+// NameLengthUnits are the units a deployment can measure name lengths in
+var NameLengthUnits = []string{"runes", "bytes"}
+
+// Customize returns p with the characters in forbidden and the reserved
+// names added, measuring lengths in unit, runes or bytes, empty keeps the
+// unit of p
+func (p NamePolicy) Customize(forbidden string, reserved []string, unit string) (NamePolicy, error) {
+	switch strings.ToLower(unit) {
+	case "":
+	case "runes":
+		p.CountBytes = false
+	case "bytes":
+		p.CountBytes = true
+	default:
+		return p, fmt.Errorf("the %s is not a name length unit, use runes or bytes", unit)
+	}
+
+	p.Forbidden += forbidden
+	// Copied so the presets never share their reserved names with a deployment
+	p.Reserved = append(append([]string(nil), p.Reserved...), reserved...)
+	for i, name := range p.Reserved {
+		p.Reserved[i] = norm.NFC.String(name)
+	}
+	return p, nil
+}
+
+// Normalize returns the name in NFC form, or an error when the policy rejects
+// it. A name wrapped in quotes may contain spaces between them
+func (p NamePolicy) Normalize(name string) (string, error) {
+	name = norm.NFC.String(name)
+	invalid := fmt.Errorf("the %s contain invalid chars", name)
+
+	inner, quoted := name, false
+	if len(name) >= 2 && (name[0] == '"' || name[0] == '\'') && name[len(name)-1] == name[0] {
+		inner, quoted = name[1:len(name)-1], true
+	}
+	if inner == "" {
+		return "", invalid
+	}
+
+	for _, r := range inner {
+		if !p.allows(r, quoted) {
+			return "", invalid
+		}
+	}
+
+	for _, reserved := range p.Reserved {
+		// Lookups ignore case, so must reserved names
+		if strings.EqualFold(inner, reserved) {
+			return "", fmt.Errorf("the %s is a reserved name", name)
+		}
+	}
+
+	return name, nil
+}
+
+func (p NamePolicy) allows(r rune, quoted bool) bool {
+	if strings.ContainsRune(p.Forbidden, r) {
+		return false
+	}
+	if strings.ContainsRune(p.Punctuation, r) || (quoted && unicode.IsSpace(r)) {
+		return true
+	}
+	if p.ASCII {
+		return r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r))
+	}
+	// Marks are kept for scripts whose letters don't compose to a single rune
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// Length measures the name in runes or bytes depending on the policy
+func (p NamePolicy) Length(name string) int {
+	if p.CountBytes {
+		return len(name)
+	}
+	return utf8.RuneCountInString(name)
+}
+
+// Sanitize turns name into one the policy accepts by replacing every rejected
+// character with an underscore and cutting it to maxLength, reporting false
+// when nothing acceptable is left
+func (p NamePolicy) Sanitize(name string, maxLength int) (string, bool) {
+	var clean strings.Builder
+	for _, r := range norm.NFC.String(name) {
+		if p.allows(r, false) {
+			clean.WriteRune(r)
+		} else {
+			clean.WriteRune('_')
+		}
+	}
+
+	sanitized := clean.String()
+	for p.Length(sanitized) > maxLength {
+		_, size := utf8.DecodeLastRuneInString(sanitized)
+		sanitized = sanitized[:len(sanitized)-size]
+	}
+
+	if _, err := p.Normalize(sanitized); err != nil {
+		return "", false
+	}
+	return sanitized, true
+}
@@ -1,16 +1,13 @@
 package utils
 
 import (
+	"cmp"
 	"regexp"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-func ValidateString(str string) bool {
-	// Alphanumeric characters, dots, underscores, and hyphens, within 1-255 characters
-	regex := regexp.MustCompile(`^(['"][a-zA-Z0-9._\s-]{1,255}['"]|[a-zA-Z0-9._-]{1,255})$`)
-	return regex.MatchString(str)
-}
-
 func ParseInput(input string) []string {
 	// Nongreedy or sequence non-whitespace chars regex
 	regex := regexp.MustCompile(`["'](.*?)["']|\S+`)
@@ -38,3 +35,57 @@ func ParseInput(input string) []string {
 
 	return args
 }
+
+// NaturalCompare compares strings treating runs of digits as numbers, so
+// "file2" sorts before "file10". Letters compare case-insensitively, like
+// names are looked up, and the exact characters only break ties. It
+// returns -1, 0 or +1 like strings.Compare
+func NaturalCompare(a string, b string) int {
+	if c := naturalCompare(a, b, true); c != 0 {
+		return c
+	}
+	return naturalCompare(a, b, false)
+}
+
+func naturalCompare(a string, b string, fold bool) int {
+	for a != "" && b != "" {
+		aDigits := digitPrefix(a)
+		bDigits := digitPrefix(b)
+		if aDigits > 0 && bDigits > 0 {
+			aNumber := strings.TrimLeft(a[:aDigits], "0")
+			bNumber := strings.TrimLeft(b[:bDigits], "0")
+			if len(aNumber) != len(bNumber) {
+				return cmp.Compare(len(aNumber), len(bNumber))
+			}
+			if c := strings.Compare(aNumber, bNumber); c != 0 {
+				return c
+			}
+			a, b = a[aDigits:], b[bDigits:]
+			continue
+		}
+
+		aRune, aSize := utf8.DecodeRuneInString(a)
+		bRune, bSize := utf8.DecodeRuneInString(b)
+		if fold {
+			aRune, bRune = unicode.ToLower(aRune), unicode.ToLower(bRune)
+		}
+		if aRune != bRune {
+			return cmp.Compare(aRune, bRune)
+		}
+		if c := strings.Compare(a[:aSize], b[:bSize]); !fold && c != 0 {
+			// Invalid bytes all decode to utf8.RuneError
+			return c
+		}
+		a, b = a[aSize:], b[bSize:]
+	}
+
+	return cmp.Compare(len(a), len(b))
+}
+
+func digitPrefix(str string) int {
+	i := 0
+	for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+		i++
+	}
+	return i
+}
@@ -0,0 +1,457 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"repl-cli-iscoollab/internal/user"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuses of a delivery
+const (
+	Pending   = "pending"
+	Delivered = "delivered"
+	Dead      = "dead"
+)
+
+// Headers sent with every delivery, the signature is the hex HMAC-SHA256 of
+// the body keyed by the secret of the hook, prefixed with "sha256="
+const (
+	EventHeader     = "X-VFS-Event"
+	DeliveryHeader  = "X-VFS-Delivery"
+	SignatureHeader = "X-VFS-Signature"
+)
+
+// Hook is a registered receiver, an empty Username or Folder matches every
+// user or folder
+type Hook struct {
+	ID        string
+	URL       string
+	Secret    string
+	Username  string
+	Folder    string
+	CreatedAt time.Time
+}
+
+// Scope is the user and folder the hook receives changes of, "*" for every user
+func (h Hook) Scope() string {
+	if h.Username == "" {
+		return "*"
+	}
+	if h.Folder == "" {
+		return h.Username
+	}
+	return h.Username + "/" + h.Folder
+}
+
+func (h Hook) matches(event user.Event) bool {
+	if h.Username != "" && !strings.EqualFold(h.Username, event.Username) {
+		return false
+	}
+	if h.Folder == "" || strings.EqualFold(h.Folder, event.Folder) {
+		return true
+	}
+	return event.Type == user.FolderRenamed && event.Before != nil && strings.EqualFold(h.Folder, event.Before.Name)
+}
+
+// Delivery is one event sent to one hook, Code is the last HTTP status
+// received and 0 when the receiver couldn't be reached
+type Delivery struct {
+	ID        string
+	Hook      string
+	Event     user.Event
+	Status    string
+	Attempts  int
+	Code      int
+	Error     string
+	UpdatedAt time.Time
+}
+
+// Payload is the JSON body posted to the receiver
+type Payload struct {
+	Delivery string     `json:"delivery"`
+	Hook     string     `json:"hook"`
+	Event    user.Event `json:"event"`
+}
+
+// Dispatcher posts the events published on user.Events to the matching
+// hooks. Failed attempts are retried after BaseDelay, doubling up to
+// MaxDelay, and a delivery still failing after MaxAttempts, or rejected
+// with a status retrying can't fix, becomes a dead letter
+type Dispatcher struct {
+	Client      *http.Client
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// MaxHistory bounds the delivered entries kept for inspection, pending
+	// deliveries and dead letters are always kept
+	MaxHistory int
+
+	mu           sync.Mutex
+	hooks        []*Hook
+	deliveries   []*Delivery
+	nextHook     int
+	nextDelivery int
+	unsubscribe  func()
+	inFlight     sync.WaitGroup
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		Client:      &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts: 5,
+		BaseDelay:   time.Second,
+		MaxDelay:    time.Minute,
+		MaxHistory:  100,
+	}
+}
+
+// Add registers a hook and starts listening for events
+func (d *Dispatcher) Add(url string, secret string, username string, folder string) Hook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.nextHook++
+	hook := &Hook{
+		ID:        fmt.Sprintf("wh%d", d.nextHook),
+		URL:       url,
+		Secret:    secret,
+		Username:  username,
+		Folder:    folder,
+		CreatedAt: user.Now(),
+	}
+	d.hooks = append(d.hooks, hook)
+
+	if d.unsubscribe == nil {
+		// Subscribing synchronously keeps events in order, every delivery
+		// then runs on a goroutine of its own so receivers never stall a change
+		d.unsubscribe = user.Events.Subscribe(d.handle)
+	}
+	return *hook
+}
+
+// Remove unregisters a hook, its pending deliveries still complete
+func (d *Dispatcher) Remove(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for i, hook := range d.hooks {
+		if hook.ID == id {
+			d.hooks = append(d.hooks[:i], d.hooks[i+1:]...)
+			if len(d.hooks) == 0 && d.unsubscribe != nil {
+				d.unsubscribe()
+				d.unsubscribe = nil
+			}
+			return nil
+		}
+	}
+	return user.Errorf(user.NotFound, "the webhook %s doesn't exist", id)
+}
+
+// Hooks returns the registered hooks in registration order
+func (d *Dispatcher) Hooks() []Hook {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	hooks := make([]Hook, 0, len(d.hooks))
+	for _, hook := range d.hooks {
+		hooks = append(hooks, *hook)
+	}
+	return hooks
+}
+
+// Deliveries returns the deliveries of the hook, or of every hook when
+// hookID is empty, oldest first, only the dead letters when dead is set
+func (d *Dispatcher) Deliveries(hookID string, dead bool) []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var deliveries []Delivery
+	for _, delivery := range d.deliveries {
+		if hookID != "" && delivery.Hook != hookID {
+			continue
+		}
+		if dead && delivery.Status != Dead {
+			continue
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries
+}
+
+// Redeliver sends a dead letter again with a fresh set of attempts
+func (d *Dispatcher) Redeliver(id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, delivery := range d.deliveries {
+		if delivery.ID != id {
+			continue
+		}
+		if delivery.Status != Dead {
+			return user.Errorf(user.Conflict, "the delivery %s is %s, only dead letters can be redelivered", id, delivery.Status)
+		}
+		hook := d.hook(delivery.Hook)
+		if hook == nil {
+			return user.Errorf(user.NotFound, "the webhook %s doesn't exist", delivery.Hook)
+		}
+
+		delivery.Status = Pending
+		delivery.Attempts = 0
+		d.start(*hook, delivery)
+		return nil
+	}
+	return user.Errorf(user.NotFound, "the delivery %s doesn't exist", id)
+}
+
+// Wait blocks until every started delivery is delivered or dead
+func (d *Dispatcher) Wait() {
+	d.inFlight.Wait()
+}
+
+// WaitTimeout is Wait giving up after timeout, it reports whether no
+// delivery is pending anymore. It polls rather than waiting on the started
+// deliveries, so no waiter outlives it
+func (d *Dispatcher) WaitTimeout(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for d.Pending() > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+// Pending counts the deliveries still being attempted
+func (d *Dispatcher) Pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pending := 0
+	for _, delivery := range d.deliveries {
+		if delivery.Status == Pending {
+			pending++
+		}
+	}
+	return pending
+}
+
+// State is what a dispatcher keeps across sessions: the hooks with their
+// secrets, and the deliveries not delivered yet or dead. The delivered
+// history only lives for the session
+type State struct {
+	Hooks        []Hook     `json:"hooks"`
+	Deliveries   []Delivery `json:"deliveries"`
+	NextHook     int        `json:"next_hook"`
+	NextDelivery int        `json:"next_delivery"`
+}
+
+// State returns the state to save
+func (d *Dispatcher) State() State {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	state := State{Hooks: []Hook{}, Deliveries: []Delivery{}, NextHook: d.nextHook, NextDelivery: d.nextDelivery}
+	for _, hook := range d.hooks {
+		state.Hooks = append(state.Hooks, *hook)
+	}
+	for _, delivery := range d.deliveries {
+		if delivery.Status != Delivered {
+			state.Deliveries = append(state.Deliveries, *delivery)
+		}
+	}
+	return state
+}
+
+// Restore replaces the hooks and deliveries with a saved state. Deliveries
+// that were still pending are attempted again from the first attempt
+func (d *Dispatcher) Restore(state State) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.hooks, d.deliveries = nil, nil
+	for _, hook := range state.Hooks {
+		d.hooks = append(d.hooks, &hook)
+	}
+	d.nextHook, d.nextDelivery = state.NextHook, state.NextDelivery
+
+	switch {
+	case len(d.hooks) > 0 && d.unsubscribe == nil:
+		d.unsubscribe = user.Events.Subscribe(d.handle)
+	case len(d.hooks) == 0 && d.unsubscribe != nil:
+		d.unsubscribe()
+		d.unsubscribe = nil
+	}
+
+	for _, saved := range state.Deliveries {
+		delivery := &saved
+		d.deliveries = append(d.deliveries, delivery)
+		if delivery.Status != Pending {
+			continue
+		}
+		if hook := d.hook(delivery.Hook); hook != nil {
+			delivery.Attempts = 0
+			d.start(*hook, delivery)
+		} else {
+			delivery.Status = Dead
+			delivery.Error = "the webhook was removed"
+		}
+	}
+}
+
+func (d *Dispatcher) hook(id string) *Hook {
+	for _, hook := range d.hooks {
+		if hook.ID == id {
+			return hook
+		}
+	}
+	return nil
+}
+
+func (d *Dispatcher) handle(event user.Event) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, hook := range d.hooks {
+		if !hook.matches(event) {
+			continue
+		}
+		// Keep following a folder across renames
+		if hook.Folder != "" && event.Type == user.FolderRenamed {
+			hook.Folder = event.Folder
+		}
+
+		d.nextDelivery++
+		delivery := &Delivery{
+			ID:        fmt.Sprintf("d%d", d.nextDelivery),
+			Hook:      hook.ID,
+			Event:     event,
+			Status:    Pending,
+			UpdatedAt: user.Now(),
+		}
+		d.deliveries = append(d.deliveries, delivery)
+		d.start(*hook, delivery)
+	}
+	d.trim()
+}
+
+// start sends the delivery in the background, d.mu must be held
+func (d *Dispatcher) start(hook Hook, delivery *Delivery) {
+	body, err := json.Marshal(Payload{Delivery: delivery.ID, Hook: hook.ID, Event: delivery.Event})
+	if err != nil {
+		delivery.Status = Dead
+		delivery.Error = err.Error()
+		return
+	}
+
+	d.inFlight.Add(1)
+	go func() {
+		defer d.inFlight.Done()
+		d.deliver(hook, delivery, body)
+	}()
+}
+
+func (d *Dispatcher) deliver(hook Hook, delivery *Delivery, body []byte) {
+	for attempt := 1; ; attempt++ {
+		code, err := d.post(hook, delivery, body)
+
+		d.mu.Lock()
+		delivery.Attempts = attempt
+		delivery.Code = code
+		delivery.UpdatedAt = user.Now()
+		delivery.Error = ""
+		if err == nil {
+			delivery.Status = Delivered
+			d.trim()
+			d.mu.Unlock()
+			return
+		}
+		delivery.Error = err.Error()
+		if !retryable(code) || attempt >= d.MaxAttempts {
+			delivery.Status = Dead
+			d.mu.Unlock()
+			return
+		}
+		d.mu.Unlock()
+
+		time.Sleep(Backoff(d.BaseDelay, d.MaxDelay, attempt))
+	}
+}
+
+func (d *Dispatcher) post(hook Hook, delivery *Delivery, body []byte) (int, error) {
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, string(delivery.Event.Type))
+	request.Header.Set(DeliveryHeader, delivery.ID)
+	request.Header.Set(SignatureHeader, Sign(hook.Secret, body))
+
+	response, err := d.Client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("the receiver answered %s", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// trim drops the oldest delivered entries beyond MaxHistory, d.mu must be held
+func (d *Dispatcher) trim() {
+	delivered := 0
+	for _, delivery := range d.deliveries {
+		if delivery.Status == Delivered {
+			delivered++
+		}
+	}
+
+	kept := d.deliveries[:0]
+	for _, delivery := range d.deliveries {
+		if delivery.Status == Delivered && delivered > d.MaxHistory {
+			delivered--
+			continue
+		}
+		kept = append(kept, delivery)
+	}
+	d.deliveries = kept
+}
+
+// retryable reports whether a later attempt may succeed, unreachable
+// receivers, throttling and server errors are worth retrying
+func retryable(code int) bool {
+	return code == 0 || code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500
+}
+
+// Backoff is the delay before the attempt following the given one
+func Backoff(base time.Duration, max time.Duration, attempt int) time.Duration {
+	delay := base
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	return min(delay, max)
+}
+
+// Sign is the signature header value of the body for the secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is the signature of body for the secret,
+// receivers written in Go can use it to authenticate deliveries
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
@@ -1,24 +1,83 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"repl-cli-iscoollab/cmd/commands"
+	"repl-cli-iscoollab/internal/config"
+	"repl-cli-iscoollab/internal/user"
 	"repl-cli-iscoollab/internal/utils"
 	"strings"
+	"time"
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run starts the REPL and returns the process exit status, which is the
+// status of the last command so scripts piping commands in can check it
+func run() int {
+	configPath := flag.String("config", "", "config file, defaults to "+config.DefaultPath())
+	output := flag.String("output", "plain", "output format: plain, json, csv or table, overrides the config")
+	namePolicy := flag.String("name-policy", "unicode", "which names are accepted: unicode or ascii, overrides the config")
+	flag.Parse()
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 2
+	}
+
+	// Flags given on the command line win over the config file
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "output":
+			cfg.Output = *output
+		case "name-policy":
+			cfg.NamePolicy = *namePolicy
+		}
+	})
+
+	if err := commands.ApplyConfig(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return 2
+	}
+
+	if cfg.DataFile != "" {
+		if err := user.Load(cfg.DataFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return 2
+		}
+	}
+
+	var history *os.File
+	if cfg.HistoryFile != "" {
+		history, err = os.OpenFile(cfg.HistoryFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			return 2
+		}
+		defer history.Close()
+	}
+
 	fmt.Print("\033[H\033[2J")
 	fmt.Println("Welcome to Virtual File System Management REPL")
 	fmt.Println("Type 'help' to see the list of commands")
 
-	reader := bufio.NewReader(os.Stdin)
+	status := 0
+	// Shared with the line editor of edit, so input piped in stays in order
+	reader := commands.Input
 	for {
-		fmt.Print("\n> ")
+		fmt.Print("\n" + cfg.Prompt)
 		command, err := reader.ReadString('\n')
-		if err != nil {
+		if err == io.EOF && command == "" {
+			fmt.Println()
+			return shutdown(cfg.DataFile, status)
+		}
+		if err != nil && err != io.EOF {
 			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
 			continue
 		}
@@ -26,104 +85,210 @@ func main() {
 		command = strings.TrimSpace(command)
 		// Parse input, accept extra spaces and quotes
 		args := utils.ParseInput(command)
+		if len(args) == 0 {
+			continue
+		}
 
-		switch args[0] {
-		case "register":
-			output, err := commands.Register(args[1:])
-			if err != nil {
-				if strings.Contains(err.Error(), "Usage: ") {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-				} else {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-				}
-			}
-
-			fmt.Print(output)
-		case "create-folder":
-			output, err := commands.CreateFolder(args[1:])
-			if err != nil {
-				if strings.Contains(err.Error(), "Usage: ") {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-				} else {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-				}
-			}
+		if history != nil {
+			fmt.Fprintln(history, commands.HistoryLine(command, args))
+		}
 
-			fmt.Print(output)
-		case "list-folders":
-			output, err := commands.ListFolders(args[1:])
-			if err != nil {
-				if strings.Contains(err.Error(), "Usage: ") {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-				} else {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-				}
-			}
+		// exit keeps the status of the command before it for scripts
+		if args[0] == "exit" {
+			commands.Exit()
+			return shutdown(cfg.DataFile, status)
+		}
 
-			fmt.Print(output)
-		case "delete-folder":
-			output, err := commands.DeleteFolder(args[1:])
-			if err != nil {
-				if strings.Contains(err.Error(), "Usage: ") {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-				} else {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-				}
-			}
+		// Background syncs wait until the command and the save are done
+		commands.LockSession()
+		status = user.ExitStatus(execute(args))
 
-			fmt.Print(output)
-		case "rename-folder":
-			output, err := commands.RenameFolder(args[1:])
-			if err != nil {
-				if strings.Contains(err.Error(), "Usage: ") {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-				} else {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-				}
+		if cfg.DataFile != "" {
+			if err := user.Save(cfg.DataFile); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %s\n", err)
 			}
+		}
+		commands.UnlockSession()
+	}
+}
 
-			fmt.Print(output)
+// shutdownTimeout bounds how long exit waits for webhook deliveries, a
+// dead receiver would otherwise hold it through every retry
+const shutdownTimeout = 5 * time.Second
 
-		case "create-file":
-			output, err := commands.CreateFile(args[1:])
-			if err != nil {
-				if strings.Contains(err.Error(), "Usage: ") {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-				} else {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-				}
+// shutdown gives the webhook deliveries in flight shutdownTimeout to finish
+// and saves the data file, deliveries still pending are saved with it and
+// resume on the next start
+func shutdown(dataFile string, status int) int {
+	if pending := commands.Webhooks.Pending(); pending > 0 {
+		fmt.Printf("Waiting up to %s for %d webhook deliveries to finish\n", shutdownTimeout, pending)
+		if !commands.Webhooks.WaitTimeout(shutdownTimeout) {
+			next := "they resume on the next start"
+			if dataFile == "" {
+				next = "they are dropped without a data_file"
 			}
+			fmt.Printf("%d webhook deliveries are still pending, %s\n", commands.Webhooks.Pending(), next)
+		}
+	}
 
-			fmt.Print(output)
-		case "list-files":
-			output, err := commands.ListFiles(args[1:])
-			if err != nil {
-				if strings.Contains(err.Error(), "Usage: ") {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-				} else {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-				}
-			}
+	if dataFile != "" {
+		commands.LockSession()
+		defer commands.UnlockSession()
+		if err := user.Save(dataFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		}
+	}
+	return status
+}
 
-			fmt.Print(output)
-		case "delete-file":
-			output, err := commands.DeleteFile(args[1:])
-			if err != nil {
-				if strings.Contains(err.Error(), "Usage: ") {
-					fmt.Fprintf(os.Stderr, "%s\n", err)
-				} else {
-					fmt.Fprintf(os.Stderr, "Error: %s\n", err)
-				}
-			}
+// loadConfig reads the file given with --config, or the one at the default
+// location when it exists
+func loadConfig(path string) (config.Config, error) {
+	if path != "" {
+		return config.Load(path, true)
+	}
+	return config.Load(config.DefaultPath(), false)
+}
 
-			fmt.Print(output)
-		case "help":
-			output := commands.Help()
-			fmt.Print(output)
-		case "exit":
-			commands.Exit()
-		default:
-			fmt.Fprintf(os.Stderr, "Error: Unrecognized command\n")
+// execute runs a single parsed command line, prints its result and returns
+// the error it failed with, a trailing --output flag overrides the session
+// format for this command only
+func execute(args []string) error {
+	args, format, err := commands.ExtractOutputFlag(args)
+	if err != nil {
+		fmt.Fprint(os.Stderr, commands.RenderError(err))
+		return err
+	}
+	if format != "" {
+		previous := commands.Output
+		if err := commands.SetOutputFormat(format); err != nil {
+			fmt.Fprint(os.Stderr, commands.RenderError(err))
+			return err
 		}
+		defer func() { commands.Output = previous }()
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	// Snapshots are read-only, a mutating command aimed at one never runs
+	var output string
+	err = commands.ReadOnly(args[0], args[1:])
+	if err == nil {
+		output, err = dispatch(args)
+	}
+
+	if err != nil {
+		fmt.Fprint(os.Stderr, commands.RenderError(err))
+	}
+	if auditErr := commands.Record(args[0], args[1:], err); auditErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: the audit log can't be written: %s\n", auditErr)
+	}
+
+	fmt.Print(output)
+	return err
+}
+
+// dispatch runs the command named by the first argument
+func dispatch(args []string) (string, error) {
+	switch args[0] {
+	case "register":
+		return commands.Register(args[1:])
+	case "create-folder":
+		return commands.CreateFolder(args[1:])
+	case "list-folders":
+		return commands.ListFolders(args[1:])
+	case "delete-folder":
+		return commands.DeleteFolder(args[1:])
+	case "rename-folder":
+		return commands.RenameFolder(args[1:])
+	case "create-file":
+		return commands.CreateFile(args[1:])
+	case "list-files":
+		return commands.ListFiles(args[1:])
+	case "delete-file":
+		return commands.DeleteFile(args[1:])
+	case "write-file":
+		return commands.WriteFile(args[1:])
+	case "cat":
+		return commands.Cat(args[1:])
+	case "snapshot":
+		return commands.Snapshot(args[1:])
+	case "import-dir":
+		return commands.ImportDir(args[1:])
+	case "export":
+		return commands.Export(args[1:])
+	case "copy-file":
+		return commands.CopyFile(args[1:])
+	case "copy-folder":
+		return commands.CopyFolder(args[1:])
+	case "edit":
+		return commands.Edit(args[1:])
+	case "stat":
+		return commands.Stat(args[1:])
+	case "gc":
+		return commands.GC(args[1:])
+	case "sync":
+		return commands.Sync(args[1:])
+	case "unsync":
+		return commands.Unsync(args[1:])
+	case "file-history":
+		return commands.FileHistory(args[1:])
+	case "diff-file":
+		return commands.DiffFile(args[1:])
+	case "revert-file":
+		return commands.RevertFile(args[1:])
+	case "set-retention":
+		return commands.SetRetention(args[1:])
+	case "find":
+		return commands.Find(args[1:])
+	case "tree":
+		return commands.Tree(args[1:])
+	case "search":
+		return commands.Search(args[1:])
+	case "reindex":
+		return commands.Reindex(args[1:])
+	case "set-description":
+		return commands.SetDescription(args[1:])
+	case "clear-description":
+		return commands.ClearDescription(args[1:])
+	case "set-attr":
+		return commands.SetAttr(args[1:])
+	case "get-attr":
+		return commands.GetAttr(args[1:])
+	case "list-attrs":
+		return commands.ListAttrs(args[1:])
+	case "unset-attr":
+		return commands.UnsetAttr(args[1:])
+	case "tag":
+		return commands.Tag(args[1:])
+	case "untag":
+		return commands.Untag(args[1:])
+	case "list-tags":
+		return commands.ListTags(args[1:])
+	case "set":
+		return commands.Set(args[1:])
+	case "config":
+		return commands.Config(args[1:])
+	case "audit":
+		return commands.Audit(args[1:])
+	case "watch":
+		return commands.Watch(args[1:])
+	case "unwatch":
+		return commands.Unwatch(args[1:])
+	case "add-webhook":
+		return commands.AddWebhook(args[1:])
+	case "list-webhooks":
+		return commands.ListWebhooks(args[1:])
+	case "remove-webhook":
+		return commands.RemoveWebhook(args[1:])
+	case "webhook-deliveries":
+		return commands.WebhookDeliveries(args[1:])
+	case "redeliver-webhook":
+		return commands.RedeliverWebhook(args[1:])
+	case "help":
+		return commands.Help(), nil
+	default:
+		return "", user.Errorf(user.UsageError, "Unrecognized command")
 	}
 }